@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Symlink modes select how discovery treats symbolic links.
+const (
+	// symlinksSkip ignores every symbolic link.
+	symlinksSkip = "skip"
+	// symlinksFiles processes links to PDF files but does not descend into
+	// linked directories.
+	symlinksFiles = "files"
+	// symlinksFollow also descends into linked directories, visiting each
+	// real directory once.
+	symlinksFollow = "follow"
+)
+
+// croppedPrefix marks the outputs of the default naming template, which are
+// never taken as inputs.
+const croppedPrefix = "cropped_"
+
+// discoverOptions controls which files discoverInputs returns.
+type discoverOptions struct {
+	recursive bool
+	include   []string
+	exclude   []string
+	symlinks  string
+	// skipDir is not descended into, so that outputs written below the
+	// input directory are not picked up again.
+	skipDir string
+}
+
+// inputFile is a PDF found by discoverInputs.
+type inputFile struct {
+	path string // path to open
+	rel  string // path relative to the root, used to mirror the tree
+}
+
+// validGlob reports whether pattern is a well-formed glob.
+func validGlob(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// matchGlob reports whether any pattern matches either the slash-separated
+// relative path or the base name of rel.
+func matchGlob(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isPDF(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".pdf")
+}
+
+// discoverInputs returns the PDFs below root in lexical order. Unreadable
+// subdirectories and broken links are reported as warnings and skipped; only
+// failing to read root itself is an error.
+func discoverInputs(root string, opts discoverOptions) ([]inputFile, []error, error) {
+	var files []inputFile
+	var warnings []error
+	visited := map[string]bool{}
+
+	var walk func(dir, rel string) error
+	walk = func(dir, rel string) error {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			if visited[real] {
+				return nil
+			}
+			visited[real] = true
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			entryPath := filepath.Join(dir, entry.Name())
+			entryRel := filepath.Join(rel, entry.Name())
+
+			isLink := entry.Type()&os.ModeSymlink != 0
+			if isLink && opts.symlinks == symlinksSkip {
+				continue
+			}
+			info, err := os.Stat(entryPath)
+			if err != nil {
+				warnings = append(warnings, err)
+				continue
+			}
+
+			if info.IsDir() {
+				if !opts.recursive || (isLink && opts.symlinks != symlinksFollow) {
+					continue
+				}
+				if matchGlob(opts.exclude, entryRel) || (opts.skipDir != "" && sameDir(entryPath, opts.skipDir)) {
+					continue
+				}
+				if err := walk(entryPath, entryRel); err != nil {
+					warnings = append(warnings, err)
+				}
+				continue
+			}
+
+			if !info.Mode().IsRegular() || !isPDF(entry.Name()) || strings.HasPrefix(entry.Name(), croppedPrefix) {
+				continue
+			}
+			if len(opts.include) > 0 && !matchGlob(opts.include, entryRel) {
+				continue
+			}
+			if matchGlob(opts.exclude, entryRel) {
+				continue
+			}
+			files = append(files, inputFile{path: entryPath, rel: entryRel})
+		}
+		return nil
+	}
+
+	if err := walk(root, ""); err != nil {
+		return nil, nil, err
+	}
+	return files, warnings, nil
+}
+
+// sameDir reports whether a and b are the same existing directory.
+func sameDir(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// mirrorDir returns the output directory for file: outDir extended by the
+// file's directory relative to the root, or "" to keep the file's own
+// directory when outDir is not set.
+func mirrorDir(outDir string, file inputFile) string {
+	if outDir == "" {
+		return ""
+	}
+	return filepath.Join(outDir, filepath.Dir(file.rel))
+}
+
+// job is an input together with the output it is cropped to.
+type job struct {
+	input  inputFile
+	output string
+}
+
+// dropOutputs splits jobs into those to run and those whose input is the
+// output of another job, which happens when a custom template writes next
+// to the inputs.
+func dropOutputs(jobs []job) (kept, dropped []job) {
+	planned := make(map[string]bool, len(jobs))
+	for _, j := range jobs {
+		if abs, err := filepath.Abs(j.output); err == nil {
+			planned[abs] = true
+		}
+	}
+	for _, j := range jobs {
+		abs, err := filepath.Abs(j.input.path)
+		if err == nil && planned[abs] && j.input.path != j.output {
+			dropped = append(dropped, j)
+			continue
+		}
+		kept = append(kept, j)
+	}
+	return kept, dropped
+}
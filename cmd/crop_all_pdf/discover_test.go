@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// makeTree creates the given files, empty, below root.
+func makeTree(t *testing.T, root string, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		p := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func discoveredRels(t *testing.T, root string, opts discoverOptions) []string {
+	t.Helper()
+	files, warnings, err := discoverInputs(root, opts)
+	if err != nil {
+		t.Fatalf("discoverInputs: %v", err)
+	}
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	rels := make([]string, 0, len(files))
+	for _, f := range files {
+		rels = append(rels, filepath.ToSlash(f.rel))
+	}
+	return rels
+}
+
+func TestDiscoverInputs(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root,
+		"a.pdf", "B.Pdf", "cropped_a.pdf", "notes.txt",
+		"sub/c.PDF", "sub/draft/d.pdf", "out/cropped_x.pdf", "out/e.pdf",
+	)
+
+	tests := []struct {
+		name     string
+		opts     discoverOptions
+		expected []string
+	}{
+		{
+			name:     "Top level, any extension case",
+			opts:     discoverOptions{symlinks: symlinksFiles},
+			expected: []string{"B.Pdf", "a.pdf"},
+		},
+		{
+			name:     "Recursive",
+			opts:     discoverOptions{recursive: true, symlinks: symlinksFiles},
+			expected: []string{"B.Pdf", "a.pdf", "out/e.pdf", "sub/c.PDF", "sub/draft/d.pdf"},
+		},
+		{
+			name:     "Recursive skipping the output dir",
+			opts:     discoverOptions{recursive: true, symlinks: symlinksFiles, skipDir: filepath.Join(root, "out")},
+			expected: []string{"B.Pdf", "a.pdf", "sub/c.PDF", "sub/draft/d.pdf"},
+		},
+		{
+			name:     "Exclude prunes directories",
+			opts:     discoverOptions{recursive: true, symlinks: symlinksFiles, exclude: []string{"draft", "out"}},
+			expected: []string{"B.Pdf", "a.pdf", "sub/c.PDF"},
+		},
+		{
+			name:     "Include by relative path",
+			opts:     discoverOptions{recursive: true, symlinks: symlinksFiles, include: []string{"sub/*"}},
+			expected: []string{"sub/c.PDF"},
+		},
+		{
+			name:     "Include by base name",
+			opts:     discoverOptions{recursive: true, symlinks: symlinksFiles, include: []string{"[a-d].*"}},
+			expected: []string{"a.pdf", "sub/c.PDF", "sub/draft/d.pdf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := discoveredRels(t, root, tt.opts)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiscoverInputs_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	makeTree(t, root, "a.pdf")
+	makeTree(t, other, "linked/f.pdf", "g.pdf")
+	if err := os.Symlink(filepath.Join(other, "g.pdf"), filepath.Join(root, "g.pdf")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(other, "linked"), filepath.Join(root, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	// A loop back to the root must not be followed forever.
+	if err := os.Symlink(root, filepath.Join(other, "linked", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode     string
+		expected []string
+	}{
+		{symlinksSkip, []string{"a.pdf"}},
+		{symlinksFiles, []string{"a.pdf", "g.pdf"}},
+		{symlinksFollow, []string{"a.pdf", "g.pdf", "linked/f.pdf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got := discoveredRels(t, root, discoverOptions{recursive: true, symlinks: tt.mode})
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiscoverInputs_MissingRoot(t *testing.T) {
+	if _, _, err := discoverInputs(filepath.Join(t.TempDir(), "missing"), discoverOptions{}); err == nil {
+		t.Fatalf("expected error for missing root")
+	}
+}
+
+func TestMirrorDir(t *testing.T) {
+	file := inputFile{path: "/in/sub/x.pdf", rel: filepath.Join("sub", "x.pdf")}
+	if got := mirrorDir("", file); got != "" {
+		t.Errorf("mirrorDir without out dir = %q", got)
+	}
+	if got, want := mirrorDir("/out", file), filepath.Join("/out", "sub"); got != want {
+		t.Errorf("mirrorDir = %q, want %q", got, want)
+	}
+}
+
+func TestDropOutputs(t *testing.T) {
+	jobs := []job{
+		{input: inputFile{path: "/d/a.pdf"}, output: "/d/a.cropped.pdf"},
+		{input: inputFile{path: "/d/a.cropped.pdf"}, output: "/d/a.cropped.cropped.pdf"},
+		{input: inputFile{path: "/d/b.pdf"}, output: "/d/b.pdf"},
+	}
+	kept, dropped := dropOutputs(jobs)
+	if len(kept) != 2 || kept[0].input.path != "/d/a.pdf" || kept[1].input.path != "/d/b.pdf" {
+		t.Errorf("unexpected kept jobs: %+v", kept)
+	}
+	if len(dropped) != 1 || dropped[0].input.path != "/d/a.cropped.pdf" {
+		t.Errorf("unexpected dropped jobs: %+v", dropped)
+	}
+}
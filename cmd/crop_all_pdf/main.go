@@ -1,19 +1,72 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
+	"time"
 
+	"pdf-crop/internal/cli"
 	"pdf-crop/internal/crop"
+	"pdf-crop/internal/metrics"
 )
 
+var errHelp = errors.New("help requested")
+
+func printUsage() {
+	fmt.Print(cli.CropAllPdfUsage())
+}
+
 type args struct {
-	Dir       string
-	Threshold float64
-	Space     int
-	DPI       float64
+	Dir          string
+	Threshold    float64
+	Space        int
+	DPI          float64
+	Center       string
+	CropFrom     string
+	MinBlock     float64
+	DropHeads    bool
+	Deskew       string
+	RefineDPI    float64
+	CoarseDPI    float64
+	Template     string
+	OutDir       string
+	Overwrite    string
+	InPlace      bool
+	Recursive    bool
+	Include      []string
+	Exclude      []string
+	Symlinks     string
+	Force        bool
+	Manifest     string
+	NoState      bool
+	Watch        bool
+	Archive      string
+	Failed       string
+	Settle       time.Duration
+	Poll         time.Duration
+	NoNotify     bool
+	Metrics      string
+	Boxes        []string
+	Bleed        float64
+	HardCrop     bool
+	Strip        bool
+	Provenance   bool
+	Annots       crop.AnnotationPolicies
+	DetectAnnots bool
+	Password     string
+	OwnerPW      string
+	Encryption   string
+	NewPW        string
+	NewOwnerPW   string
+	LogLevel     slog.Level
+	LogFormat    string
 }
 
 func parseArgs(argv []string) (args, error) {
@@ -21,6 +74,10 @@ func parseArgs(argv []string) (args, error) {
 		Threshold: 0.1,
 		Space:     5,
 		DPI:       128,
+		Center:    crop.CenterMedian,
+		CropFrom:  "center",
+		Symlinks:  symlinksFiles,
+		LogFormat: cli.LogFormatText,
 	}
 	for i := 0; i < len(argv); i++ {
 		switch argv[i] {
@@ -60,56 +117,563 @@ func parseArgs(argv []string) (args, error) {
 			}
 			parsed.DPI = val
 			i++
+		case "--center":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !crop.ValidCenterMode(val) {
+				return parsed, fmt.Errorf("invalid --center: %s", val)
+			}
+			parsed.Center = val
+			i = next
+		case "--crop-from":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !crop.ValidCropFrom(val) {
+				return parsed, fmt.Errorf("invalid --crop-from: %s", val)
+			}
+			parsed.CropFrom = val
+			i = next
+		case "--min-block-area":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			area, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.MinBlock = area
+			i = next
+		case "--refine-dpi":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			dpi, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.RefineDPI = dpi
+			i = next
+		case "--coarse-dpi":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			dpi, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.CoarseDPI = dpi
+			i = next
+		case "--output-template":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if err := crop.ValidTemplate(val, false); err != nil {
+				return parsed, fmt.Errorf("invalid --output-template: %w", err)
+			}
+			parsed.Template = val
+			i = next
+		case "--out-dir":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OutDir = val
+			i = next
+		case "--boxes":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			boxes, err := crop.ParseBoxes(val)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --boxes: %w", err)
+			}
+			parsed.Boxes = boxes
+			i = next
+		case "--bleed":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			bleed, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if bleed < 0 {
+				return parsed, fmt.Errorf("invalid --bleed: %s", val)
+			}
+			parsed.Bleed = bleed
+			i = next
+		case "--provenance":
+			parsed.Provenance = true
+		case "--password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Password = val
+			i = next
+		case "--owner-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OwnerPW = val
+			i = next
+		case "--password-file":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if parsed.Password, parsed.OwnerPW, err = cli.ReadPasswordFile(val); err != nil {
+				return parsed, err
+			}
+			i = next
+		case "--encryption":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if val == "" || !crop.ValidEncryption(val) {
+				return parsed, fmt.Errorf("invalid --encryption: %s", val)
+			}
+			parsed.Encryption = val
+			i = next
+		case "--new-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.NewPW = val
+			i = next
+		case "--new-owner-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.NewOwnerPW = val
+			i = next
+		case "--annotations":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			policies, err := crop.ParseAnnotationPolicies(val)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --annotations: %w", err)
+			}
+			parsed.Annots = policies
+			i = next
+		case "--detect-annotations":
+			parsed.DetectAnnots = true
+		case "--hard-crop":
+			parsed.HardCrop = true
+		case "--strip-hidden":
+			parsed.Strip = true
+		case "--deskew":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if val == "" || !crop.ValidDeskew(val) {
+				return parsed, fmt.Errorf("invalid --deskew: %s", val)
+			}
+			parsed.Deskew = val
+			i = next
+		case "--overwrite":
+			parsed.Overwrite = crop.OverwriteReplace
+		case "--no-clobber":
+			parsed.Overwrite = crop.OverwriteNever
+		case "--backup":
+			parsed.Overwrite = crop.OverwriteBackup
+		case "--in-place":
+			parsed.InPlace = true
+		case "-r", "--recursive":
+			parsed.Recursive = true
+		case "--include", "--exclude":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !validGlob(val) {
+				return parsed, fmt.Errorf("invalid %s pattern: %s", argv[i], val)
+			}
+			if argv[i] == "--include" {
+				parsed.Include = append(parsed.Include, val)
+			} else {
+				parsed.Exclude = append(parsed.Exclude, val)
+			}
+			i = next
+		case "--symlinks":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			switch val {
+			case symlinksSkip, symlinksFiles, symlinksFollow:
+			default:
+				return parsed, fmt.Errorf("invalid --symlinks: %s", val)
+			}
+			parsed.Symlinks = val
+			i = next
+		case "--force":
+			parsed.Force = true
+		case "--manifest":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Manifest = val
+			i = next
+		case "--no-manifest":
+			parsed.NoState = true
+		case "--watch":
+			parsed.Watch = true
+		case "--archive-dir", "--error-dir":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if argv[i] == "--archive-dir" {
+				parsed.Archive = val
+			} else {
+				parsed.Failed = val
+			}
+			i = next
+		case "--settle", "--poll":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return parsed, fmt.Errorf("invalid %s: %s", argv[i], val)
+			}
+			if argv[i] == "--settle" {
+				parsed.Settle = d
+			} else {
+				parsed.Poll = d
+			}
+			i = next
+		case "--no-notify":
+			parsed.NoNotify = true
+		case "--metrics-addr":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Metrics = val
+			i = next
+		case "--drop-headers":
+			parsed.DropHeads = true
+		case "--keep-headers":
+			parsed.DropHeads = false
+		case "--log-level":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			level, err := cli.ParseLogLevel(val)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.LogLevel = level
+			i = next
+		case "--log-format":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !cli.ValidLogFormat(val) {
+				return parsed, fmt.Errorf("invalid --log-format: %s", val)
+			}
+			parsed.LogFormat = val
+			i = next
+		case "-h", "--help":
+			return parsed, errHelp
 		default:
 			return parsed, fmt.Errorf("unknown argument: %s", argv[i])
 		}
 	}
+	if parsed.InPlace && (parsed.Template != "" || parsed.OutDir != "") {
+		return parsed, fmt.Errorf("--in-place cannot be combined with --output-template or --out-dir")
+	}
+	if parsed.Watch && (parsed.InPlace || parsed.Recursive) {
+		return parsed, fmt.Errorf("--watch cannot be combined with --in-place or --recursive")
+	}
+	if parsed.Strip && !parsed.HardCrop {
+		return parsed, fmt.Errorf("--strip-hidden requires --hard-crop")
+	}
+	if parsed.Metrics != "" && !parsed.Watch {
+		return parsed, fmt.Errorf("--metrics-addr requires --watch")
+	}
+	if (parsed.NewPW != "" || parsed.NewOwnerPW != "") && parsed.Encryption != crop.EncryptionEncrypt {
+		return parsed, fmt.Errorf("--new-password and --new-owner-password require --encryption encrypt")
+	}
 	return parsed, nil
 }
 
 func main() {
 	parsed, err := parseArgs(os.Args[1:])
+	if errors.Is(err, errHelp) {
+		printUsage()
+		return
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	logger := cli.NewLogger(os.Stderr, parsed.LogLevel, parsed.LogFormat)
 
 	if parsed.Dir == "" {
 		cwd, err := os.Getwd()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+			logger.Error("working directory", "err", err)
 			os.Exit(1)
 		}
 		parsed.Dir = cwd
 	}
 
-	entries, err := os.ReadDir(parsed.Dir)
+	options := crop.Options{
+		Logger:            logger,
+		DPI:               parsed.DPI,
+		Threshold:         parsed.Threshold,
+		Space:             parsed.Space,
+		CropFrom:          parsed.CropFrom,
+		CenterMode:        parsed.Center,
+		MinBlockArea:      parsed.MinBlock,
+		DropHeaders:       parsed.DropHeads,
+		Deskew:            parsed.Deskew,
+		RefineDPI:         parsed.RefineDPI,
+		CoarseDPI:         parsed.CoarseDPI,
+		Boxes:             parsed.Boxes,
+		Bleed:             parsed.Bleed,
+		HardCrop:          parsed.HardCrop,
+		StripHidden:       parsed.Strip,
+		Provenance:        parsed.Provenance,
+		Annotations:       parsed.Annots,
+		DetectAnnotations: parsed.DetectAnnots,
+		Password:          parsed.Password,
+		OwnerPassword:     parsed.OwnerPW,
+		Encryption:        parsed.Encryption,
+		NewPassword:       parsed.NewPW,
+		NewOwnerPassword:  parsed.NewOwnerPW,
+		Overwrite:         parsed.Overwrite,
+		InPlace:           parsed.InPlace,
+	}
+	template := parsed.Template
+	if template == "" {
+		template = crop.DefaultDocumentTemplate
+	}
+	now := time.Now()
+
+	if parsed.Watch {
+		runWatch(parsed, options, template)
+		return
+	}
+
+	files, warnings, err := discoverInputs(parsed.Dir, discoverOptions{
+		recursive: parsed.Recursive,
+		include:   parsed.Include,
+		exclude:   parsed.Exclude,
+		symlinks:  parsed.Symlinks,
+		skipDir:   parsed.OutDir,
+	})
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.Error("discover inputs", "dir", parsed.Dir, "err", err)
 		os.Exit(1)
 	}
+	for _, warning := range warnings {
+		logger.Warn("discover inputs", "err", warning)
+	}
 
-	options := crop.Options{
-		DPI:       parsed.DPI,
-		Threshold: parsed.Threshold,
-		Space:     parsed.Space,
-		CropFrom:  "center",
+	var processed, skipped, failed int
+	jobs := make([]job, 0, len(files))
+	for _, file := range files {
+		output := file.path
+		if !parsed.InPlace {
+			output, err = crop.ExpandTemplate(template, file.path, mirrorDir(parsed.OutDir, file), -1, now)
+			if err != nil {
+				logger.Error("processing failed", "file", file.rel, "err", err)
+				failed++
+				continue
+			}
+		}
+		jobs = append(jobs, job{input: file, output: output})
+	}
+	jobs, dropped := dropOutputs(jobs)
+	for _, j := range dropped {
+		fmt.Printf("Skipping %s: it is the output of another input\n", j.input.rel)
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
+	manifestPath := statePath(parsed)
+	var state *manifest
+	if manifestPath != "" {
+		state, err = loadManifest(manifestPath)
+	} else {
+		state, err = newManifest()
+	}
+	if err != nil {
+		logger.Error("read manifest", "path", manifestPath, "err", err)
+		os.Exit(1)
+	}
+	optionsHash, err := hashOptions(options, state.Key)
+	if err != nil {
+		logger.Error("hash options", "err", err)
+		os.Exit(1)
+	}
+
+	for _, j := range jobs {
+		inputPath, outputPath := j.input.path, j.output
+		key := filepath.ToSlash(j.input.rel)
+		inputHash, err := hashFile(inputPath)
+		if err != nil {
+			logger.Error("processing failed", "file", j.input.rel, "err", err)
+			failed++
 			continue
 		}
-		if filepath.Ext(entry.Name()) != ".pdf" && filepath.Ext(entry.Name()) != ".PDF" {
+		entry := manifestEntry{
+			InputHash:   inputHash,
+			OptionsHash: optionsHash,
+			Version:     crop.Version,
+			Output:      outputPath,
+		}
+		if !parsed.NoState && !parsed.Force && state.upToDate(key, entry) {
+			fmt.Printf("Skipping unchanged: %s\n", j.input.rel)
+			skipped++
 			continue
 		}
-		inputPath := filepath.Join(parsed.Dir, entry.Name())
-		outputPath := filepath.Join(parsed.Dir, "cropped_"+entry.Name())
+
 		fmt.Printf("Processing: %s -> %s\n", inputPath, outputPath)
-		if _, err := crop.CropAllPagesToSingleFile(inputPath, outputPath, options); err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", entry.Name(), err)
+		results, err := crop.CropAllPagesToSingleFile(inputPath, outputPath, options)
+		if err != nil {
+			logger.Error("processing failed", "file", j.input.rel, "err", err)
+			failed++
 			continue
 		}
-		fmt.Printf("Successfully processed: %s\n", entry.Name())
+		printPageNotes(results, parsed.Deskew)
+		fmt.Printf("Successfully processed: %s\n", j.input.rel)
+		processed++
+
+		if parsed.NoState {
+			continue
+		}
+		// An in-place crop replaces the input, so the next run sees the
+		// cropped file.
+		if outputPath == inputPath {
+			if entry.InputHash, err = hashFile(outputPath); err != nil {
+				logger.Warn("hash output", "file", j.input.rel, "err", err)
+				continue
+			}
+		}
+		entry.ProcessedAt = time.Now()
+		state.Entries[key] = entry
+		if err := state.save(manifestPath); err != nil {
+			logger.Warn("write manifest", "path", manifestPath, "err", err)
+		}
+	}
+
+	fmt.Printf("Summary: %d processed, %d skipped, %d failed\n", processed, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// statePath returns the manifest file of parsed, or "" with --no-manifest.
+func statePath(parsed args) string {
+	if parsed.NoState {
+		return ""
+	}
+	if parsed.Manifest != "" {
+		return parsed.Manifest
+	}
+	return filepath.Join(parsed.Dir, manifestFile)
+}
+
+// printPageNotes prints the per-page skew, dropped bands, stripped content,
+// changed annotations and warnings of results.
+func printPageNotes(results []crop.PageResult, deskew string) {
+	for _, res := range results {
+		if deskew != "" {
+			fmt.Printf("  page %d: skew %.2f\n", res.PageNo, res.Skew)
+		}
+		for _, band := range res.Dropped {
+			fmt.Printf("  page %d: dropped %s %s\n", res.PageNo, band.Edge, crop.RectString(band.Rect))
+		}
+		if res.Stripped.Total() > 0 {
+			fmt.Printf("  page %d: stripped %s\n", res.PageNo, res.Stripped)
+		}
+		for _, annot := range res.Annotations {
+			fmt.Printf("  page %d: annotation %s %s %s\n", res.PageNo, annot.Action, annot.Subtype, crop.RectString(annot.Rect))
+		}
+		for _, warning := range res.Warnings {
+			fmt.Printf("  page %d: warning: %s\n", res.PageNo, warning)
+		}
+	}
+}
+
+// runWatch crops PDFs dropped into parsed.Dir until interrupted.
+func runWatch(parsed args, options crop.Options, template string) {
+	if parsed.Metrics != "" {
+		prom := metrics.NewPrometheus()
+		options.Metrics = prom
+		srv := prom.Serve(parsed.Metrics, func(err error) {
+			options.Logger.Warn("metrics server", "addr", parsed.Metrics, "err", err)
+		})
+		defer srv.Close()
+	}
+
+	archive := parsed.Archive
+	if archive == "" {
+		archive = filepath.Join(parsed.Dir, "archive")
+	}
+	failed := parsed.Failed
+	if failed == "" {
+		failed = filepath.Join(parsed.Dir, "error")
+	}
+
+	cfg := watchConfig{
+		dir:        parsed.Dir,
+		archiveDir: archive,
+		errorDir:   failed,
+		settle:     parsed.Settle,
+		poll:       parsed.Poll,
+		noNotify:   parsed.NoNotify,
+		manifest:   statePath(parsed),
+		options:    options,
+		version:    crop.Version,
+		discover: discoverOptions{
+			include:  parsed.Include,
+			exclude:  parsed.Exclude,
+			symlinks: parsed.Symlinks,
+			skipDir:  parsed.OutDir,
+		},
+		process: func(path string) (string, error) {
+			output, err := crop.ExpandTemplate(template, path, parsed.OutDir, -1, time.Now())
+			if err != nil {
+				return "", err
+			}
+			results, err := crop.CropAllPagesToSingleFile(path, output, options)
+			if err != nil {
+				return "", err
+			}
+			printPageNotes(results, parsed.Deskew)
+			return output, nil
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := newWatcher(cfg, os.Stdout).run(ctx); err != nil {
+		options.Logger.Error("watch", "dir", parsed.Dir, "err", err)
+		os.Exit(1)
 	}
 }
@@ -3,9 +3,13 @@ package main
 import (
 	"bytes"
 	"errors"
+	"log/slog"
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"pdf-crop/internal/crop"
 )
 
 func TestParseArgs_Help(t *testing.T) {
@@ -107,3 +111,230 @@ func TestParseArgs_MissingValueForSpace(t *testing.T) {
 		t.Fatalf("expected error for missing space value")
 	}
 }
+
+func TestParseArgs_Center(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--center", "profile"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Center != "profile" {
+		t.Fatalf("expected center profile, got %q", args.Center)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--center"}); err == nil {
+		t.Fatalf("expected error for missing center value")
+	}
+}
+
+func TestParseArgs_BlocksMode(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--crop-from", "blocks", "--min-block-area", "0.005"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.CropFrom != "blocks" || args.MinBlock != 0.005 {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+}
+
+func TestParseArgs_HeaderSwitches(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--drop-headers"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !args.DropHeads {
+		t.Fatalf("expected --drop-headers to enable header exclusion")
+	}
+	args, err = parseArgs([]string{"--dir", "/tmp", "--drop-headers", "--keep-headers"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.DropHeads {
+		t.Fatalf("expected the last header switch to win")
+	}
+}
+
+func TestParseArgs_Deskew(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--deskew", "correct"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Deskew != "correct" {
+		t.Fatalf("expected deskew correct, got %q", args.Deskew)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--deskew", "sideways"}); err == nil {
+		t.Fatalf("expected error for invalid deskew mode")
+	}
+}
+
+func TestParseArgs_RefineDPI(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--refine-dpi", "600"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.RefineDPI != 600 {
+		t.Fatalf("expected refine dpi 600, got %v", args.RefineDPI)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--refine-dpi", "fine"}); err == nil {
+		t.Fatalf("expected error for invalid refine dpi")
+	}
+}
+
+func TestParseArgs_CoarseDPI(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--dpi", "300", "--coarse-dpi", "50"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.CoarseDPI != 50 || args.DPI != 300 {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--coarse-dpi"}); err == nil {
+		t.Fatalf("expected error for missing coarse dpi value")
+	}
+}
+
+func TestParseArgs_OutputTemplate(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--output-template", "{name}.cropped.pdf", "--out-dir", "/out"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Template != "{name}.cropped.pdf" || args.OutDir != "/out" {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	// One output per document, so page placeholders are rejected.
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--output-template", "{name}_{page}.pdf"}); err == nil {
+		t.Fatalf("expected error for page placeholder")
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--out-dir"}); err == nil {
+		t.Fatalf("expected error for missing out dir value")
+	}
+}
+
+func TestParseArgs_WritePolicies(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--no-clobber"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Overwrite != crop.OverwriteNever {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	args, err = parseArgs([]string{"--dir", "/tmp", "--in-place", "--overwrite"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !args.InPlace || args.Overwrite != crop.OverwriteReplace {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--in-place", "--out-dir", "/out"}); err == nil {
+		t.Fatalf("expected error for --in-place with --out-dir")
+	}
+}
+
+func TestParseArgs_Discovery(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "-r", "--include", "*.pdf", "--exclude", "draft*", "--exclude", "old", "--symlinks", "follow"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !args.Recursive || len(args.Include) != 1 || len(args.Exclude) != 2 || args.Symlinks != symlinksFollow {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if args, _ := parseArgs([]string{"--dir", "/tmp"}); args.Symlinks != symlinksFiles {
+		t.Fatalf("expected default symlink mode %q, got %q", symlinksFiles, args.Symlinks)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--include", "[a-"}); err == nil {
+		t.Fatalf("expected error for malformed glob")
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--symlinks", "always"}); err == nil {
+		t.Fatalf("expected error for invalid symlink mode")
+	}
+}
+
+func TestParseArgs_Manifest(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--force", "--manifest", "/var/state.json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !args.Force || args.Manifest != "/var/state.json" || args.NoState {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	args, err = parseArgs([]string{"--dir", "/tmp", "--no-manifest"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !args.NoState {
+		t.Fatalf("expected --no-manifest to disable the state file")
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--manifest"}); err == nil {
+		t.Fatalf("expected error for missing manifest path")
+	}
+}
+
+func TestParseArgs_Watch(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--watch", "--archive-dir", "/a", "--error-dir", "/e", "--settle", "5s", "--poll", "500ms", "--no-notify"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !args.Watch || args.Archive != "/a" || args.Failed != "/e" || args.Settle != 5*time.Second || args.Poll != 500*time.Millisecond || !args.NoNotify {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	for _, argv := range [][]string{
+		{"--dir", "/tmp", "--settle", "soon"},
+		{"--dir", "/tmp", "--poll", "-1s"},
+		{"--dir", "/tmp", "--watch", "-r"},
+		{"--dir", "/tmp", "--watch", "--in-place"},
+	} {
+		if _, err := parseArgs(argv); err == nil {
+			t.Errorf("expected error for %v", argv)
+		}
+	}
+}
+
+func TestParseArgs_MetricsAddr(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--watch", "--metrics-addr", ":9100"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Metrics != ":9100" {
+		t.Fatalf("Metrics = %q", args.Metrics)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--metrics-addr", ":9100"}); err == nil {
+		t.Error("expected --metrics-addr without --watch to fail")
+	}
+}
+
+func TestParseArgs_Logging(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--log-level", "warn", "--log-format", "text"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.LogLevel != slog.LevelWarn || args.LogFormat != "text" {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--log-format", "yaml"}); err == nil {
+		t.Error("expected an invalid --log-format to fail")
+	}
+}
+
+func TestParseArgs_Passwords(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--password", "user", "--encryption", "decrypt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Password != "user" || args.Encryption != crop.EncryptionDecrypt {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--encryption", "decrypt", "--new-owner-password", "x"}); err == nil {
+		t.Error("expected --new-owner-password without --encryption encrypt to fail")
+	}
+}
+
+func TestParseArgs_Annotations(t *testing.T) {
+	args, err := parseArgs([]string{"--dir", "/tmp", "--annotations", "drop", "--detect-annotations"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Annots.Comments != crop.AnnotationsDrop || !args.DetectAnnots {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"--dir", "/tmp", "--annotations", "notes=drop"}); err == nil {
+		t.Error("expected an unknown annotation kind to fail")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pdf-crop/internal/crop"
+)
+
+// manifestFile is the default state file name, kept in --dir.
+const manifestFile = ".crop_all_pdf.json"
+
+// manifest records what previous runs produced so that unchanged inputs can
+// be skipped. Entries are keyed by the slash-separated input path relative
+// to --dir.
+type manifest struct {
+	// Key is a random key, created with the manifest, for the digest of the
+	// passwords that goes into the options hash. It is stored alongside the
+	// hashes, so it acts as a salt: it rules out precomputed lookups and
+	// matching passwords across manifests, not guessing by whoever can read
+	// this file.
+	Key     string                   `json:"key"`
+	Entries map[string]manifestEntry `json:"entries"`
+}
+
+type manifestEntry struct {
+	InputHash   string    `json:"input_hash"`
+	OptionsHash string    `json:"options_hash"`
+	Version     string    `json:"version"`
+	Output      string    `json:"output"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// newManifest returns an empty manifest with a fresh key.
+func newManifest() (*manifest, error) {
+	m := &manifest{Entries: map[string]manifestEntry{}}
+	return m, m.newKey()
+}
+
+func (m *manifest) newKey() error {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	m.Key = hex.EncodeToString(key)
+	return nil
+}
+
+// loadManifest reads the manifest at path. A missing file yields an empty
+// manifest.
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newManifest()
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Entries == nil {
+		m.Entries = map[string]manifestEntry{}
+	}
+	// Manifests written before the key existed get one now.
+	if m.Key == "" {
+		if err := m.newKey(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// save writes the manifest to path through a temporary file, so an
+// interrupted run keeps the previous state.
+func (m *manifest) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// upToDate reports whether key was last cropped from the same input with the
+// same options and tool version, and its output is still there.
+func (m *manifest) upToDate(key string, want manifestEntry) bool {
+	got, ok := m.Entries[key]
+	if !ok {
+		return false
+	}
+	if got.InputHash != want.InputHash || got.OptionsHash != want.OptionsHash ||
+		got.Version != want.Version || got.Output != want.Output {
+		return false
+	}
+	_, err := os.Stat(got.Output)
+	return err == nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashOptions returns the hex SHA-256 of the options that affect the
+// output. The write policies do not, so they are left out. The passwords
+// are never serialized; an HMAC of them under key stands in, so that
+// changing one re-crops the files. Since key is kept in the manifest, this
+// only prevents precomputed and cross-manifest lookups of the passwords.
+func hashOptions(opts crop.Options, key string) (string, error) {
+	opts.Overwrite = ""
+	opts.InPlace = false
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	// Without passwords the hash stays what it was before they existed.
+	if opts.Password != "" || opts.OwnerPassword != "" || opts.NewPassword != "" || opts.NewOwnerPassword != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		for _, pw := range []string{opts.Password, opts.OwnerPassword, opts.NewPassword, opts.NewOwnerPassword} {
+			// Length prefixes keep the boundaries between the fields.
+			fmt.Fprintf(mac, "%d:%s", len(pw), pw)
+		}
+		data = mac.Sum(data)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
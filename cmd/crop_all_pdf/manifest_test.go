@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pdf-crop/internal/crop"
+)
+
+func TestManifest_RoundTrip(t *testing.T) {
+	tdir := t.TempDir()
+	path := filepath.Join(tdir, manifestFile)
+
+	m, err := loadManifest(path)
+	if err != nil {
+		t.Fatalf("load missing manifest: %v", err)
+	}
+	if len(m.Entries) != 0 || m.Key == "" {
+		t.Fatalf("expected empty manifest with a key, got %+v", m)
+	}
+
+	m.Entries["sub/a.pdf"] = manifestEntry{InputHash: "in", OptionsHash: "opt", Version: "v1", Output: "out.pdf"}
+	if err := m.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := loadManifest(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := loaded.Entries["sub/a.pdf"]; got.InputHash != "in" || got.Output != "out.pdf" {
+		t.Fatalf("unexpected entry after round trip: %+v", got)
+	}
+	if loaded.Key != m.Key {
+		t.Fatalf("key changed after round trip: %q, want %q", loaded.Key, m.Key)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadManifest(path); err == nil {
+		t.Fatalf("expected error for corrupt manifest")
+	}
+}
+
+func TestManifest_UpToDate(t *testing.T) {
+	tdir := t.TempDir()
+	output := filepath.Join(tdir, "out.pdf")
+	if err := os.WriteFile(output, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	entry := manifestEntry{InputHash: "in", OptionsHash: "opt", Version: "v1", Output: output}
+	m := &manifest{Entries: map[string]manifestEntry{"a.pdf": entry}}
+
+	if !m.upToDate("a.pdf", entry) {
+		t.Fatalf("expected unchanged entry to be up to date")
+	}
+	if m.upToDate("b.pdf", entry) {
+		t.Fatalf("expected unknown input to be stale")
+	}
+	for name, change := range map[string]func(*manifestEntry){
+		"input":   func(e *manifestEntry) { e.InputHash = "changed" },
+		"options": func(e *manifestEntry) { e.OptionsHash = "changed" },
+		"version": func(e *manifestEntry) { e.Version = "v2" },
+		"output":  func(e *manifestEntry) { e.Output = filepath.Join(tdir, "other.pdf") },
+	} {
+		want := entry
+		change(&want)
+		if m.upToDate("a.pdf", want) {
+			t.Errorf("expected stale entry after %s change", name)
+		}
+	}
+
+	os.Remove(output)
+	if m.upToDate("a.pdf", entry) {
+		t.Fatalf("expected stale entry once the output is gone")
+	}
+}
+
+func TestHashOptions(t *testing.T) {
+	opts := crop.DefaultOptions()
+	base, err := hashOptions(opts, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := opts
+	policy.Overwrite = crop.OverwriteBackup
+	policy.InPlace = true
+	if got, _ := hashOptions(policy, "key"); got != base {
+		t.Errorf("write policies should not change the options hash")
+	}
+
+	changed := opts
+	changed.Space++
+	if got, _ := hashOptions(changed, "key"); got == base {
+		t.Errorf("expected a different hash when Space changes")
+	}
+	if got, _ := hashOptions(opts, "other key"); got != base {
+		t.Errorf("the key should only matter with passwords")
+	}
+
+	withPassword := opts
+	withPassword.Password = "secret"
+	first, _ := hashOptions(withPassword, "key")
+	if first == base {
+		t.Errorf("expected a different hash when a password is set")
+	}
+	withPassword.Password = "other"
+	if got, _ := hashOptions(withPassword, "key"); got == first {
+		t.Errorf("expected a different hash when the password changes")
+	}
+	if got, _ := hashOptions(withPassword, "other key"); got == first {
+		t.Errorf("expected a different hash under another key")
+	}
+	// The owner password does not run into the user password.
+	split := opts
+	split.Password, split.OwnerPassword = "ab", "c"
+	joined := opts
+	joined.Password, joined.OwnerPassword = "a", "bc"
+	a, _ := hashOptions(split, "key")
+	b, _ := hashOptions(joined, "key")
+	if a == b {
+		t.Errorf("expected the password boundaries to matter")
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("hashFile = %s, want %s", got, want)
+	}
+}
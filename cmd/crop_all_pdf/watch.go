@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"pdf-crop/internal/crop"
+)
+
+const (
+	// defaultSettle is how long a file's size and modification time must
+	// stay unchanged before it is considered completely written.
+	defaultSettle = 2 * time.Second
+	// defaultPoll is the rescan interval. It also paces the stability
+	// checks when fsnotify is available.
+	defaultPoll = time.Second
+)
+
+// watchConfig describes a hot folder.
+type watchConfig struct {
+	dir        string
+	archiveDir string
+	errorDir   string
+	settle     time.Duration
+	poll       time.Duration
+	// noNotify disables fsnotify and relies on polling alone.
+	noNotify bool
+	discover discoverOptions
+	// manifest is the state file shared with batch runs, or empty for none.
+	// Outputs it lists are never picked up as inputs, and each processed
+	// file is recorded in it.
+	manifest string
+	// The hash of options and version are recorded with each processed
+	// file.
+	options crop.Options
+	version string
+	// process crops one settled file and returns the output it wrote.
+	process func(path string) (string, error)
+}
+
+// pendingFile is a candidate that has not yet stayed unchanged for long
+// enough.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// watcher processes PDFs dropped into a directory.
+type watcher struct {
+	cfg      watchConfig
+	pending  map[string]pendingFile
+	produced map[string]bool
+	state    *manifest
+	// optionsHash is the hash of cfg.options under the key of state.
+	optionsHash string
+	log         io.Writer
+}
+
+func newWatcher(cfg watchConfig, log io.Writer) *watcher {
+	if cfg.settle <= 0 {
+		cfg.settle = defaultSettle
+	}
+	if cfg.poll <= 0 {
+		cfg.poll = defaultPoll
+	}
+	return &watcher{
+		cfg:      cfg,
+		pending:  map[string]pendingFile{},
+		produced: map[string]bool{},
+		log:      log,
+	}
+}
+
+// run watches until ctx is done. fsnotify events trigger an immediate scan;
+// without fsnotify the directory is polled every cfg.poll.
+func (w *watcher) run(ctx context.Context) error {
+	for _, dir := range []string{w.cfg.archiveDir, w.cfg.errorDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := w.loadState(); err != nil {
+		return err
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if !w.cfg.noNotify {
+		fw, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = fw.Add(w.cfg.dir)
+		}
+		if err != nil {
+			fmt.Fprintf(w.log, "Warning: file notifications unavailable, polling every %v: %v\n", w.cfg.poll, err)
+		} else {
+			defer fw.Close()
+			events, errs = fw.Events, fw.Errors
+		}
+	}
+
+	ticker := time.NewTicker(w.cfg.poll)
+	defer ticker.Stop()
+	fmt.Fprintf(w.log, "Watching %s\n", w.cfg.dir)
+	w.scan(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-events:
+			w.scan(time.Now())
+		case err := <-errs:
+			fmt.Fprintf(w.log, "Warning: watch: %v\n", err)
+		case now := <-ticker.C:
+			w.scan(now)
+		}
+	}
+}
+
+// loadState reads cfg.manifest and marks the outputs it lists as produced,
+// so that outputs of earlier runs are not cropped again after a restart.
+func (w *watcher) loadState() error {
+	if w.cfg.manifest == "" {
+		return nil
+	}
+	state, err := loadManifest(w.cfg.manifest)
+	if err != nil {
+		return fmt.Errorf("read manifest %s: %w", w.cfg.manifest, err)
+	}
+	if w.optionsHash, err = hashOptions(w.cfg.options, state.Key); err != nil {
+		return err
+	}
+	w.state = state
+	for _, entry := range state.Entries {
+		if entry.Output != "" {
+			w.produced[absPath(entry.Output)] = true
+		}
+	}
+	return nil
+}
+
+// record adds the processed file path and its output to the manifest.
+func (w *watcher) record(path, output string) {
+	if w.state == nil {
+		return
+	}
+	inputHash, err := hashFile(path)
+	if err != nil {
+		fmt.Fprintf(w.log, "Warning: %v\n", err)
+		return
+	}
+	key := path
+	if rel, err := filepath.Rel(w.cfg.dir, path); err == nil {
+		key = rel
+	}
+	w.state.Entries[filepath.ToSlash(key)] = manifestEntry{
+		InputHash:   inputHash,
+		OptionsHash: w.optionsHash,
+		Version:     w.cfg.version,
+		Output:      output,
+		ProcessedAt: time.Now(),
+	}
+	if err := w.state.save(w.cfg.manifest); err != nil {
+		fmt.Fprintf(w.log, "Warning: write manifest %s: %v\n", w.cfg.manifest, err)
+	}
+}
+
+// scan looks for candidates in the watched directory and processes those
+// whose size and modification time have not changed for cfg.settle.
+func (w *watcher) scan(now time.Time) {
+	files, warnings, err := discoverInputs(w.cfg.dir, w.cfg.discover)
+	if err != nil {
+		fmt.Fprintf(w.log, "Warning: scan %s: %v\n", w.cfg.dir, err)
+		return
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(w.log, "Warning: %v\n", warning)
+	}
+
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if w.produced[absPath(file.path)] {
+			continue
+		}
+		seen[file.path] = true
+		info, err := os.Stat(file.path)
+		if err != nil {
+			continue
+		}
+
+		p, ok := w.pending[file.path]
+		if !ok || p.size != info.Size() || !p.modTime.Equal(info.ModTime()) {
+			w.pending[file.path] = pendingFile{size: info.Size(), modTime: info.ModTime(), since: now}
+			continue
+		}
+		if now.Sub(p.since) < w.cfg.settle {
+			continue
+		}
+		delete(w.pending, file.path)
+		w.handle(file.path)
+	}
+	for path := range w.pending {
+		if !seen[path] {
+			delete(w.pending, path)
+		}
+	}
+}
+
+// handle processes one settled file and moves the original to the archive
+// or, on failure, to the error folder together with the error message.
+func (w *watcher) handle(path string) {
+	name := filepath.Base(path)
+	fmt.Fprintf(w.log, "Processing: %s\n", path)
+	output, err := w.cfg.process(path)
+	if err != nil {
+		fmt.Fprintf(w.log, "Error processing %s: %v\n", name, err)
+		dest, moveErr := moveUnique(path, w.cfg.errorDir)
+		if moveErr != nil {
+			fmt.Fprintf(w.log, "Warning: move %s to %s: %v\n", name, w.cfg.errorDir, moveErr)
+			return
+		}
+		note := strings.TrimSuffix(dest, filepath.Ext(dest)) + ".error.txt"
+		if writeErr := os.WriteFile(note, []byte(err.Error()+"\n"), 0644); writeErr != nil {
+			fmt.Fprintf(w.log, "Warning: %v\n", writeErr)
+		}
+		return
+	}
+
+	w.produced[absPath(output)] = true
+	w.record(path, output)
+	if _, err := moveUnique(path, w.cfg.archiveDir); err != nil {
+		fmt.Fprintf(w.log, "Warning: archive %s: %v\n", name, err)
+		return
+	}
+	fmt.Fprintf(w.log, "Successfully processed: %s -> %s\n", name, output)
+}
+
+// moveUnique moves path into dir, adding a numeric suffix instead of
+// replacing a file of the same name, and returns the new path. It falls
+// back to copying when dir is on another file system.
+func moveUnique(path, dir string) (string, error) {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(filepath.Base(path), ext)
+	dest := filepath.Join(dir, stem+ext)
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(dest); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		dest = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, n, ext))
+	}
+
+	if err := os.Rename(path, dest); err == nil {
+		return dest, nil
+	}
+	if err := copyFile(path, dest); err != nil {
+		return "", err
+	}
+	return dest, os.Remove(path)
+}
+
+// absPath returns the absolute form of path, or path itself if that fails.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
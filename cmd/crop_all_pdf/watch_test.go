@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWatcher returns a watcher on a fresh directory whose process
+// function records the files it is given and fails for names containing
+// "bad".
+func newTestWatcher(t *testing.T) (*watcher, *[]string) {
+	t.Helper()
+	dir := t.TempDir()
+	var processed []string
+	cfg := watchConfig{
+		dir:        dir,
+		archiveDir: filepath.Join(dir, "archive"),
+		errorDir:   filepath.Join(dir, "error"),
+		settle:     2 * time.Second,
+		discover:   discoverOptions{symlinks: symlinksFiles},
+		process: func(path string) (string, error) {
+			processed = append(processed, filepath.Base(path))
+			if strings.Contains(path, "bad") {
+				return "", errors.New("broken pdf")
+			}
+			output := filepath.Join(filepath.Dir(path), croppedPrefix+filepath.Base(path))
+			return output, os.WriteFile(output, []byte("cropped"), 0644)
+		},
+	}
+	for _, d := range []string{cfg.archiveDir, cfg.errorDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newWatcher(cfg, &bytes.Buffer{}), &processed
+}
+
+func TestWatcher_WaitsForStableSize(t *testing.T) {
+	w, processed := newTestWatcher(t)
+	path := filepath.Join(w.cfg.dir, "scan.pdf")
+	start := time.Now()
+
+	if err := os.WriteFile(path, []byte("part"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.scan(start)
+	w.scan(start.Add(time.Second))
+
+	// Still being written: the size changes and restarts the clock.
+	if err := os.WriteFile(path, []byte("partial content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.scan(start.Add(3 * time.Second))
+	w.scan(start.Add(4 * time.Second))
+	if len(*processed) != 0 {
+		t.Fatalf("processed a file that was still changing: %v", *processed)
+	}
+
+	w.scan(start.Add(5 * time.Second))
+	if len(*processed) != 1 || (*processed)[0] != "scan.pdf" {
+		t.Fatalf("expected scan.pdf to be processed once, got %v", *processed)
+	}
+	if _, err := os.Stat(filepath.Join(w.cfg.archiveDir, "scan.pdf")); err != nil {
+		t.Fatalf("expected original in archive: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected original moved out of the hot folder")
+	}
+
+	// The output stays in the hot folder but is never picked up.
+	w.scan(start.Add(10 * time.Second))
+	w.scan(start.Add(20 * time.Second))
+	if len(*processed) != 1 {
+		t.Fatalf("output was processed again: %v", *processed)
+	}
+}
+
+func TestWatcher_FailuresGoToErrorFolder(t *testing.T) {
+	w, processed := newTestWatcher(t)
+	if err := os.WriteFile(filepath.Join(w.cfg.dir, "bad.pdf"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A file of the same name from an earlier failure is kept.
+	if err := os.WriteFile(filepath.Join(w.cfg.errorDir, "bad.pdf"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	w.scan(start)
+	w.scan(start.Add(3 * time.Second))
+	if len(*processed) != 1 {
+		t.Fatalf("expected one attempt, got %v", *processed)
+	}
+	if _, err := os.Stat(filepath.Join(w.cfg.errorDir, "bad-1.pdf")); err != nil {
+		t.Fatalf("expected failed file in error folder: %v", err)
+	}
+	note, err := os.ReadFile(filepath.Join(w.cfg.errorDir, "bad-1.error.txt"))
+	if err != nil || !strings.Contains(string(note), "broken pdf") {
+		t.Fatalf("expected error note, got %q (%v)", note, err)
+	}
+	if old, _ := os.ReadFile(filepath.Join(w.cfg.errorDir, "bad.pdf")); string(old) != "old" {
+		t.Fatalf("earlier failure was overwritten")
+	}
+}
+
+func TestWatcher_RunPolling(t *testing.T) {
+	w, processed := newTestWatcher(t)
+	w.cfg.noNotify = true
+	w.cfg.poll = 10 * time.Millisecond
+	w.cfg.settle = 30 * time.Millisecond
+	if err := os.WriteFile(filepath.Join(w.cfg.dir, "drop.PDF"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- w.run(ctx) }()
+
+	archived := filepath.Join(w.cfg.archiveDir, "drop.PDF")
+	for {
+		if _, err := os.Stat(archived); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("file was not processed before the timeout")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(*processed) != 1 {
+		t.Fatalf("expected one processed file, got %v", *processed)
+	}
+}
+
+func TestWatcher_SkipsOutputsFromManifest(t *testing.T) {
+	w, processed := newTestWatcher(t)
+	w.cfg.manifest = filepath.Join(w.cfg.dir, manifestFile)
+	// Outputs named by a custom template are not recognized by their name.
+	process := w.cfg.process
+	w.cfg.process = func(path string) (string, error) {
+		if _, err := process(path); err != nil {
+			return "", err
+		}
+		output := filepath.Join(filepath.Dir(path), "small-"+filepath.Base(path))
+		return output, os.WriteFile(output, []byte("cropped"), 0644)
+	}
+	if err := w.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(w.cfg.dir, "scan.pdf"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	w.scan(start)
+	w.scan(start.Add(3 * time.Second))
+	if len(*processed) != 1 {
+		t.Fatalf("expected scan.pdf to be processed, got %v", *processed)
+	}
+
+	// A restarted watcher learns about the output from the manifest.
+	restarted := newWatcher(w.cfg, &bytes.Buffer{})
+	if err := restarted.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	restarted.scan(start.Add(10 * time.Second))
+	restarted.scan(start.Add(20 * time.Second))
+	if len(*processed) != 1 {
+		t.Fatalf("output was processed after a restart: %v", *processed)
+	}
+	state, err := loadManifest(w.cfg.manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := state.Entries["scan.pdf"]; entry.Output != filepath.Join(w.cfg.dir, "small-scan.pdf") || entry.InputHash == "" {
+		t.Errorf("manifest entry = %+v", entry)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"pdf-crop/internal/cli"
+	"pdf-crop/internal/crop"
+)
+
+type infoArgs struct {
+	InputFile string
+	Password  string
+	OwnerPW   string
+}
+
+func parseInfoArgs(argv []string) (infoArgs, error) {
+	var parsed infoArgs
+	for i := 0; i < len(argv); i++ {
+		switch argv[i] {
+		case "-i", "--input_file":
+			if i+1 >= len(argv) {
+				return parsed, fmt.Errorf("missing value for %s", argv[i])
+			}
+			parsed.InputFile = argv[i+1]
+			i++
+		case "--password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Password = val
+			i = next
+		case "--owner-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OwnerPW = val
+			i = next
+		case "--password-file":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if parsed.Password, parsed.OwnerPW, err = cli.ReadPasswordFile(val); err != nil {
+				return parsed, err
+			}
+			i = next
+		case "-h", "--help":
+			return parsed, errHelp
+		default:
+			return parsed, fmt.Errorf("unknown argument: %s", argv[i])
+		}
+	}
+	if parsed.InputFile == "" {
+		return parsed, fmt.Errorf("-i/--input_file is required")
+	}
+	return parsed, nil
+}
+
+// runInfo prints the crop provenance recorded in a PDF.
+func runInfo(argv []string) error {
+	parsed, err := parseInfoArgs(argv)
+	if err != nil {
+		return err
+	}
+	p, err := crop.ReadProvenanceWithPassword(parsed.InputFile, parsed.Password, parsed.OwnerPW)
+	if err != nil {
+		return fmt.Errorf("%s: %w", parsed.InputFile, err)
+	}
+	printProvenance(os.Stdout, p)
+	return nil
+}
+
+func printProvenance(w io.Writer, p *crop.Provenance) {
+	fmt.Fprintf(w, "version %s\n", p.Version)
+	fmt.Fprintf(w, "cropped %s\n", p.Time.Format(time.RFC3339))
+	fmt.Fprintf(w, "mode %s\n", p.Mode)
+	fmt.Fprintf(w, "dpi %g\n", p.DPI)
+	fmt.Fprintf(w, "threshold %g\n", p.Threshold)
+	for _, page := range p.Pages {
+		how := "manual"
+		if page.Auto {
+			how = "auto"
+		}
+		if page.Crop == nil {
+			fmt.Fprintf(w, "page %d %s none\n", page.PageNo, how)
+			continue
+		}
+		fmt.Fprintf(w, "page %d %s %s\n", page.PageNo, how, crop.RectString(page.Crop))
+	}
+}
@@ -1,26 +1,75 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 
+	"pdf-crop/internal/cli"
 	"pdf-crop/internal/crop"
 )
 
+var errHelp = errors.New("help requested")
+
+func printUsage() {
+	fmt.Print(cli.PdfCropUsage())
+}
+
 type args struct {
-	InputFile string
-	Pages     []crop.PageOption
-	Space     int
-	Threshold float64
-	DPI       float64
+	InputFile    string
+	Pages        []crop.PageOption
+	Space        int
+	Threshold    float64
+	DPI          float64
+	Center       string
+	CropFrom     string
+	MinBlock     float64
+	DropHeads    bool
+	Deskew       string
+	RefineDPI    float64
+	CoarseDPI    float64
+	Template     string
+	OutDir       string
+	Overwrite    string
+	InPlace      bool
+	Output       string
+	Order        string
+	Boxes        []string
+	Bleed        float64
+	HardCrop     bool
+	Strip        bool
+	Provenance   bool
+	Links        string
+	Annots       crop.AnnotationPolicies
+	DetectAnnots bool
+	Password     string
+	OwnerPW      string
+	Encryption   string
+	NewPW        string
+	NewOwnerPW   string
+	LogLevel     slog.Level
+	LogFormat    string
 }
 
+// Page orders for a single -o output.
+const (
+	orderOriginal = "original"
+	orderGiven    = "given"
+)
+
 func parseArgs(argv []string) (args, error) {
 	parsed := args{
 		Space:     5,
 		Threshold: 0.008,
 		DPI:       128,
+		Center:    crop.CenterMedian,
+		CropFrom:  "center",
+		Order:     orderOriginal,
+		LogFormat: cli.LogFormatText,
 	}
 	for i := 0; i < len(argv); i++ {
 		switch argv[i] {
@@ -31,8 +80,8 @@ func parseArgs(argv []string) (args, error) {
 			parsed.InputFile = argv[i+1]
 			i++
 		case "-p", "--page":
-			if i+6 >= len(argv) {
-				return parsed, fmt.Errorf("--page requires 6 arguments")
+			if i+5 >= len(argv) {
+				return parsed, fmt.Errorf("--page requires 6 arguments (5 with -o)")
 			}
 			pageNo, err := strconv.Atoi(argv[i+1])
 			if err != nil {
@@ -54,7 +103,13 @@ func parseArgs(argv []string) (args, error) {
 			if err != nil {
 				return parsed, fmt.Errorf("invalid bottom value: %w", err)
 			}
-			output := argv[i+6]
+			// The per-page output is optional: pages go into the -o file
+			// or get a default name.
+			output := ""
+			if i+6 < len(argv) && !strings.HasPrefix(argv[i+6], "-") {
+				output = argv[i+6]
+				i++
+			}
 			parsed.Pages = append(parsed.Pages, crop.PageOption{
 				Number: pageNo,
 				Left:   left,
@@ -63,7 +118,24 @@ func parseArgs(argv []string) (args, error) {
 				Bottom: bottom,
 				Output: output,
 			})
-			i += 6
+			i += 5
+		case "-o", "--output":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Output = val
+			i = next
+		case "--order":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if val != orderOriginal && val != orderGiven {
+				return parsed, fmt.Errorf("invalid --order: %s", val)
+			}
+			parsed.Order = val
+			i = next
 		case "--space":
 			if i+1 >= len(argv) {
 				return parsed, fmt.Errorf("missing value for --space")
@@ -94,6 +166,222 @@ func parseArgs(argv []string) (args, error) {
 			}
 			parsed.DPI = val
 			i++
+		case "--center":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !crop.ValidCenterMode(val) {
+				return parsed, fmt.Errorf("invalid --center: %s", val)
+			}
+			parsed.Center = val
+			i = next
+		case "--crop-from":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !crop.ValidCropFrom(val) {
+				return parsed, fmt.Errorf("invalid --crop-from: %s", val)
+			}
+			parsed.CropFrom = val
+			i = next
+		case "--min-block-area":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			area, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.MinBlock = area
+			i = next
+		case "--refine-dpi":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			dpi, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.RefineDPI = dpi
+			i = next
+		case "--coarse-dpi":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			dpi, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.CoarseDPI = dpi
+			i = next
+		case "--output-template":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if err := crop.ValidTemplate(val, true); err != nil {
+				return parsed, fmt.Errorf("invalid --output-template: %w", err)
+			}
+			parsed.Template = val
+			i = next
+		case "--out-dir":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OutDir = val
+			i = next
+		case "--boxes":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			boxes, err := crop.ParseBoxes(val)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --boxes: %w", err)
+			}
+			parsed.Boxes = boxes
+			i = next
+		case "--bleed":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			bleed, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if bleed < 0 {
+				return parsed, fmt.Errorf("invalid --bleed: %s", val)
+			}
+			parsed.Bleed = bleed
+			i = next
+		case "--provenance":
+			parsed.Provenance = true
+		case "--links":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if val == "" || !crop.ValidLinks(val) {
+				return parsed, fmt.Errorf("invalid --links: %s", val)
+			}
+			parsed.Links = val
+			i = next
+		case "--password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Password = val
+			i = next
+		case "--owner-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OwnerPW = val
+			i = next
+		case "--password-file":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if parsed.Password, parsed.OwnerPW, err = cli.ReadPasswordFile(val); err != nil {
+				return parsed, err
+			}
+			i = next
+		case "--encryption":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if val == "" || !crop.ValidEncryption(val) {
+				return parsed, fmt.Errorf("invalid --encryption: %s", val)
+			}
+			parsed.Encryption = val
+			i = next
+		case "--new-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.NewPW = val
+			i = next
+		case "--new-owner-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.NewOwnerPW = val
+			i = next
+		case "--annotations":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			policies, err := crop.ParseAnnotationPolicies(val)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid --annotations: %w", err)
+			}
+			parsed.Annots = policies
+			i = next
+		case "--detect-annotations":
+			parsed.DetectAnnots = true
+		case "--hard-crop":
+			parsed.HardCrop = true
+		case "--strip-hidden":
+			parsed.Strip = true
+		case "--deskew":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if val == "" || !crop.ValidDeskew(val) {
+				return parsed, fmt.Errorf("invalid --deskew: %s", val)
+			}
+			parsed.Deskew = val
+			i = next
+		case "--overwrite":
+			parsed.Overwrite = crop.OverwriteReplace
+		case "--no-clobber":
+			parsed.Overwrite = crop.OverwriteNever
+		case "--backup":
+			parsed.Overwrite = crop.OverwriteBackup
+		case "--in-place":
+			parsed.InPlace = true
+		case "--drop-headers":
+			parsed.DropHeads = true
+		case "--keep-headers":
+			parsed.DropHeads = false
+		case "--log-level":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			level, err := cli.ParseLogLevel(val)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.LogLevel = level
+			i = next
+		case "--log-format":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !cli.ValidLogFormat(val) {
+				return parsed, fmt.Errorf("invalid --log-format: %s", val)
+			}
+			parsed.LogFormat = val
+			i = next
+		case "-h", "--help":
+			return parsed, errHelp
 		default:
 			return parsed, fmt.Errorf("unknown argument: %s", argv[i])
 		}
@@ -102,30 +390,136 @@ func parseArgs(argv []string) (args, error) {
 	if parsed.InputFile == "" {
 		return parsed, fmt.Errorf("-i/--input_file is required")
 	}
+	if parsed.Strip && !parsed.HardCrop {
+		return parsed, fmt.Errorf("--strip-hidden requires --hard-crop")
+	}
+	if parsed.InPlace && (len(parsed.Pages) > 0 || parsed.Output != "") {
+		return parsed, fmt.Errorf("--in-place crops every page and cannot be combined with -p or -o")
+	}
+	if parsed.Output != "" {
+		for _, page := range parsed.Pages {
+			if page.Output != "" {
+				return parsed, fmt.Errorf("page %d: a per-page output cannot be combined with -o", page.Number)
+			}
+		}
+	}
+	if (parsed.NewPW != "" || parsed.NewOwnerPW != "") && parsed.Encryption != crop.EncryptionEncrypt {
+		return parsed, fmt.Errorf("--new-password and --new-owner-password require --encryption encrypt")
+	}
 
 	return parsed, nil
 }
 
+// orderPages returns pages in document order for orderOriginal and as given
+// on the command line for orderGiven.
+func orderPages(pages []crop.PageOption, order string) []crop.PageOption {
+	if order != orderOriginal {
+		return pages
+	}
+	sorted := slices.Clone(pages)
+	slices.SortStableFunc(sorted, func(a, b crop.PageOption) int {
+		return a.Number - b.Number
+	})
+	return sorted
+}
+
+// subcommands run in place of cropping when named as the first argument.
+var subcommands = map[string]func([]string) error{
+	"info":   runInfo,
+	"serve":  runServe,
+	"uncrop": runUncrop,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			err := run(os.Args[2:])
+			if errors.Is(err, errHelp) {
+				printUsage()
+				return
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	parsed, err := parseArgs(os.Args[1:])
+	if errors.Is(err, errHelp) {
+		printUsage()
+		return
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
+	logger := cli.NewLogger(os.Stderr, parsed.LogLevel, parsed.LogFormat)
 	options := crop.Options{
-		DPI:       parsed.DPI,
-		Threshold: parsed.Threshold,
-		Space:     parsed.Space,
-		CropFrom:  "center",
+		Logger:            logger,
+		DPI:               parsed.DPI,
+		Threshold:         parsed.Threshold,
+		Space:             parsed.Space,
+		CropFrom:          parsed.CropFrom,
+		CenterMode:        parsed.Center,
+		MinBlockArea:      parsed.MinBlock,
+		DropHeaders:       parsed.DropHeads,
+		Deskew:            parsed.Deskew,
+		RefineDPI:         parsed.RefineDPI,
+		CoarseDPI:         parsed.CoarseDPI,
+		Boxes:             parsed.Boxes,
+		Bleed:             parsed.Bleed,
+		HardCrop:          parsed.HardCrop,
+		StripHidden:       parsed.Strip,
+		Provenance:        parsed.Provenance,
+		Links:             parsed.Links,
+		Annotations:       parsed.Annots,
+		DetectAnnotations: parsed.DetectAnnots,
+		Password:          parsed.Password,
+		OwnerPassword:     parsed.OwnerPW,
+		Encryption:        parsed.Encryption,
+		NewPassword:       parsed.NewPW,
+		NewOwnerPassword:  parsed.NewOwnerPW,
+		OutputTemplate:    parsed.Template,
+		OutputDir:         parsed.OutDir,
+		Overwrite:         parsed.Overwrite,
+		InPlace:           parsed.InPlace,
 	}
 
-	results, err := crop.CropPages(parsed.InputFile, parsed.Pages, options)
+	var results []crop.PageResult
+	switch {
+	case parsed.InPlace:
+		results, err = crop.CropAllPagesToSingleFile(parsed.InputFile, parsed.InputFile, options)
+	case parsed.Output != "":
+		results, err = crop.CropPagesToFile(parsed.InputFile, parsed.Output, orderPages(parsed.Pages, parsed.Order), options)
+	default:
+		results, err = crop.CropPages(parsed.InputFile, parsed.Pages, options)
+	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.Error("crop failed", "input", parsed.InputFile, "err", err)
 		os.Exit(1)
 	}
 	for _, res := range results {
 		fmt.Printf("%d %s %s %s\n", res.PageNo, crop.RectString(res.Media), crop.RectString(res.Crop), res.Output)
+		if parsed.Deskew != "" && res.WasAuto {
+			fmt.Printf("%d skew %.2f\n", res.PageNo, res.Skew)
+		}
+		for _, band := range res.Dropped {
+			fmt.Printf("%d dropped %s %s\n", res.PageNo, band.Edge, crop.RectString(band.Rect))
+		}
+		if res.Bleed != nil {
+			fmt.Printf("%d bleed %s\n", res.PageNo, crop.RectString(res.Bleed))
+		}
+		if res.Stripped.Total() > 0 {
+			fmt.Printf("%d stripped %s\n", res.PageNo, res.Stripped)
+		}
+		for _, annot := range res.Annotations {
+			fmt.Printf("%d annotation %s %s %s\n", res.PageNo, annot.Action, annot.Subtype, crop.RectString(annot.Rect))
+		}
+		for _, warning := range res.Warnings {
+			fmt.Printf("%d warning %s\n", res.PageNo, warning)
+		}
 	}
 }
@@ -3,9 +3,17 @@ package main
 import (
 	"bytes"
 	"errors"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+
+	"pdf-crop/internal/crop"
 )
 
 func TestParseArgs_Help(t *testing.T) {
@@ -161,3 +169,357 @@ func TestParseArgs_InvalidBottomValue(t *testing.T) {
 		t.Fatalf("expected error for invalid bottom value")
 	}
 }
+
+func TestParseArgs_Center(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--center", "densest"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Center != "densest" {
+		t.Fatalf("expected center densest, got %q", args.Center)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--center", "middle"}); err == nil {
+		t.Fatalf("expected error for invalid center mode")
+	}
+}
+
+func TestParseArgs_BlocksMode(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--crop-from", "blocks", "--min-block-area", "0.01"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.CropFrom != "blocks" || args.MinBlock != 0.01 {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--crop-from", "edges"}); err == nil {
+		t.Fatalf("expected error for invalid crop mode")
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--min-block-area", "x"}); err == nil {
+		t.Fatalf("expected error for invalid min block area")
+	}
+}
+
+func TestParseArgs_HeaderSwitches(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--drop-headers"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !args.DropHeads {
+		t.Fatalf("expected --drop-headers to enable header exclusion")
+	}
+	args, err = parseArgs([]string{"-i", "in.pdf", "--drop-headers", "--keep-headers"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.DropHeads {
+		t.Fatalf("expected the last header switch to win")
+	}
+}
+
+func TestParseArgs_Deskew(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--deskew", "correct"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Deskew != "correct" {
+		t.Fatalf("expected deskew correct, got %q", args.Deskew)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--deskew", "sideways"}); err == nil {
+		t.Fatalf("expected error for invalid deskew mode")
+	}
+}
+
+func TestParseArgs_RefineDPI(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--refine-dpi", "600"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.RefineDPI != 600 {
+		t.Fatalf("expected refine dpi 600, got %v", args.RefineDPI)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--refine-dpi", "fine"}); err == nil {
+		t.Fatalf("expected error for invalid refine dpi")
+	}
+}
+
+func TestParseArgs_CoarseDPI(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--dpi", "300", "--coarse-dpi", "50"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.CoarseDPI != 50 || args.DPI != 300 {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--coarse-dpi"}); err == nil {
+		t.Fatalf("expected error for missing coarse dpi value")
+	}
+}
+
+func TestParseArgs_SingleOutput(t *testing.T) {
+	args, err := parseArgs([]string{
+		"-i", "in.pdf",
+		"-o", "out.pdf",
+		"-p", "2", "0", "0", "0", "0",
+		"-p", "0", "10", "10", "100", "100",
+		"--order", "given",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Output != "out.pdf" || args.Order != orderGiven {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if len(args.Pages) != 2 || args.Pages[0].Number != 2 || args.Pages[1].Output != "" {
+		t.Fatalf("unexpected pages: %+v", args.Pages)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--order", "random"}); err == nil {
+		t.Fatalf("expected error for invalid order")
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "-o"}); err == nil {
+		t.Fatalf("expected error for missing output value")
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "-o", "out.pdf", "-p", "0", "0", "0", "0", "0", "page.pdf"}); err == nil {
+		t.Fatalf("expected error for a per-page output with -o")
+	}
+}
+
+func TestOrderPages(t *testing.T) {
+	pages := []crop.PageOption{{Number: 3}, {Number: 0}, {Number: 1}}
+	got := orderPages(pages, orderOriginal)
+	if got[0].Number != 0 || got[1].Number != 1 || got[2].Number != 3 {
+		t.Fatalf("original order: %+v", got)
+	}
+	if pages[0].Number != 3 {
+		t.Fatalf("orderPages modified its input")
+	}
+	got = orderPages(pages, orderGiven)
+	if got[0].Number != 3 || got[1].Number != 0 || got[2].Number != 1 {
+		t.Fatalf("given order: %+v", got)
+	}
+}
+
+func TestParseArgs_OutputTemplate(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--output-template", "{dir}/{name}_p{page:03}.pdf", "--out-dir", "out"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Template != "{dir}/{name}_p{page:03}.pdf" || args.OutDir != "out" {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--output-template", "{title}.pdf"}); err == nil {
+		t.Fatalf("expected error for unknown placeholder")
+	}
+}
+
+func TestParseArgs_WritePolicies(t *testing.T) {
+	args, err := parseArgs([]string{"-i", "in.pdf", "--backup", "--in-place"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Overwrite != crop.OverwriteBackup || !args.InPlace {
+		t.Fatalf("parsed values unexpected: %+v", args)
+	}
+	args, err = parseArgs([]string{"-i", "in.pdf", "--backup", "--no-clobber"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args.Overwrite != crop.OverwriteNever {
+		t.Fatalf("expected the last policy flag to win, got %q", args.Overwrite)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--in-place", "-o", "out.pdf"}); err == nil {
+		t.Fatalf("expected error for --in-place with -o")
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--in-place", "-p", "0", "0", "0", "0", "0"}); err == nil {
+		t.Fatalf("expected error for --in-place with -p")
+	}
+}
+
+func TestParseServeArgs(t *testing.T) {
+	parsed, err := parseServeArgs([]string{"--addr", "127.0.0.1:9000", "--max-mb", "8", "--max-concurrent", "2", "--max-dpi", "300", "--max-megapixels", "40", "--timeout", "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Addr != "127.0.0.1:9000" || parsed.MaxBodyMB != 8 || parsed.MaxConcurrent != 2 || parsed.MaxDPI != 300 || parsed.MaxMegapixels != 40 || parsed.Timeout.String() != "5s" {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+
+	for _, argv := range [][]string{
+		{"--timeout", "0s"},
+		{"--max-concurrent", "0"},
+		{"--max-mb", "-1"},
+		{"--max-dpi", "0"},
+		{"--max-megapixels", "0"},
+		{"--bogus"},
+	} {
+		if _, err := parseServeArgs(argv); err == nil {
+			t.Errorf("%v: expected error", argv)
+		}
+	}
+}
+
+func TestParseArgs_Logging(t *testing.T) {
+	parsed, err := parseArgs([]string{"-i", "in.pdf", "--log-level", "debug", "--log-format", "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.LogLevel != slog.LevelDebug || parsed.LogFormat != "json" {
+		t.Fatalf("parsed = %v %q", parsed.LogLevel, parsed.LogFormat)
+	}
+	for _, argv := range [][]string{
+		{"-i", "in.pdf", "--log-level", "loud"},
+		{"-i", "in.pdf", "--log-format", "xml"},
+	} {
+		if _, err := parseArgs(argv); err == nil {
+			t.Errorf("%v: expected error", argv)
+		}
+	}
+}
+
+func TestParseArgs_HardCrop(t *testing.T) {
+	parsed, err := parseArgs([]string{"-i", "in.pdf", "--hard-crop", "--strip-hidden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.HardCrop || !parsed.Strip {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+	if _, err := parseArgs([]string{"-i", "in.pdf", "--strip-hidden"}); err == nil {
+		t.Error("--strip-hidden without --hard-crop: expected error")
+	}
+}
+
+func TestParseUncropArgs(t *testing.T) {
+	parsed, err := parseUncropArgs([]string{"-i", "in.pdf", "-o", "out.pdf", "--pages", "0,2-4", "--no-clobber"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(parsed.Pages, []int{0, 2, 3, 4}) || parsed.Overwrite != crop.OverwriteNever {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+	for _, argv := range [][]string{
+		{"-i", "in.pdf"},
+		{"-i", "in.pdf", "-o", "out.pdf", "--in-place"},
+		{"-o", "out.pdf"},
+		{"-i", "in.pdf", "--in-place", "--pages", "3-1"},
+		{"-i", "in.pdf", "--in-place", "--pages", "-1"},
+		{"-i", "in.pdf", "--in-place", "--pages", "a"},
+	} {
+		if _, err := parseUncropArgs(argv); err == nil {
+			t.Errorf("%v: expected error", argv)
+		}
+	}
+}
+
+func TestParseArgs_Provenance(t *testing.T) {
+	parsed, err := parseArgs([]string{"-i", "in.pdf", "--provenance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Provenance {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+}
+
+func TestParseArgs_Links(t *testing.T) {
+	parsed, err := parseArgs([]string{"-i", "in.pdf", "--links", "external"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Links != crop.LinksExternal {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+	for _, argv := range [][]string{{"-i", "in.pdf", "--links"}, {"-i", "in.pdf", "--links", "keep"}} {
+		if _, err := parseArgs(argv); err == nil {
+			t.Errorf("%v: expected error", argv)
+		}
+	}
+}
+
+func TestParseArgs_Passwords(t *testing.T) {
+	parsed, err := parseArgs([]string{"-i", "in.pdf", "--password", "user", "--owner-password", "owner",
+		"--encryption", "encrypt", "--new-password", "new", "--new-owner-password", "newowner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Password != "user" || parsed.OwnerPW != "owner" || parsed.Encryption != crop.EncryptionEncrypt ||
+		parsed.NewPW != "new" || parsed.NewOwnerPW != "newowner" {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+
+	file := filepath.Join(t.TempDir(), "passwords")
+	if err := os.WriteFile(file, []byte("from file\r\nowner from file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = parseArgs([]string{"-i", "in.pdf", "--password-file", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Password != "from file" || parsed.OwnerPW != "owner from file" {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+
+	for _, argv := range [][]string{
+		{"-i", "in.pdf", "--password"},
+		{"-i", "in.pdf", "--password-file", filepath.Join(t.TempDir(), "missing")},
+		{"-i", "in.pdf", "--encryption", "rc4"},
+		{"-i", "in.pdf", "--new-password", "new"},
+	} {
+		if _, err := parseArgs(argv); err == nil {
+			t.Errorf("%v: expected error", argv)
+		}
+	}
+
+	uncrop, err := parseUncropArgs([]string{"-i", "in.pdf", "--in-place", "--owner-password", "owner"})
+	if err != nil || uncrop.OwnerPW != "owner" {
+		t.Fatalf("uncrop = %+v, err = %v", uncrop, err)
+	}
+}
+
+func TestParseArgs_Annotations(t *testing.T) {
+	parsed, err := parseArgs([]string{"-i", "in.pdf", "--annotations", "clip,widgets=keep", "--detect-annotations"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := crop.AnnotationPolicies{Links: crop.AnnotationsClip, Widgets: crop.AnnotationsKeep, Comments: crop.AnnotationsClip}
+	if parsed.Annots != want || !parsed.DetectAnnots {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+	for _, argv := range [][]string{{"-i", "in.pdf", "--annotations"}, {"-i", "in.pdf", "--annotations", "links=hide"}} {
+		if _, err := parseArgs(argv); err == nil {
+			t.Errorf("%v: expected error", argv)
+		}
+	}
+}
+
+func TestParseInfoArgs(t *testing.T) {
+	parsed, err := parseInfoArgs([]string{"-i", "in.pdf", "--password", "user", "--owner-password", "owner"})
+	if err != nil || parsed.InputFile != "in.pdf" || parsed.Password != "user" || parsed.OwnerPW != "owner" {
+		t.Fatalf("parsed = %+v, err = %v", parsed, err)
+	}
+	for _, argv := range [][]string{{}, {"-i"}, {"-i", "in.pdf", "--bogus"}, {"-i", "in.pdf", "--password"}} {
+		if _, err := parseInfoArgs(argv); err == nil {
+			t.Errorf("%v: expected error", argv)
+		}
+	}
+}
+
+func TestPrintProvenance(t *testing.T) {
+	var buf bytes.Buffer
+	printProvenance(&buf, &crop.Provenance{
+		Version:   "v1.2.3",
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Mode:      "border",
+		DPI:       150,
+		Threshold: 0.01,
+		Pages: []crop.ProvenancePage{
+			{PageNo: 1, Crop: types.NewRectangle(10, 20, 30, 40), Auto: true},
+			{PageNo: 0, Crop: types.NewRectangle(0, 0, 50, 50)},
+			{PageNo: 2},
+		},
+	})
+	want := "version v1.2.3\ncropped 2024-01-02T03:04:05Z\nmode border\ndpi 150\nthreshold 0.01\n" +
+		"page 1 auto (10, 20), (30, 40)\npage 0 manual (0, 0), (50, 50)\npage 2 manual none\n"
+	if buf.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
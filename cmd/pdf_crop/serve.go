@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"pdf-crop/internal/cli"
+	"pdf-crop/internal/crop"
+	"pdf-crop/internal/metrics"
+	"pdf-crop/internal/server"
+)
+
+type serveArgs struct {
+	Addr          string
+	MaxBodyMB     float64
+	MaxConcurrent int
+	MaxDPI        float64
+	MaxMegapixels float64
+	Timeout       time.Duration
+	LogLevel      slog.Level
+	LogFormat     string
+}
+
+func parseServeArgs(argv []string) (serveArgs, error) {
+	parsed := serveArgs{
+		Addr:          ":8080",
+		MaxBodyMB:     server.DefaultMaxBodyBytes >> 20,
+		MaxDPI:        server.DefaultMaxDPI,
+		MaxMegapixels: server.DefaultMaxPixels / 1e6,
+		Timeout:       server.DefaultTimeout,
+		LogFormat:     cli.LogFormatText,
+	}
+	for i := 0; i < len(argv); i++ {
+		switch argv[i] {
+		case "--addr":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Addr = val
+			i = next
+		case "--max-mb":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			mb, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if mb <= 0 {
+				return parsed, fmt.Errorf("invalid --max-mb: %s", val)
+			}
+			parsed.MaxBodyMB = mb
+			i = next
+		case "--max-concurrent":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			n, err := cli.ParseInt(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if n <= 0 {
+				return parsed, fmt.Errorf("invalid --max-concurrent: %s", val)
+			}
+			parsed.MaxConcurrent = n
+			i = next
+		case "--max-dpi":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			dpi, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if dpi <= 0 {
+				return parsed, fmt.Errorf("invalid --max-dpi: %s", val)
+			}
+			parsed.MaxDPI = dpi
+			i = next
+		case "--max-megapixels":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			mp, err := cli.ParseFloat(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if mp <= 0 {
+				return parsed, fmt.Errorf("invalid --max-megapixels: %s", val)
+			}
+			parsed.MaxMegapixels = mp
+			i = next
+		case "--timeout":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return parsed, fmt.Errorf("invalid --timeout: %s", val)
+			}
+			parsed.Timeout = d
+			i = next
+		case "--log-level":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			level, err := cli.ParseLogLevel(val)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.LogLevel = level
+			i = next
+		case "--log-format":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !cli.ValidLogFormat(val) {
+				return parsed, fmt.Errorf("invalid --log-format: %s", val)
+			}
+			parsed.LogFormat = val
+			i = next
+		case "-h", "--help":
+			return parsed, errHelp
+		default:
+			return parsed, fmt.Errorf("unknown argument: %s", argv[i])
+		}
+	}
+	return parsed, nil
+}
+
+// runServe serves the HTTP API until interrupted, then lets running
+// requests finish.
+func runServe(argv []string) error {
+	parsed, err := parseServeArgs(argv)
+	if err != nil {
+		return err
+	}
+
+	logger := cli.NewLogger(os.Stderr, parsed.LogLevel, parsed.LogFormat)
+	options := crop.DefaultOptions()
+	options.Logger = logger
+	prom := metrics.NewPrometheus()
+	srv := &http.Server{
+		Addr: parsed.Addr,
+		Handler: server.New(server.Config{
+			MaxBodyBytes:   int64(parsed.MaxBodyMB * (1 << 20)),
+			MaxConcurrent:  parsed.MaxConcurrent,
+			MaxDPI:         parsed.MaxDPI,
+			MaxPixels:      int64(parsed.MaxMegapixels * 1e6),
+			Timeout:        parsed.Timeout,
+			Options:        options,
+			Metrics:        prom,
+			MetricsHandler: prom.Handler(),
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", parsed.Addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), parsed.Timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"pdf-crop/internal/cli"
+	"pdf-crop/internal/crop"
+)
+
+type uncropArgs struct {
+	InputFile string
+	Output    string
+	Pages     []int
+	Overwrite string
+	InPlace   bool
+	Password  string
+	OwnerPW   string
+	LogLevel  slog.Level
+	LogFormat string
+}
+
+func parseUncropArgs(argv []string) (uncropArgs, error) {
+	parsed := uncropArgs{LogFormat: cli.LogFormatText}
+	for i := 0; i < len(argv); i++ {
+		switch argv[i] {
+		case "-i", "--input_file":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.InputFile = val
+			i = next
+		case "-o", "--output":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Output = val
+			i = next
+		case "--pages":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			pages, err := cli.ParsePages(val, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Pages = append(parsed.Pages, pages...)
+			i = next
+		case "--overwrite":
+			parsed.Overwrite = crop.OverwriteReplace
+		case "--no-clobber":
+			parsed.Overwrite = crop.OverwriteNever
+		case "--backup":
+			parsed.Overwrite = crop.OverwriteBackup
+		case "--in-place":
+			parsed.InPlace = true
+		case "--password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.Password = val
+			i = next
+		case "--owner-password":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			parsed.OwnerPW = val
+			i = next
+		case "--password-file":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if parsed.Password, parsed.OwnerPW, err = cli.ReadPasswordFile(val); err != nil {
+				return parsed, err
+			}
+			i = next
+		case "--log-level":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			level, err := cli.ParseLogLevel(val)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.LogLevel = level
+			i = next
+		case "--log-format":
+			val, next, err := cli.RequireValue(argv, i, argv[i])
+			if err != nil {
+				return parsed, err
+			}
+			if !cli.ValidLogFormat(val) {
+				return parsed, fmt.Errorf("invalid --log-format: %s", val)
+			}
+			parsed.LogFormat = val
+			i = next
+		case "-h", "--help":
+			return parsed, errHelp
+		default:
+			return parsed, fmt.Errorf("unknown argument: %s", argv[i])
+		}
+	}
+
+	if parsed.InputFile == "" {
+		return parsed, fmt.Errorf("-i/--input_file is required")
+	}
+	if parsed.InPlace == (parsed.Output != "") {
+		return parsed, fmt.Errorf("uncrop needs exactly one of -o and --in-place")
+	}
+	return parsed, nil
+}
+
+// runUncrop restores the page boxes recorded when the input was cropped.
+func runUncrop(argv []string) error {
+	parsed, err := parseUncropArgs(argv)
+	if err != nil {
+		return err
+	}
+
+	logger := cli.NewLogger(os.Stderr, parsed.LogLevel, parsed.LogFormat)
+	output := parsed.Output
+	if parsed.InPlace {
+		output = parsed.InputFile
+	}
+	results, err := crop.Restore(parsed.InputFile, output, parsed.Pages, crop.Options{
+		Logger:        logger,
+		Overwrite:     parsed.Overwrite,
+		InPlace:       parsed.InPlace,
+		Password:      parsed.Password,
+		OwnerPassword: parsed.OwnerPW,
+	})
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		fmt.Printf("%d %s %s %s\n", res.PageNo, crop.RectString(res.Media), crop.RectString(res.Crop), res.Output)
+	}
+	return nil
+}
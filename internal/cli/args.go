@@ -2,7 +2,9 @@ package cli
 
 import (
 	"fmt"
+	"os"
 	"strconv"
+	"strings"
 )
 
 // RequireValue returns the next argument after a flag or an error if missing.
@@ -38,3 +40,43 @@ func ParseFloat(val string, flag string) (float64, error) {
 	}
 	return v, nil
 }
+
+// ParsePages parses a comma-separated list of zero-based page numbers and
+// ranges such as "0,2-4" and annotates errors with the flag name.
+func ParsePages(val string, flag string) ([]int, error) {
+	var pages []int
+	for _, part := range strings.Split(val, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid %s: %s", flag, val)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil || to < from {
+				return nil, fmt.Errorf("invalid %s: %s", flag, val)
+			}
+		}
+		for p := from; p <= to; p++ {
+			pages = append(pages, p)
+		}
+	}
+	return pages, nil
+}
+
+// ReadPasswordFile reads the passwords for --password-file: the user
+// password on the first line and, optionally, the owner password on the
+// second. Keeping passwords in a file keeps them out of the process list
+// and the shell history.
+func ReadPasswordFile(path string) (password, ownerPassword string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid --password-file: %w", err)
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	password = lines[0]
+	if len(lines) > 1 {
+		ownerPassword = lines[1]
+	}
+	return password, ownerPassword, nil
+}
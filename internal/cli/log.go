@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats accepted by --log-format.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// ParseLogLevel parses a --log-level value: debug, info, warn or error.
+func ParseLogLevel(val string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(val))); err != nil {
+		return 0, fmt.Errorf("invalid --log-level: %s", val)
+	}
+	return level, nil
+}
+
+// ValidLogFormat reports whether format is a known --log-format value.
+func ValidLogFormat(format string) bool {
+	return format == LogFormatText || format == LogFormatJSON
+}
+
+// NewLogger returns a logger that writes records at level and above to w in
+// the given format.
+func NewLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
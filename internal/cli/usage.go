@@ -3,25 +3,126 @@ package cli
 func PdfCropUsage() string {
 	return "pdf_crop - Crop PDF pages using raster detection\n\n" +
 		"Usage:\n" +
-		"  pdf_crop -i <input.pdf> [--threshold <float>] [--space <int>] [--dpi <float>]\n" +
-		"  pdf_crop -i <input.pdf> -p <page> <left> <top> <right> <bottom> <out.pdf> [repeatable]\n\n" +
+		"  pdf_crop -i <input.pdf> [--threshold <float>] [--space <int>] [--dpi <float>] [--crop-from <mode>] [--center <mode>]\n" +
+		"  pdf_crop -i <input.pdf> -p <page> <left> <top> <right> <bottom> <out.pdf> [repeatable]\n" +
+		"  pdf_crop -i <input.pdf> -o <out.pdf> [-p <page> <left> <top> <right> <bottom> ...] [--order <order>]\n" +
+		"  pdf_crop info -i <cropped.pdf> [--password <pw>] [--owner-password <pw>] [--password-file <path>]\n" +
+		"  pdf_crop uncrop -i <cropped.pdf> (-o <out.pdf> | --in-place) [--pages <list>]\n" +
+		"  pdf_crop serve [--addr <host:port>] [--max-mb <float>] [--max-concurrent <int>] [--max-dpi <float>] [--max-megapixels <float>] [--timeout <duration>]\n\n" +
 		"Options:\n" +
 		"  -i, --input_file    Path to input PDF (required)\n" +
 		"  -p, --page          Per-page crop + output: page left top right bottom out.pdf (can repeat)\n" +
+		"                      Use 0 0 0 0 to auto-detect; out.pdf must be omitted with -o\n" +
+		"  -o, --output        Write all selected pages into this single PDF\n" +
+		"      --order          Page order in the -o output: original or given (default: original)\n" +
+		"      --output-template Name per-page outputs, e.g. {dir}/{name}_p{page:03}.pdf\n" +
+		"                      Placeholders: {dir} {name} {ext} {page} {page1} {date}\n" +
+		"      --out-dir        Write outputs to this directory instead of next to the input\n" +
+		"      --overwrite      Replace existing outputs (default)\n" +
+		"      --no-clobber     Fail instead of replacing an existing output\n" +
+		"      --backup         Keep an existing output as <name>.bak before replacing it\n" +
+		"      --in-place       Crop every page and replace the input file (not with -p or -o)\n" +
 		"      --threshold      Detection threshold (default: 0.008)\n" +
 		"      --space          Extra whitespace in points (default: 5)\n" +
 		"      --dpi            Rasterization DPI (default: 128)\n" +
-		"  -h, --help          Show this help and exit\n"
+		"      --crop-from      Detection mode: center, border, blocks (default: center)\n" +
+		"      --center         Center detection: median, centroid, profile, densest (default: median)\n" +
+		"      --min-block-area Ignore blocks smaller than this fraction of the page (blocks mode)\n" +
+		"      --refine-dpi     Re-render the border strips at this DPI to place edges precisely\n" +
+		"      --coarse-dpi     Detect at this low DPI first, then refine the edges at --dpi\n" +
+		"      --deskew         Estimate page skew: detect (report only) or correct (rotate content)\n" +
+		"      --boxes          Page boxes to set: crop, trim, bleed, art, comma-separated (default: crop)\n" +
+		"                      Without crop the existing CropBox is kept\n" +
+		"      --bleed          BleedBox margin around the frame in points (with --boxes bleed)\n" +
+		"      --hard-crop      Also set the MediaBox to the crop, so the page really ends there\n" +
+		"      --strip-hidden   With --hard-crop, remove content and annotations fully outside the crop\n" +
+		"      --annotations    Annotations outside the crop: keep, drop (if fully outside) or clip,\n" +
+		"                      for all or per kind, e.g. clip,widgets=keep; kinds: links, widgets, comments\n" +
+		"      --detect-annotations Include annotation appearances in content detection\n" +
+		"      --provenance     Record the version, time, settings and page crops in the document info\n" +
+		"      --links          Links to pages left out of a -p or -o output: remove or external\n" +
+		"                      (link into the input file) (default: remove)\n" +
+		"      --password       User password of an encrypted input\n" +
+		"      --owner-password Owner password of an encrypted input\n" +
+		"      --password-file  Read the user password, and the owner password from a second line, from a file\n" +
+		"      --encryption     Output encryption: keep (the input's), decrypt or encrypt (AES-256) (default: keep)\n" +
+		"      --new-password   User password for --encryption encrypt (default: --password)\n" +
+		"      --new-owner-password Owner password for --encryption encrypt (default: --owner-password)\n" +
+		"      --drop-headers   Exclude running heads, footers and page numbers from detection\n" +
+		"      --keep-headers   Keep headers and footers inside the crop (default)\n" +
+		"      --log-level      Log level on stderr: debug, info, warn, error (default: info)\n" +
+		"      --log-format     Log format on stderr: text or json (default: text)\n" +
+		"  -h, --help          Show this help and exit\n\n" +
+		"Uncrop options:\n" +
+		"      --pages          Zero-based pages to restore, e.g. 0,2-4 (default: all)\n" +
+		"      -i, -o, --in-place, --overwrite, --no-clobber, --backup, the passwords and logging as above\n" +
+		"                      Fails for pages that were not cropped by pdf_crop\n\n" +
+		"Serve options:\n" +
+		"      --addr           Listen address (default: :8080)\n" +
+		"      --max-mb         Largest accepted upload in MiB (default: 64)\n" +
+		"      --max-concurrent Documents processed at once (default: number of CPUs)\n" +
+		"      --max-dpi        Highest dpi, refine_dpi or coarse_dpi a request may ask for (default: 1200)\n" +
+		"      --max-megapixels Largest page render in megapixels, 4 bytes each (default: 150)\n" +
+		"      --timeout        Time limit per request, including queueing (default: 60s)\n" +
+		"                      Prometheus metrics are served at GET /metrics\n" +
+		"      --log-level, --log-format as above\n"
 }
 
 func CropAllPdfUsage() string {
 	return "crop_all_pdf - Crop all PDFs in a directory\n\n" +
 		"Usage:\n" +
-		"  crop_all_pdf --dir <path> [--threshold <float>] [--space <int>] [--dpi <float>]\n\n" +
+		"  crop_all_pdf --dir <path> [--threshold <float>] [--space <int>] [--dpi <float>] [--crop-from <mode>] [--center <mode>]\n" +
+		"  crop_all_pdf --dir <path> --watch [--archive-dir <path>] [--error-dir <path>]\n\n" +
 		"Options:\n" +
 		"  -d, --dir           Directory containing PDFs (default: current directory)\n" +
+		"  -r, --recursive     Also process PDFs in subdirectories\n" +
+		"      --include        Only process files whose path or name matches this glob (can repeat)\n" +
+		"      --exclude        Skip files and directories matching this glob (can repeat)\n" +
+		"      --symlinks       Symbolic links: skip, files (default), follow (also directories)\n" +
+		"      --force          Re-crop files the manifest records as unchanged\n" +
+		"      --manifest       State file for incremental runs (default: <dir>/.crop_all_pdf.json)\n" +
+		"      --no-manifest    Neither read nor write the state file\n" +
+		"      --watch          Keep running and crop PDFs as they appear in --dir\n" +
+		"      --archive-dir    Where --watch moves processed originals (default: <dir>/archive)\n" +
+		"      --error-dir      Where --watch moves files that failed (default: <dir>/error)\n" +
+		"      --settle         How long a file must stay unchanged before cropping (default: 2s)\n" +
+		"      --poll           Rescan interval for --watch (default: 1s)\n" +
+		"      --no-notify      Poll only, without file system notifications (e.g. network shares)\n" +
+		"      --metrics-addr   Serve Prometheus metrics at http://<addr>/metrics during --watch\n" +
+		"      --output-template Name outputs, e.g. {name}.cropped.pdf (default: {dir}/cropped_{name}{ext})\n" +
+		"                      Placeholders: {dir} {name} {ext} {date}\n" +
+		"      --out-dir        Write outputs to this directory, mirroring the input tree\n" +
+		"      --overwrite      Replace existing outputs (default)\n" +
+		"      --no-clobber     Fail instead of replacing an existing output\n" +
+		"      --backup         Keep an existing output as <name>.bak before replacing it\n" +
+		"      --in-place       Replace each input file with its cropped version\n" +
 		"      --threshold      Detection threshold (default: 0.1)\n" +
 		"      --space          Extra whitespace in points (default: 5)\n" +
 		"      --dpi            Rasterization DPI (default: 128)\n" +
+		"      --crop-from      Detection mode: center, border, blocks (default: center)\n" +
+		"      --center         Center detection: median, centroid, profile, densest (default: median)\n" +
+		"      --min-block-area Ignore blocks smaller than this fraction of the page (blocks mode)\n" +
+		"      --refine-dpi     Re-render the border strips at this DPI to place edges precisely\n" +
+		"      --coarse-dpi     Detect at this low DPI first, then refine the edges at --dpi\n" +
+		"      --deskew         Estimate page skew: detect (report only) or correct (rotate content)\n" +
+		"      --boxes          Page boxes to set: crop, trim, bleed, art, comma-separated (default: crop)\n" +
+		"                      Without crop the existing CropBox is kept\n" +
+		"      --bleed          BleedBox margin around the frame in points (with --boxes bleed)\n" +
+		"      --hard-crop      Also set the MediaBox to the crop, so the page really ends there\n" +
+		"      --strip-hidden   With --hard-crop, remove content and annotations fully outside the crop\n" +
+		"      --annotations    Annotations outside the crop: keep, drop (if fully outside) or clip,\n" +
+		"                      for all or per kind, e.g. clip,widgets=keep; kinds: links, widgets, comments\n" +
+		"      --detect-annotations Include annotation appearances in content detection\n" +
+		"      --provenance     Record the version, time, settings and page crops in the document info\n" +
+		"      --password       User password of an encrypted input\n" +
+		"      --owner-password Owner password of an encrypted input\n" +
+		"      --password-file  Read the user password, and the owner password from a second line, from a file\n" +
+		"      --encryption     Output encryption: keep (the input's), decrypt or encrypt (AES-256) (default: keep)\n" +
+		"      --new-password   User password for --encryption encrypt (default: --password)\n" +
+		"      --new-owner-password Owner password for --encryption encrypt (default: --owner-password)\n" +
+		"      --drop-headers   Exclude running heads, footers and page numbers from detection\n" +
+		"      --keep-headers   Keep headers and footers inside the crop (default)\n" +
+		"      --log-level      Log level on stderr: debug, info, warn, error (default: info)\n" +
+		"      --log-format     Log format on stderr: text or json (default: text)\n" +
 		"  -h, --help          Show this help and exit\n"
 }
@@ -0,0 +1,282 @@
+package crop
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestParseAnnotationPolicies(t *testing.T) {
+	tests := []struct {
+		list string
+		want AnnotationPolicies
+	}{
+		{"drop", AnnotationPolicies{Links: "drop", Widgets: "drop", Comments: "drop"}},
+		{"clip, widgets=keep", AnnotationPolicies{Links: "clip", Widgets: "keep", Comments: "clip"}},
+		{"Links=Drop,comments=clip", AnnotationPolicies{Links: "drop", Comments: "clip"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseAnnotationPolicies(tt.list)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.list, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q = %+v, want %+v", tt.list, got, tt.want)
+		}
+	}
+	for _, list := range []string{"", "hide", "links=hide", "popups=drop", "drop,"} {
+		if _, err := ParseAnnotationPolicies(list); err == nil {
+			t.Errorf("%q: expected error", list)
+		}
+	}
+}
+
+// writeAnnotatedFixture adds annotations around the square (100, 100),
+// (200, 200) of the 300x300 fixture page: outside it a link, a note with a
+// popup, a form field widget and a black stamp, and across its left edge a
+// square with an appearance.
+func writeAnnotatedFixture(t *testing.T, dir string) string {
+	t.Helper()
+	ctx, err := api.ReadContextFile(writeFixturePDF(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newObject := func(o types.Object) types.IndirectRef {
+		ir, err := ctx.IndRefForNewObject(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *ir
+	}
+	appearance := func(content string, bbox *types.Rectangle) types.IndirectRef {
+		sd, _ := ctx.NewStreamDictForBuf([]byte(content))
+		sd.Dict["Type"] = types.Name("XObject")
+		sd.Dict["Subtype"] = types.Name("Form")
+		sd.Dict["BBox"] = bbox.Array()
+		if err := sd.Encode(); err != nil {
+			t.Fatal(err)
+		}
+		return newObject(*sd)
+	}
+	annot := func(subtype string, rect *types.Rectangle, extra types.Dict) types.IndirectRef {
+		d := types.Dict{"Type": types.Name("Annot"), "Subtype": types.Name(subtype), "Rect": rect.Array()}
+		for k, v := range extra {
+			d[k] = v
+		}
+		return newObject(d)
+	}
+
+	note := annot("Text", types.NewRectangle(5, 250, 25, 270), nil)
+	popup := annot("Popup", types.NewRectangle(30, 200, 90, 240), types.Dict{"Parent": note})
+	widget := annot("Widget", types.NewRectangle(250, 5, 290, 25), types.Dict{
+		"FT": types.Name("Tx"), "T": types.StringLiteral("name"), "DA": types.StringLiteral("/Helv 0 Tf 0 g"),
+	})
+	root, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root["AcroForm"] = types.Dict{"Fields": types.Array{widget}}
+
+	d, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d["Annots"] = types.Array{
+		annot("Link", types.NewRectangle(5, 5, 25, 25), nil),
+		note,
+		popup,
+		widget,
+		annot("Square", types.NewRectangle(80, 120, 130, 170), types.Dict{
+			"AP": types.Dict{"N": appearance("0 0 1 RG 0 0 50 50 re S", types.NewRectangle(0, 0, 50, 50))},
+		}),
+		annot("Stamp", types.NewRectangle(240, 240, 280, 280), types.Dict{
+			"AP": types.Dict{"N": appearance("0 g 0 0 10 10 re f", types.NewRectangle(0, 0, 10, 10))},
+		}),
+	}
+
+	out := filepath.Join(dir, "annotated.pdf")
+	if err := api.WriteContextFile(ctx, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// pageAnnotations returns the annotations of page 1 of ctx by subtype.
+func pageAnnotations(t *testing.T, ctx *model.Context) map[string]types.Dict {
+	t.Helper()
+	annots := map[string]types.Dict{}
+	for _, annot := range pageLinks(t, ctx, 1) {
+		annots[*annot.NameEntry("Subtype")] = annot
+	}
+	return annots
+}
+
+func TestCropPagesToFile_AnnotationPolicies(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeAnnotatedFixture(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	opts := DefaultOptions()
+	opts.Annotations = AnnotationPolicies{Links: AnnotationsDrop, Widgets: AnnotationsDrop, Comments: AnnotationsClip}
+	page := PageOption{Number: 0, Left: 100, Top: 100, Right: 200, Bottom: 200}
+	results, err := CropPagesToFile(pdfPath, outPath, []PageOption{page}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, change := range results[0].Annotations {
+		got[change.Subtype] = change.Action
+	}
+	want := map[string]string{"Link": AnnotationDropped, "Text": AnnotationDropped, "Widget": AnnotationDropped, "Stamp": AnnotationDropped, "Square": AnnotationClipped}
+	if len(got) != len(want) {
+		t.Errorf("changes = %v, want %v", got, want)
+	}
+	for subtype, action := range want {
+		if got[subtype] != action {
+			t.Errorf("%s %s, want %s", subtype, got[subtype], action)
+		}
+	}
+
+	ctx, err := api.ReadContextFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annots := pageAnnotations(t, ctx)
+	if len(annots) != 1 {
+		t.Fatalf("annotations = %v, want only the square", annots)
+	}
+	square := annots["Square"]
+	rect, err := dictRect(ctx, square["Rect"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rect.Equals(*types.NewRectangle(100, 120, 130, 170)) {
+		t.Errorf("square Rect = %v", rect)
+	}
+	ap, _ := ctx.DereferenceDict(square["AP"])
+	sd, _, err := ctx.DereferenceStreamDict(ap["N"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bbox, err := dictRect(ctx, sd.Dict["BBox"]); err != nil || !bbox.Equals(*types.NewRectangle(20, 0, 50, 50)) {
+		t.Errorf("square BBox = %v, %v", bbox, err)
+	}
+
+	root, _ := ctx.Catalog()
+	form, _ := ctx.DereferenceDict(root["AcroForm"])
+	if fields, _ := ctx.DereferenceArray(form["Fields"]); len(fields) != 0 {
+		t.Errorf("form fields = %v, want none", fields)
+	}
+}
+
+func TestCropPagesToFile_AnnotationsKeptByDefault(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeAnnotatedFixture(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	page := PageOption{Number: 0, Left: 100, Top: 100, Right: 200, Bottom: 200}
+	results, err := CropPagesToFile(pdfPath, outPath, []PageOption{page}, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results[0].Annotations) != 0 {
+		t.Errorf("changes = %+v", results[0].Annotations)
+	}
+	ctx, err := api.ReadContextFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annots := pageLinks(t, ctx, 1); len(annots) != 6 {
+		t.Errorf("annotations = %d, want all 6", len(annots))
+	}
+}
+
+func TestCropPagesToFile_AnnotationPoliciesFollowBoxes(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeAnnotatedFixture(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	// Only the TrimBox is set, so the CropBox still spans the whole page.
+	opts := DefaultOptions()
+	opts.Boxes = []string{BoxTrim}
+	opts.Annotations = AnnotationPolicies{Links: AnnotationsDrop, Widgets: AnnotationsKeep, Comments: AnnotationsKeep}
+	page := PageOption{Number: 0, Left: 100, Top: 100, Right: 200, Bottom: 200}
+	results, err := CropPagesToFile(pdfPath, outPath, []PageOption{page}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := results[0].Annotations; len(changes) != 1 || changes[0].Subtype != "Link" {
+		t.Errorf("changes = %+v, want the link dropped", changes)
+	}
+}
+
+func TestCropAllPagesToSingleFile_DetectAnnotations(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeAnnotatedFixture(t, tdir)
+
+	crop := func(detect bool) *types.Rectangle {
+		opts := DefaultOptions()
+		opts.CropFrom = "border"
+		opts.DetectAnnotations = detect
+		results, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "out.pdf"), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results[0].Crop
+	}
+	if without := crop(false); without.UR.X >= 240 || without.UR.Y >= 240 {
+		t.Errorf("crop without annotations = %v, want it to leave out the stamp", without)
+	}
+	if with := crop(true); with.UR.X < 280 || with.UR.Y < 280 {
+		t.Errorf("crop with annotations = %v, want it to take in the stamp", with)
+	}
+}
+
+func TestRemoveField(t *testing.T) {
+	ctx, err := api.ReadContextFile(writeFixturePDF(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newObject := func(o types.Object) types.IndirectRef {
+		ir, err := ctx.IndRefForNewObject(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *ir
+	}
+	// A field with two widgets under a group, next to another field.
+	group := newObject(types.Dict{"T": types.StringLiteral("group")})
+	field := newObject(types.Dict{"T": types.StringLiteral("field"), "Parent": group})
+	first := newObject(types.Dict{"Subtype": types.Name("Widget"), "Parent": field})
+	second := newObject(types.Dict{"Subtype": types.Name("Widget"), "Parent": field})
+	other := newObject(types.Dict{"T": types.StringLiteral("other")})
+	groupDict, _ := ctx.DereferenceDict(group)
+	groupDict["Kids"] = types.Array{field}
+	fieldDict, _ := ctx.DereferenceDict(field)
+	fieldDict["Kids"] = newObject(types.Array{first, second})
+	root, _ := ctx.Catalog()
+	form := types.Dict{"Fields": types.Array{group, other}, "CO": types.Array{field}}
+	root["AcroForm"] = form
+
+	remove := func(ir types.IndirectRef) {
+		t.Helper()
+		widget, _ := ctx.DereferenceDict(ir)
+		if err := removeField(ctx, ir, widget); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remove(first)
+	if kids, _ := ctx.DereferenceArray(fieldDict["Kids"]); len(kids) != 1 || len(form.ArrayEntry("Fields")) != 2 {
+		t.Fatalf("after the first widget: kids %v, fields %v", kids, form["Fields"])
+	}
+	remove(second)
+	if fields := form.ArrayEntry("Fields"); len(fields) != 1 || fields[0] != other {
+		t.Errorf("fields = %v, want only the other field", fields)
+	}
+	if co := form.ArrayEntry("CO"); len(co) != 0 {
+		t.Errorf("calculation order = %v", co)
+	}
+}
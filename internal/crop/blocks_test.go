@@ -0,0 +1,100 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// makeBlocksImage draws a figure near the top and a text block near the
+// bottom, separated by a tall whitespace gap, plus a single stray pixel.
+func makeBlocksImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	for y := 10; y < 40; y++ {
+		for x := 20; x < 60; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+	for y := 120; y < 180; y++ {
+		for x := 30; x < 90; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+	img.Set(5, 195, color.Black)
+	return img
+}
+
+func TestSplitProfile(t *testing.T) {
+	counts := []int{0, 1, 1, 0, 1, 0, 0, 0, 2, 2, 0}
+	runs := splitProfile(counts, 3)
+	if len(runs) != 2 {
+		t.Fatalf("expected 2 runs, got %v", runs)
+	}
+	if runs[0] != [2]int{1, 5} || runs[1] != [2]int{8, 10} {
+		t.Errorf("unexpected runs: %v", runs)
+	}
+}
+
+func TestDetectBlocks_FindsSeparatedBlocks(t *testing.T) {
+	d := buildDetectData(makeBlocksImage())
+	blocks := detectBlocks(d, 5, 5)
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d: %v", len(blocks), blocks)
+	}
+	if blocks[0] != (contentBlock{x0: 20, y0: 10, x1: 60, y1: 40}) {
+		t.Errorf("unexpected figure block: %+v", blocks[0])
+	}
+	if blocks[1] != (contentBlock{x0: 30, y0: 120, x1: 90, y1: 180}) {
+		t.Errorf("unexpected text block: %+v", blocks[1])
+	}
+}
+
+func TestUnionBlocks_MinArea(t *testing.T) {
+	d := buildDetectData(makeBlocksImage())
+	blocks := detectBlocks(d, 5, 5)
+
+	all, ok := unionBlocks(blocks, 0)
+	if !ok || all != (contentBlock{x0: 5, y0: 10, x1: 90, y1: 196}) {
+		t.Errorf("unexpected union of all blocks: %+v", all)
+	}
+
+	large, ok := unionBlocks(blocks, 10)
+	if !ok || large != (contentBlock{x0: 20, y0: 10, x1: 90, y1: 180}) {
+		t.Errorf("unexpected union without specks: %+v", large)
+	}
+
+	if _, ok := unionBlocks(blocks, 1_000_000); ok {
+		t.Errorf("expected no block above the area limit")
+	}
+}
+
+func TestDetectFrame_BlocksKeepsAllContent(t *testing.T) {
+	img := makeBlocksImage()
+	opts := Options{Space: 5, Threshold: 0.05, CropFrom: "blocks", MinBlockArea: 0.001}
+
+	left, top, right, bottom := detectFrame(img, opts)
+	if left != 0.2 || top != 0.05 || right != 0.9 || bottom != 0.9 {
+		t.Errorf("unexpected blocks frame: l=%.3f t=%.3f r=%.3f b=%.3f", left, top, right, bottom)
+	}
+
+	// Center mode stops at the first gap and keeps only one block.
+	_, top, _, bottom = detectFrame(img, Options{Space: 5, Threshold: 0.05, CropFrom: "center"})
+	if top < 0.5 && bottom > 0.5 {
+		t.Errorf("expected center mode to keep a single block, got t=%.3f b=%.3f", top, bottom)
+	}
+}
+
+func TestAnalyzeFrame_ClampsThresholdsInEveryMode(t *testing.T) {
+	img := makeBlocksImage()
+	for _, mode := range []string{"center", "blocks", "border"} {
+		f := analyzeFrame(img, Options{Space: 5, Threshold: 0.0001, CropFrom: mode, CenterMode: CenterMedian})
+		if f.thresholdW < 1 || f.thresholdH < 1 {
+			t.Errorf("%s: thresholds %dx%d, want at least 1", mode, f.thresholdW, f.thresholdH)
+		}
+	}
+}
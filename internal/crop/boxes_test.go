@@ -0,0 +1,124 @@
+package crop
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestParseBoxes(t *testing.T) {
+	boxes, err := ParseBoxes("Trim, bleed,trim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boxes) != 2 || boxes[0] != BoxTrim || boxes[1] != BoxBleed {
+		t.Fatalf("boxes = %v", boxes)
+	}
+	for _, list := range []string{"", "media", "crop,,art"} {
+		if _, err := ParseBoxes(list); err == nil {
+			t.Errorf("%q: expected error", list)
+		}
+	}
+}
+
+func TestPlanBoxes(t *testing.T) {
+	media := types.RectForDim(600, 800)
+	frame := types.NewRectangle(100, 100, 500, 700)
+
+	tests := []struct {
+		name                 string
+		boxes                []string
+		bleed                float64
+		crop, trim, bleedBox *types.Rectangle
+		art                  *types.Rectangle
+		warnings             int
+	}{
+		{name: "default", crop: frame},
+		{name: "trim only", boxes: []string{BoxTrim, BoxArt}, trim: frame, art: frame},
+		{
+			name: "crop follows bleed", boxes: []string{BoxCrop, BoxTrim, BoxBleed}, bleed: 9,
+			crop: types.NewRectangle(91, 91, 509, 709), trim: frame, bleedBox: types.NewRectangle(91, 91, 509, 709),
+		},
+		{
+			name: "bleed cut to media", boxes: []string{BoxBleed}, bleed: 150,
+			bleedBox: media, warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, warnings := planBoxes(media, frame, Options{Boxes: tt.boxes, Bleed: tt.bleed})
+			check := func(name string, got, want *types.Rectangle) {
+				if (got == nil) != (want == nil) || (got != nil && !got.Equals(*want)) {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+			check("crop", b.crop, tt.crop)
+			check("trim", b.trim, tt.trim)
+			check("bleed", b.bleed, tt.bleedBox)
+			check("art", b.art, tt.art)
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %v", warnings)
+			}
+			if err := b.validate(media, media); err != nil {
+				t.Errorf("validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestPageBoxesValidate(t *testing.T) {
+	media := types.RectForDim(600, 800)
+	small := types.NewRectangle(200, 200, 400, 600)
+	frame := types.NewRectangle(100, 100, 500, 700)
+
+	if err := (pageBoxes{crop: types.NewRectangle(-10, 0, 100, 100)}).validate(media, media); !errors.Is(err, ErrBoxNesting) {
+		t.Errorf("crop past media: %v", err)
+	}
+	if err := (pageBoxes{trim: frame}).validate(media, small); !errors.Is(err, ErrBoxNesting) {
+		t.Errorf("trim past the existing crop: %v", err)
+	}
+	if err := (pageBoxes{trim: frame, bleed: small}).validate(media, media); !errors.Is(err, ErrBoxNesting) {
+		t.Errorf("trim past bleed: %v", err)
+	}
+}
+
+func TestCropPages_TrimAndBleedBoxes(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	outPath := filepath.Join(tdir, "boxes.pdf")
+
+	opts := DefaultOptions()
+	opts.Boxes = []string{BoxTrim, BoxBleed, BoxArt}
+	opts.Bleed = 9
+	results, err := CropAllPagesToSingleFile(pdfPath, outPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := results[0].Crop
+
+	ctx, err := api.ReadContextFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := pages[0]
+	if pb.Crop != nil && !pb.Crop.Rect.Equals(*pb.MediaBox()) {
+		t.Errorf("CropBox changed to %v", pb.Crop.Rect)
+	}
+	if !pb.TrimBox().Equals(*frame) || !pb.ArtBox().Equals(*frame) {
+		t.Errorf("TrimBox %v / ArtBox %v, want %v", pb.TrimBox(), pb.ArtBox(), frame)
+	}
+	bleed := pb.BleedBox()
+	if bleed.LL.X != frame.LL.X-9 || bleed.UR.Y != frame.UR.Y+9 {
+		t.Errorf("BleedBox %v, want %v grown by 9", bleed, frame)
+	}
+	if results[0].Bleed == nil || !results[0].Bleed.Equals(*bleed) {
+		t.Errorf("PageResult.Bleed = %v", results[0].Bleed)
+	}
+}
@@ -0,0 +1,61 @@
+package crop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseContent(t *testing.T) {
+	src := []byte("% comment\nq 1 0 0 1 -2.5 .5 cm\n" +
+		"/OC <</MCID 3 /Alt (a\\)b)>> BDC\n" +
+		"BT /F1 12 Tf [(Hel\\154o) -250 <20776f>] TJ ET\n" +
+		"BI /W 2 /H 1 /BPC 8 /CS /G ID \x7fEI EI\n" +
+		"EMC Q")
+	ops, err := parseContent(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, op := range ops {
+		names = append(names, op.op)
+	}
+	if got := strings.Join(names, " "); got != "q cm BDC BT Tf TJ ET BI EMC Q" {
+		t.Fatalf("ops = %s", got)
+	}
+
+	cm := ops[1]
+	if len(cm.operands) != 6 || cm.operands[4].num != -2.5 || cm.operands[5].num != 0.5 {
+		t.Errorf("cm operands = %+v", cm.operands)
+	}
+	if string(src[cm.start:cm.end]) != "1 0 0 1 -2.5 .5 cm" {
+		t.Errorf("cm span = %q", src[cm.start:cm.end])
+	}
+	if bdc := ops[2]; bdc.operands[1].kind != tokDict || len(bdc.operands[1].elems) != 4 {
+		t.Errorf("BDC operands = %+v", bdc.operands)
+	}
+	tj := ops[5].operands[0]
+	if tj.kind != tokArray || len(tj.elems) != 3 {
+		t.Fatalf("TJ operand = %+v", tj)
+	}
+	if string(tj.elems[0].text) != "Hello" || tj.elems[1].num != -250 || string(tj.elems[2].text) != " wo" {
+		t.Errorf("TJ elements = %q %v %q", tj.elems[0].text, tj.elems[1].num, tj.elems[2].text)
+	}
+	if bi := ops[7]; !strings.HasSuffix(string(src[bi.start:bi.end]), "\x7fEI EI") {
+		t.Errorf("inline image span = %q", src[bi.start:bi.end])
+	}
+}
+
+func TestParseContent_Errors(t *testing.T) {
+	for _, src := range []string{
+		"(unterminated Tj",
+		"[1 2",
+		"<12G4> Tj",
+		"1 0 0",
+		"BI /W 1 ID xyz",
+		"} q",
+	} {
+		if _, err := parseContent([]byte(src)); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
@@ -22,6 +22,9 @@ func TestDefaultOptions(t *testing.T) {
 	if opts.CropFrom != "center" {
 		t.Errorf("Expected CropFrom 'center', got %s", opts.CropFrom)
 	}
+	if opts.CenterMode != CenterMedian {
+		t.Errorf("Expected CenterMode %q, got %s", CenterMedian, opts.CenterMode)
+	}
 }
 
 func TestRectFromTopLeft(t *testing.T) {
@@ -113,6 +116,11 @@ func TestRectString(t *testing.T) {
 			rect:     types.NewRectangle(0, 0, 0, 0),
 			expected: "(0, 0), (0, 0)",
 		},
+		{
+			name:     "Fractional rectangle",
+			rect:     types.NewRectangle(10.25, 20.5, 100.125, 200),
+			expected: "(10, 20), (100, 200)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -125,6 +133,13 @@ func TestRectString(t *testing.T) {
 	}
 }
 
+func TestPreciseRectString(t *testing.T) {
+	rect := types.NewRectangle(10.25, 20.5, 100.125, 200)
+	if got, want := preciseRectString(rect), "(10.25, 20.5), (100.13, 200)"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
 func TestRectFromImage(t *testing.T) {
 	// Create a test RGBA image with content
 	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
@@ -524,3 +539,23 @@ func TestPageResult(t *testing.T) {
 		t.Error("Expected non-nil Crop")
 	}
 }
+
+func TestNormalizeOptions_CoarseToFine(t *testing.T) {
+	opts := Options{DPI: 300, CoarseDPI: 50}
+	normalizeOptions(&opts)
+	if opts.DPI != 50 || opts.RefineDPI != 300 {
+		t.Errorf("expected render at 50 DPI and refine at 300, got DPI=%v RefineDPI=%v", opts.DPI, opts.RefineDPI)
+	}
+
+	opts = Options{DPI: 300, CoarseDPI: 50, RefineDPI: 600}
+	normalizeOptions(&opts)
+	if opts.DPI != 50 || opts.RefineDPI != 600 {
+		t.Errorf("expected higher RefineDPI kept, got DPI=%v RefineDPI=%v", opts.DPI, opts.RefineDPI)
+	}
+
+	opts = Options{DPI: 100, CoarseDPI: 200}
+	normalizeOptions(&opts)
+	if opts.DPI != 100 || opts.RefineDPI != 0 {
+		t.Errorf("expected CoarseDPI above DPI to be ignored, got DPI=%v RefineDPI=%v", opts.DPI, opts.RefineDPI)
+	}
+}
@@ -0,0 +1,224 @@
+package crop
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Deskew modes select what happens with the estimated skew of a page.
+const (
+	// DeskewOff skips skew estimation.
+	DeskewOff = ""
+	// DeskewDetect estimates the skew angle and reports it in PageResult.
+	DeskewDetect = "detect"
+	// DeskewCorrect also rotates the page content to straighten it before
+	// the crop is detected.
+	DeskewCorrect = "correct"
+)
+
+const (
+	// maxSkewAngle bounds the search, in degrees, in either direction.
+	maxSkewAngle = 5.0
+	// skewCoarseStep and skewFineStep are the angle increments of the
+	// two search passes, in degrees.
+	skewCoarseStep = 0.5
+	skewFineStep   = 0.05
+	// skewMaxSamples caps the number of pixels scored per angle.
+	skewMaxSamples = 20000
+	// minSkewCorrection is the smallest angle, in degrees, worth rotating.
+	minSkewCorrection = 0.05
+)
+
+// ValidDeskew reports whether mode names a known deskew mode.
+func ValidDeskew(mode string) bool {
+	switch mode {
+	case DeskewOff, DeskewDetect, DeskewCorrect:
+		return true
+	}
+	return false
+}
+
+// estimateSkew returns the angle in degrees by which the content of img is
+// rotated counter-clockwise, using the projection profile method: the row
+// profile is sharpest when projected along the direction of the text lines.
+func estimateSkew(img *image.RGBA) float64 {
+	points := skewSamples(img)
+	if len(points) == 0 {
+		return 0
+	}
+	height := img.Bounds().Dy()
+	width := img.Bounds().Dx()
+	offset := int(math.Ceil(float64(width)*math.Tan(maxSkewAngle*math.Pi/180))) + 1
+	hist := make([]int, height+2*offset)
+
+	score := func(angle float64) int {
+		clear(hist)
+		t := math.Tan(angle * math.Pi / 180)
+		for _, p := range points {
+			hist[int(float64(p.Y)+float64(p.X)*t)+offset]++
+		}
+		s := 0
+		for _, c := range hist {
+			s += c * c
+		}
+		return s
+	}
+
+	best := 0.0
+	bestScore := -1
+	search := func(center, step float64, n int) {
+		for i := -n; i <= n; i++ {
+			angle := center + float64(i)*step
+			if math.Abs(angle) > maxSkewAngle {
+				continue
+			}
+			s := score(angle)
+			if s > bestScore || (s == bestScore && math.Abs(angle) < math.Abs(best)) {
+				best = angle
+				bestScore = s
+			}
+		}
+	}
+	search(0, skewCoarseStep, int(maxSkewAngle/skewCoarseStep))
+	search(best, skewFineStep, int(skewCoarseStep/skewFineStep))
+	return math.Round(best/skewFineStep) * skewFineStep
+}
+
+// skewSamples returns up to skewMaxSamples non-white pixel positions,
+// evenly subsampled in scan order.
+func skewSamples(img *image.RGBA) []image.Point {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	total := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if isNonWhite(img, x, y) {
+				total++
+			}
+		}
+	}
+	step := total/skewMaxSamples + 1
+
+	points := make([]image.Point, 0, total/step+1)
+	n := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !isNonWhite(img, x, y) {
+				continue
+			}
+			if n%step == 0 {
+				points = append(points, image.Point{X: x, Y: y})
+			}
+			n++
+		}
+	}
+	return points
+}
+
+// rotateImage returns a copy of img rotated clockwise by angle degrees about
+// its center, undoing a counter-clockwise skew of the same angle. Pixels
+// that come from outside the source are white.
+func rotateImage(img *image.RGBA, angle float64) *image.RGBA {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range out.Pix {
+		out.Pix[i] = 255
+	}
+
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx := float64(width) / 2
+	cy := float64(height) / 2
+	for y := 0; y < height; y++ {
+		dy := float64(y) + 0.5 - cy
+		for x := 0; x < width; x++ {
+			dx := float64(x) + 0.5 - cx
+			sx := int(math.Floor(cx + dx*cos + dy*sin))
+			sy := int(math.Floor(cy - dx*sin + dy*cos))
+			if sx < 0 || sy < 0 || sx >= width || sy >= height {
+				continue
+			}
+			src := sy*img.Stride + sx*4
+			dst := y*out.Stride + x*4
+			copy(out.Pix[dst:dst+4], img.Pix[src:src+4])
+		}
+	}
+	return out
+}
+
+// deskewPage wraps the page content in a transformation that rotates it
+// clockwise by angle degrees about the center of media, matching rotateImage.
+func deskewPage(ctx *model.Context, pageNumber int, media *types.Rectangle, angle float64) error {
+	d, _, _, err := ctx.PageDict(pageNumber, false)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return fmt.Errorf("page %d not found", pageNumber)
+	}
+
+	bb, err := ctx.PageContent(d, pageNumber)
+	if err == model.ErrNoContent {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sin, cos := math.Sincos(-angle * math.Pi / 180)
+	cx := (media.LL.X + media.UR.X) / 2
+	cy := (media.LL.Y + media.UR.Y) / 2
+	e := cx - cx*cos + cy*sin
+	f := cy - cx*sin - cy*cos
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "q %.5f %.5f %.5f %.5f %.5f %.5f cm ", cos, sin, -sin, cos, e, f)
+	buf.Write(bb)
+	buf.WriteString(" Q ")
+
+	sd, err := ctx.NewStreamDictForBuf(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	ir, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+	d["Contents"] = *ir
+	return nil
+}
+
+// straighten estimates the skew of a rendered page when opts.Deskew asks for
+// it. In correct mode it also rotates the page content and returns the
+// matching straightened raster for detection. Pages with a /Rotate entry are
+// rendered turned, so their skew is only reported, with a warning.
+func straighten(ctx *model.Context, pageNumber int, img *image.RGBA, media *types.Rectangle, opts Options) (*image.RGBA, float64, string, error) {
+	if opts.Deskew == DeskewOff {
+		return img, 0, "", nil
+	}
+	angle := estimateSkew(img)
+	if opts.Deskew != DeskewCorrect || math.Abs(angle) < minSkewCorrection {
+		return img, angle, "", nil
+	}
+	_, _, inh, err := ctx.PageDict(pageNumber, false)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if inh != nil && inh.Rotate%360 != 0 {
+		return img, angle, fmt.Sprintf("page is rotated by %d degrees, skew of %.2f degrees left uncorrected", inh.Rotate, angle), nil
+	}
+	if err := deskewPage(ctx, pageNumber, media, angle); err != nil {
+		return nil, 0, "", err
+	}
+	return rotateImage(img, angle), angle, "", nil
+}
@@ -253,7 +253,7 @@ func TestDetectFrame(t *testing.T) {
 	threshold := 0.1
 	cropFrom := "center"
 
-	left, top, right, bottom := detectFrame(img, space, threshold, cropFrom)
+	left, top, right, bottom := detectFrame(img, Options{Space: space, Threshold: threshold, CropFrom: cropFrom})
 
 	// Verify values are in range [0, 1]
 	if left < 0 || left > 1 {
@@ -292,7 +292,7 @@ func TestDetectFrameBorder(t *testing.T) {
 	threshold := 0.1
 	cropFrom := "border"
 
-	left, top, right, bottom := detectFrame(img, space, threshold, cropFrom)
+	left, top, right, bottom := detectFrame(img, Options{Space: space, Threshold: threshold, CropFrom: cropFrom})
 
 	// Verify values are in range [0, 1]
 	if left < 0 || left > 1 {
@@ -326,7 +326,7 @@ func TestDetectFrameBorder_ThresholdClampToSpace(t *testing.T) {
 	threshold := 0.9 // 90% of size -> should clamp to space
 	cropFrom := "border"
 
-	left, top, right, bottom := detectFrame(img, space, threshold, cropFrom)
+	left, top, right, bottom := detectFrame(img, Options{Space: space, Threshold: threshold, CropFrom: cropFrom})
 	// Fractions should be within bounds and reflect border detection
 	if !(left > 0 && top > 0 && right < 1 && bottom < 1) {
 		t.Errorf("border detection failed with clamp: l=%.2f t=%.2f r=%.2f b=%.2f", left, top, right, bottom)
@@ -341,7 +341,7 @@ func TestDetectFrameEmptyImage(t *testing.T) {
 	threshold := 0.1
 	cropFrom := "center"
 
-	left, top, right, bottom := detectFrame(img, space, threshold, cropFrom)
+	left, top, right, bottom := detectFrame(img, Options{Space: space, Threshold: threshold, CropFrom: cropFrom})
 
 	// Should return zeros for empty image
 	if left != 0 || top != 0 || right != 0 || bottom != 0 {
@@ -353,8 +353,77 @@ func TestDetectFrameEmptyImage(t *testing.T) {
 func TestDetectFrameZeroSizeImage(t *testing.T) {
 	// Zero-size image
 	img := image.NewRGBA(image.Rect(0, 0, 0, 0))
-	left, top, right, bottom := detectFrame(img, 5, 0.1, "center")
+	left, top, right, bottom := detectFrame(img, Options{Space: 5, Threshold: 0.1, CropFrom: "center"})
 	if left != 0 || top != 0 || right != 0 || bottom != 0 {
 		t.Errorf("expected all zeros for zero-size image, got (%f, %f, %f, %f)", left, top, right, bottom)
 	}
 }
+
+// makeRuleAndBodyImage draws a full-width rule near the top and a block of
+// body text lower on the page; the rule is the single densest row.
+func makeRuleAndBodyImage() *image.RGBA {
+	nonWhite := []image.Point{}
+	for x := 0; x < 100; x++ {
+		nonWhite = append(nonWhite, image.Point{X: x, Y: 10})
+	}
+	for y := 50; y < 90; y++ {
+		for x := 30; x < 70; x += 2 {
+			nonWhite = append(nonWhite, image.Point{X: x, Y: y})
+		}
+	}
+	return createTestImage(100, 100, nonWhite)
+}
+
+func TestFindCenter_Modes(t *testing.T) {
+	d := buildDetectData(makeRuleAndBodyImage())
+
+	_, cy := findCenter(d, CenterDensest)
+	if cy != 10 {
+		t.Errorf("densest: expected center on rule at y=10, got %d", cy)
+	}
+
+	for _, mode := range []string{CenterCentroid, CenterMedian, CenterProfile} {
+		cx, cy := findCenter(d, mode)
+		if cy < 50 || cy >= 90 {
+			t.Errorf("%s: expected center Y inside body (50..89), got %d", mode, cy)
+		}
+		if cx < 30 || cx >= 70 {
+			t.Errorf("%s: expected center X inside body (30..69), got %d", mode, cx)
+		}
+	}
+}
+
+func TestDetectFrame_CenterModeSkipsRule(t *testing.T) {
+	img := makeRuleAndBodyImage()
+
+	_, top, _, bottom := detectFrame(img, Options{Space: 1, Threshold: 0.05, CropFrom: "center", CenterMode: CenterMedian})
+	if top < 0.45 || bottom < 0.85 {
+		t.Errorf("median: expected frame around body text, got top=%.2f bottom=%.2f", top, bottom)
+	}
+
+	_, top, _, bottom = detectFrame(img, Options{Space: 1, Threshold: 0.05, CropFrom: "center", CenterMode: CenterDensest})
+	if bottom > 0.2 {
+		t.Errorf("densest: expected frame around the rule only, got top=%.2f bottom=%.2f", top, bottom)
+	}
+}
+
+func TestSnapToContent(t *testing.T) {
+	counts := []int{0, 3, 0, 0, 0, 0, 2, 0}
+	tests := []struct {
+		pos      int
+		expected int
+	}{
+		{1, 1},
+		{2, 1},
+		{4, 6},
+		{7, 6},
+	}
+	for _, tt := range tests {
+		if got := snapToContent(counts, tt.pos); got != tt.expected {
+			t.Errorf("snapToContent(%d) = %d, expected %d", tt.pos, got, tt.expected)
+		}
+	}
+	if got := snapToContent([]int{0, 0}, 1); got != 1 {
+		t.Errorf("expected unchanged position for empty profile, got %d", got)
+	}
+}
@@ -0,0 +1,142 @@
+package crop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+
+	"github.com/gen2brain/go-fitz"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// ErrPageCountMismatch is returned when MuPDF and pdfcpu disagree on the
+// number of pages in a document, which usually means the file is damaged
+// and one of the parsers repaired it differently.
+var ErrPageCountMismatch = errors.New("page count mismatch")
+
+// ErrPageTooLarge is returned, before anything is rendered, for a page or
+// strip that would take more than Options.MaxPixels pixels at the
+// resolution it is rendered at.
+var ErrPageTooLarge = errors.New("page too large to render")
+
+// Document is a PDF loaded once and shared by both engines: MuPDF renders
+// the pages for detection and pdfcpu edits the page boxes.
+type Document struct {
+	data []byte
+	doc  *fitz.Document
+	ctx  *model.Context
+}
+
+// OpenDocument reads the PDF at path and opens it with both engines.
+func OpenDocument(path string) (*Document, error) {
+	return OpenDocumentWithPassword(path, "", "")
+}
+
+// OpenDocumentWithPassword is like OpenDocument for a PDF that may be
+// encrypted; either password opens it if the document accepts it.
+func OpenDocumentWithPassword(path, password, ownerPassword string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewDocumentWithPassword(data, password, ownerPassword)
+}
+
+// NewDocument opens the PDF in data with both engines. The caller must not
+// modify data while the document is open.
+func NewDocument(data []byte) (*Document, error) {
+	return NewDocumentWithPassword(data, "", "")
+}
+
+// NewDocumentWithPassword is like NewDocument for a PDF that may be
+// encrypted. It fails with ErrPassword if neither password opens it.
+func NewDocumentWithPassword(data []byte, password, ownerPassword string) (*Document, error) {
+	ctx, err := readContext(data, password, ownerPassword)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := fitz.NewFromMemory(data)
+	if errors.Is(err, fitz.ErrNeedsPassword) {
+		doc.Close()
+		var plain []byte
+		if plain, err = decryptedCopy(data, password, ownerPassword); err != nil {
+			return nil, err
+		}
+		doc, err = fitz.NewFromMemory(plain)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n := doc.NumPage(); n != ctx.PageCount {
+		doc.Close()
+		return nil, fmt.Errorf("%w: mupdf %d, pdfcpu %d", ErrPageCountMismatch, n, ctx.PageCount)
+	}
+	return &Document{data: data, doc: doc, ctx: ctx}, nil
+}
+
+// NumPage returns the number of pages in the document.
+func (d *Document) NumPage() int {
+	return d.ctx.PageCount
+}
+
+// AutoCrop detects the crop of every page and sets it as the CropBox. It
+// checks ctx between pages and returns its error once it is done.
+func (d *Document) AutoCrop(ctx context.Context, opts Options) ([]PageResult, error) {
+	normalizeOptions(&opts)
+	results := make([]PageResult, 0, d.NumPage())
+	for pageNo := 0; pageNo < d.NumPage(); pageNo++ {
+		if err := ctx.Err(); err != nil {
+			opts.metrics().Failure(FailureCanceled)
+			return nil, err
+		}
+		res, err := cropPage(d, PageOption{Number: pageNo}, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// render rasterizes page pageNo at opts.DPI for detection. MuPDF renders
+// only the page content, so when opts.DetectAnnotations asks for the
+// annotations too they are drawn into a copy of the page first.
+func (d *Document) render(pageNo int, media *types.Rectangle, opts Options) (*image.RGBA, error) {
+	region := pageCropBox(d.ctx, pageNo+1, media)
+	if err := checkPixels(region, opts.DPI, opts.MaxPixels); err != nil {
+		return nil, err
+	}
+	if !opts.DetectAnnotations {
+		return d.doc.ImageDPI(pageNo, opts.DPI)
+	}
+	return renderWithAnnotations(d.ctx, pageNo+1, region, opts.DPI)
+}
+
+// checkPixels fails with ErrPageTooLarge when rendering region at dpi would
+// take more than maxPixels pixels. Zero maxPixels allows any size.
+func checkPixels(region *types.Rectangle, dpi float64, maxPixels int64) error {
+	if maxPixels <= 0 {
+		return nil
+	}
+	pixels := region.Width() * dpi / 72 * region.Height() * dpi / 72
+	if pixels > float64(maxPixels) {
+		return fmt.Errorf("%w: %s at %g DPI needs %.0f pixels, the limit is %d",
+			ErrPageTooLarge, RectString(region), dpi, pixels, maxPixels)
+	}
+	return nil
+}
+
+// Write writes the document, with the crops set so far, to w.
+func (d *Document) Write(w io.Writer) error {
+	return api.WriteContext(d.ctx, w)
+}
+
+// Close releases the MuPDF document.
+func (d *Document) Close() error {
+	return d.doc.Close()
+}
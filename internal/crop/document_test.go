@@ -0,0 +1,82 @@
+package crop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenDocument_SharesPages(t *testing.T) {
+	tdir := t.TempDir()
+	var pngs []string
+	for i := 0; i < 3; i++ {
+		p := filepath.Join(tdir, fmt.Sprintf("p%d.png", i+1))
+		writePNG(t, p, makeTestImage(300, 400))
+		pngs = append(pngs, p)
+	}
+	pdfPath := filepath.Join(tdir, "multi.pdf")
+	createMultiPagePDFViaImport(t, pngs, pdfPath)
+
+	d, err := OpenDocument(pdfPath)
+	if err != nil {
+		t.Fatalf("OpenDocument: %v", err)
+	}
+	defer d.Close()
+	if d.NumPage() != 3 {
+		t.Fatalf("NumPage = %d, want 3", d.NumPage())
+	}
+	if d.doc.NumPage() != d.ctx.PageCount {
+		t.Fatalf("engines disagree: mupdf %d, pdfcpu %d", d.doc.NumPage(), d.ctx.PageCount)
+	}
+}
+
+func TestOpenDocument_Errors(t *testing.T) {
+	tdir := t.TempDir()
+	if _, err := OpenDocument(filepath.Join(tdir, "missing.pdf")); err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+
+	bad := filepath.Join(tdir, "bad.pdf")
+	if err := os.WriteFile(bad, []byte("not a pdf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDocument(bad); err == nil {
+		t.Fatalf("expected error for invalid pdf")
+	}
+	if _, err := NewDocument(nil); err == nil {
+		t.Fatalf("expected error for empty input")
+	}
+}
+
+func TestAutoCrop_MaxPixels(t *testing.T) {
+	data, err := os.ReadFile(writeFixturePDF(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	autoCrop := func(opts Options) error {
+		d, err := NewDocument(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		_, err = d.AutoCrop(context.Background(), opts)
+		return err
+	}
+
+	// The 300x300 pt page takes 300x300 pixels at 72 DPI.
+	opts := Options{DPI: 72, MaxPixels: 300 * 300}
+	if err := autoCrop(opts); err != nil {
+		t.Fatalf("page within the limit: %v", err)
+	}
+	opts.MaxPixels--
+	if err := autoCrop(opts); !errors.Is(err, ErrPageTooLarge) {
+		t.Fatalf("page over the limit: got %v, want ErrPageTooLarge", err)
+	}
+	opts = Options{DPI: 72, RefineDPI: 2400, MaxPixels: 300 * 300}
+	if err := autoCrop(opts); !errors.Is(err, ErrPageTooLarge) {
+		t.Fatalf("refinement strip over the limit: got %v, want ErrPageTooLarge", err)
+	}
+}
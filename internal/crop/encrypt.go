@@ -0,0 +1,160 @@
+package crop
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Encryption policies select how outputs are protected.
+const (
+	// EncryptionKeep writes outputs with the encryption and passwords of
+	// the input, or unencrypted if the input was. This is the default.
+	EncryptionKeep = "keep"
+	// EncryptionDecrypt writes outputs unencrypted.
+	EncryptionDecrypt = "decrypt"
+	// EncryptionEncrypt encrypts outputs with AES-256 under
+	// Options.NewPassword and Options.NewOwnerPassword, whether or not the
+	// input was encrypted. The permissions of an encrypted input are kept.
+	EncryptionEncrypt = "encrypt"
+)
+
+// ErrPassword is returned for an encrypted input that neither
+// Options.Password nor Options.OwnerPassword opens.
+var ErrPassword = errors.New("wrong or missing password")
+
+// ValidEncryption reports whether policy names a known encryption policy.
+// The empty string selects EncryptionKeep.
+func ValidEncryption(policy string) bool {
+	switch policy {
+	case "", EncryptionKeep, EncryptionDecrypt, EncryptionEncrypt:
+		return true
+	}
+	return false
+}
+
+// readConfiguration returns the pdfcpu configuration for reading a
+// document with the given passwords.
+func readConfiguration(password, ownerPassword string) *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW = password
+	conf.OwnerPW = ownerPassword
+	return conf
+}
+
+// readContext reads and validates the PDF in data with pdfcpu, opening it
+// with the given passwords if it is encrypted.
+func readContext(data []byte, password, ownerPassword string) (*model.Context, error) {
+	ctx, err := api.ReadAndValidate(bytes.NewReader(data), readConfiguration(password, ownerPassword))
+	if errors.Is(err, pdfcpu.ErrWrongPassword) {
+		return nil, ErrPassword
+	}
+	return ctx, err
+}
+
+// decryptedCopy returns the PDF in data without its encryption. go-fitz
+// offers no way to authenticate with MuPDF, so documents that need a
+// password to open are rendered from such a copy. The copy is read on its
+// own because writing a context changes it.
+func decryptedCopy(data []byte, password, ownerPassword string) ([]byte, error) {
+	ctx, err := readContext(data, password, ownerPassword)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Cmd = model.DECRYPT
+	var buf bytes.Buffer
+	if err := api.WriteContext(ctx, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// keepEncryption has dst, extracted from src, written with the encryption
+// of src. The file ID is copied too, since the key is derived from it.
+func keepEncryption(src, dst *model.Context) error {
+	if src.Encrypt == nil || src.EncKey == nil {
+		return nil
+	}
+	d, err := src.DereferenceDict(*src.Encrypt)
+	if err != nil {
+		return err
+	}
+	if dst.Encrypt, err = dst.IndRefForNewObject(d.Clone()); err != nil {
+		return err
+	}
+	dst.E = src.E
+	dst.EncKey = src.EncKey
+	dst.AES4Strings = src.AES4Strings
+	dst.AES4Streams = src.AES4Streams
+	dst.AES4EmbeddedStreams = src.AES4EmbeddedStreams
+	if src.ID != nil {
+		dst.ID = src.ID.Clone().(types.Array)
+	}
+	if err := ensureWriteState(dst); err != nil {
+		return err
+	}
+	dst.Read.UsingXRefStreams = src.Read.UsingXRefStreams
+	return nil
+}
+
+// ensureWriteState gives ctx the read and optimization state pdfcpu
+// consults when it writes an encrypted document. A context built from
+// scratch has neither, so empty ones are borrowed from a context for an
+// empty file.
+func ensureWriteState(ctx *model.Context) error {
+	if ctx.Read != nil && ctx.Optimize != nil {
+		return nil
+	}
+	empty, err := model.NewContext(bytes.NewReader(nil), ctx.Configuration)
+	if err != nil {
+		return err
+	}
+	if ctx.Read == nil {
+		ctx.Read = empty.Read
+	}
+	if ctx.Optimize == nil {
+		ctx.Optimize = empty.Optimize
+	}
+	return nil
+}
+
+// applyEncryption prepares ctx to be written as opts.Encryption says.
+// Outputs extracted from a document share its configuration, so ctx is
+// given a copy of its own before it is changed.
+func applyEncryption(ctx *model.Context, opts Options) error {
+	switch opts.Encryption {
+	case EncryptionDecrypt:
+		conf := *ctx.Configuration
+		conf.Cmd = model.DECRYPT
+		ctx.Configuration = &conf
+
+	case EncryptionEncrypt:
+		password, ownerPassword := opts.NewPassword, opts.NewOwnerPassword
+		if password == "" {
+			password = opts.Password
+		}
+		if ownerPassword == "" {
+			ownerPassword = opts.OwnerPassword
+		}
+		if ownerPassword == "" {
+			// Without an owner password anyone who can open the output
+			// could also change its permissions.
+			return fmt.Errorf("encrypting the output needs an owner password")
+		}
+		conf := *ctx.Configuration
+		conf.Cmd = model.ENCRYPT
+		conf.UserPW, conf.OwnerPW = password, ownerPassword
+		conf.EncryptUsingAES, conf.EncryptKeyLength = true, 256
+		if ctx.E != nil {
+			conf.Permissions = model.PermissionFlags(ctx.E.P)
+		}
+		ctx.Configuration = &conf
+		return ensureWriteState(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package crop
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/matrix"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestStripContent(t *testing.T) {
+	target := types.NewRectangle(100, 100, 200, 200)
+	res := contentResources{
+		xobjects: map[string]xobjectExtent{
+			"Im1": {bbox: types.NewRectangle(0, 0, 1, 1), matrix: matrix.IdentMatrix},
+			"Fm1": {form: true, bbox: types.NewRectangle(0, 0, 50, 50), matrix: matrix.IdentMatrix},
+		},
+		opaqueFonts: map[string]bool{"T3": true},
+	}
+
+	tests := []struct {
+		name       string
+		src        string
+		stats      StripStats
+		keep, gone []string
+		unused     []string
+	}{
+		{
+			name:  "paths",
+			src:   "0 0 1 rg 120 120 10 10 re f 10 10 m 50 50 l S q 1 0 0 1 300 0 cm 0 0 20 20 re f Q",
+			stats: StripStats{Paths: 2},
+			keep:  []string{"120 120 10 10 re", "1 0 0 1 300 0 cm", "0 0 1 rg"},
+			gone:  []string{"10 10 m", "50 50 l", "0 0 20 20 re"},
+		},
+		{
+			name: "clip path and wide stroke",
+			src:  "0 0 20 20 re W n 40 w 10 10 m 90 10 l S",
+			keep: []string{"0 0 20 20 re W n", "90 10 l S"},
+		},
+		{
+			name:  "text objects",
+			src:   "BT /F1 10 Tf 12 TL 10 20 Td (hidden) Tj (more) ' ET BT /F1 10 Tf 150 150 Td (shown) Tj 0 -200 Td (kept) Tj ET",
+			stats: StripStats{Text: 2},
+			keep:  []string{"/F1 10 Tf", "12 TL", "10 20 Td", "T*", "(shown) Tj", "(kept) Tj"},
+			gone:  []string{"(hidden)", "(more)"},
+		},
+		{
+			name: "text position follows earlier strings",
+			src:  "BT /F1 10 Tf 60 150 Td (abcd) Tj (efgh) Tj ET",
+			keep: []string{"(abcd) Tj", "(efgh) Tj"},
+		},
+		{
+			name: "clipping text and opaque fonts",
+			src:  "BT /F1 10 Tf 7 Tr 10 20 Td (clip) Tj ET BT /T3 10 Tf 10 20 Td (type3) Tj ET",
+			keep: []string{"(clip) Tj", "(type3) Tj"},
+		},
+		{
+			name:   "xobjects",
+			src:    "q 20 0 0 20 10 10 cm /Im1 Do Q q 1 0 0 1 120 120 cm /Fm1 Do Q q 1 0 0 1 300 300 cm /Fm1 Do /Other Do Q",
+			stats:  StripStats{Images: 1, Forms: 1},
+			keep:   []string{"1 0 0 1 120 120 cm\n/Fm1 Do", "/Other Do"},
+			gone:   []string{"/Im1 Do"},
+			unused: []string{"Im1"},
+		},
+		{
+			name:  "inline image",
+			src:   "q 10 0 0 10 0 0 cm BI /W 1 /H 1 /BPC 8 /CS /G ID \x7f EI Q",
+			stats: StripStats{Images: 1},
+			gone:  []string{"BI"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripContent([]byte(tt.src), target, res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", out.stats, tt.stats)
+			}
+			got := string(out.content)
+			for _, s := range tt.keep {
+				if !strings.Contains(got, s) {
+					t.Errorf("%q removed from:\n%s", s, got)
+				}
+			}
+			for _, s := range tt.gone {
+				if strings.Contains(got, s) {
+					t.Errorf("%q kept in:\n%s", s, got)
+				}
+			}
+			if strings.Join(out.unused, ",") != strings.Join(tt.unused, ",") {
+				t.Errorf("unused = %v, want %v", out.unused, tt.unused)
+			}
+			if tt.stats.Total() == 0 && got != tt.src {
+				t.Errorf("content changed without removals:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestStripContent_LostState(t *testing.T) {
+	src := "/Name cm 10 10 m 20 20 l S"
+	out, err := stripContent([]byte(src), types.NewRectangle(100, 100, 200, 200), contentResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.stats.Total() != 0 {
+		t.Errorf("removed %+v after an unreadable cm", out.stats)
+	}
+}
+
+// writeHardCropFixture adds vector content, text and annotations inside and
+// outside the square (100, 100), (200, 200) to the 300x300 fixture page.
+func writeHardCropFixture(t *testing.T, dir string) string {
+	t.Helper()
+	ctx, err := api.ReadContextFile(writeFixturePDF(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ctx.PageContent(d, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = append(content, []byte("\nq 0 0 1 rg 250 250 20 20 re f Q\n"+
+		"BT /F1 12 Tf 10 20 Td (Hidden) Tj ET\n"+
+		"BT /F1 12 Tf 120 150 Td (Shown) Tj ET\n")...)
+	sd, _ := ctx.NewStreamDictForBuf(content)
+	if err := sd.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	ir, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d["Contents"] = *ir
+
+	resources, err := ctx.DereferenceDict(d["Resources"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	font, err := ctx.IndRefForNewObject(types.Dict{
+		"Type":     types.Name("Font"),
+		"Subtype":  types.Name("Type1"),
+		"BaseFont": types.Name("Helvetica"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources["Font"] = types.Dict{"F1": *font}
+	annot := func(subtype string, rect *types.Rectangle) types.Object {
+		ir, err := ctx.IndRefForNewObject(types.Dict{
+			"Type":     types.Name("Annot"),
+			"Subtype":  types.Name(subtype),
+			"Rect":     rect.Array(),
+			"Contents": types.StringLiteral(subtype),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *ir
+	}
+	d["Annots"] = types.Array{
+		annot("Text", types.NewRectangle(5, 5, 25, 25)),
+		annot("Text", types.NewRectangle(150, 150, 170, 170)),
+		annot("Widget", types.NewRectangle(5, 250, 50, 270)),
+	}
+
+	out := filepath.Join(dir, "hard.pdf")
+	if err := api.WriteContextFile(ctx, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCropPagesToFile_HardCrop(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeHardCropFixture(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	opts := DefaultOptions()
+	opts.HardCrop = true
+	opts.StripHidden = true
+	page := PageOption{Number: 0, Left: 100, Top: 100, Right: 200, Bottom: 200}
+	results, err := CropPagesToFile(pdfPath, outPath, []PageOption{page}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StripStats{Paths: 1, Text: 1, Annotations: 1}
+	if results[0].Stripped != want {
+		t.Errorf("Stripped = %+v, want %+v", results[0].Stripped, want)
+	}
+
+	ctx, err := api.ReadContextFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := types.NewRectangle(100, 100, 200, 200)
+	if !pages[0].MediaBox().Equals(*frame) || !pages[0].CropBox().Equals(*frame) {
+		t.Errorf("MediaBox %v, CropBox %v, want %v", pages[0].MediaBox(), pages[0].CropBox(), frame)
+	}
+	d, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ctx.PageContent(d, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("(Hidden)")) || !bytes.Contains(content, []byte("(Shown)")) {
+		t.Errorf("content after stripping:\n%s", content)
+	}
+	annots, err := ctx.DereferenceArray(d["Annots"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annots) != 2 {
+		t.Errorf("annotations = %d, want the visible one and the widget", len(annots))
+	}
+}
+
+func TestCropPages_HardCropKeepsContent(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeHardCropFixture(t, tdir)
+
+	opts := DefaultOptions()
+	opts.HardCrop = true
+	out := filepath.Join(tdir, "page.pdf")
+	page := PageOption{Number: 0, Left: 100, Top: 100, Right: 200, Bottom: 200, Output: out}
+	results, err := CropPages(pdfPath, []PageOption{page}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = results[0].Output
+	if results[0].Stripped.Total() != 0 {
+		t.Errorf("Stripped = %+v without StripHidden", results[0].Stripped)
+	}
+	ctx, err := api.ReadContextFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ctx.PageContent(d, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(content, []byte("(Hidden)")) {
+		t.Errorf("content changed without StripHidden:\n%s", content)
+	}
+	pages, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media := pages[0].MediaBox(); !media.Equals(*types.NewRectangle(100, 100, 200, 200)) {
+		t.Errorf("MediaBox = %v", media)
+	}
+}
+
+func TestHardCrop_SharedResources(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "p.png")
+	pdfPath := filepath.Join(tdir, "p.pdf")
+	writePNG(t, pngPath, makeTestImage(300, 300))
+	createMultiPagePDFViaImport(t, []string{pngPath, pngPath}, pdfPath)
+	ctx, err := api.ReadContextFile(pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both pages draw the image of page 1 through one Resources object.
+	first, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := ctx.DereferenceDict(first["Resources"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	xobjects, err := ctx.DereferenceDict(resources["XObject"])
+	if err != nil || len(xobjects) != 1 {
+		t.Fatalf("fixture XObjects = %v, %v", xobjects, err)
+	}
+	var name string
+	for name = range xobjects {
+	}
+	shared, err := ctx.IndRefForNewObject(resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setContent := func(d types.Dict, content string) {
+		sd, err := ctx.NewStreamDictForBuf([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sd.Encode(); err != nil {
+			t.Fatal(err)
+		}
+		ir, err := ctx.IndRefForNewObject(*sd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d["Contents"] = *ir
+		d["Resources"] = *shared
+	}
+	second, _, _, err := ctx.PageDict(2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// On page 1 the image lies outside the crop.
+	setContent(first, "q 20 0 0 20 5 5 cm /"+name+" Do Q\n0 0 1 rg 120 120 20 20 re f\n")
+	setContent(second, "q 300 0 0 300 0 0 cm /"+name+" Do Q\n")
+
+	stats, warnings, err := hardCrop(ctx, 1, types.NewRectangle(100, 100, 200, 200), true)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("hardCrop: %v %v", err, warnings)
+	}
+	if stats.Images != 1 {
+		t.Errorf("Stripped = %+v, want the image", stats)
+	}
+	hasImage := func(pageNumber int) bool {
+		_, _, inh, err := ctx.PageDict(pageNumber, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		xobjects, err := ctx.DereferenceDict(inh.Resources["XObject"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := xobjects[name]
+		return ok
+	}
+	if hasImage(1) {
+		t.Error("page 1 still lists the image it no longer draws")
+	}
+	if !hasImage(2) {
+		t.Error("page 2 lost the image it draws")
+	}
+}
+
+func TestCropPagesToFile_HardCropFollowsBoxes(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeHardCropFixture(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	// Only the TrimBox is set, so the CropBox still spans the whole page.
+	opts := DefaultOptions()
+	opts.HardCrop = true
+	opts.Boxes = []string{BoxTrim}
+	page := PageOption{Number: 0, Left: 100, Top: 100, Right: 200, Bottom: 200}
+	if _, err := CropPagesToFile(pdfPath, outPath, []PageOption{page}, opts); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := api.ReadContextFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := types.NewRectangle(100, 100, 200, 200)
+	if !pages[0].MediaBox().Equals(*frame) {
+		t.Errorf("MediaBox %v, want the frame %v", pages[0].MediaBox(), frame)
+	}
+}
@@ -0,0 +1,105 @@
+package crop
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics keeps every event it receives.
+type recordingMetrics struct {
+	mu       sync.Mutex
+	pages    int
+	stages   map[string]int
+	failures map[string]int
+	read     int
+	written  int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{stages: map[string]int{}, failures: map[string]int{}}
+}
+
+func (m *recordingMetrics) PageProcessed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pages++
+}
+
+func (m *recordingMetrics) ObserveStage(stage string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stages[stage]++
+}
+
+func (m *recordingMetrics) Failure(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[kind]++
+}
+
+func (m *recordingMetrics) BytesRead(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.read += n
+}
+
+func (m *recordingMetrics) BytesWritten(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written += n
+}
+
+func TestMetrics_CropAllPages(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	m := newRecordingMetrics()
+	opts := DefaultOptions()
+	opts.Metrics = m
+	if _, err := CropAllPagesToSingleFile(pdfPath, outPath, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	in, _ := os.Stat(pdfPath)
+	out, _ := os.Stat(outPath)
+	if m.pages != 1 {
+		t.Errorf("pages = %d, want 1", m.pages)
+	}
+	for _, stage := range []string{StageRender, StageDetect, StageWrite} {
+		if m.stages[stage] != 1 {
+			t.Errorf("stage %s observed %d times, want 1", stage, m.stages[stage])
+		}
+	}
+	if m.read != int(in.Size()) || m.written != int(out.Size()) {
+		t.Errorf("bytes read/written = %d/%d, want %d/%d", m.read, m.written, in.Size(), out.Size())
+	}
+	if len(m.failures) != 0 {
+		t.Errorf("unexpected failures: %v", m.failures)
+	}
+}
+
+func TestMetrics_Failures(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+
+	m := newRecordingMetrics()
+	opts := DefaultOptions()
+	opts.Metrics = m
+	if _, err := CropPages(filepath.Join(tdir, "missing.pdf"), nil, opts); err == nil {
+		t.Fatal("expected an error for a missing input")
+	}
+	opts.Overwrite = OverwriteNever
+	if err := CropDocument(pdfPath, pdfPath+".out", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOutput(nil, pdfPath, pdfPath+".out", opts); err == nil {
+		t.Fatal("expected ErrOutputExists")
+	}
+	if m.failures[FailureOpen] != 1 || m.failures[FailureWrite] != 1 {
+		t.Errorf("failures = %v", m.failures)
+	}
+}
@@ -0,0 +1,111 @@
+package crop
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Output name templates expand placeholders in braces:
+//
+//	{dir}    output directory: Options.OutputDir, or the input's directory
+//	{name}   input file name without extension ({stem} is an alias)
+//	{ext}    input extension including the dot, e.g. ".pdf"
+//	{page}   0-based page number
+//	{page1}  1-based page number
+//	{date}   current date as YYYY-MM-DD
+//
+// Page placeholders take an optional fmt width, e.g. {page:03} for
+// zero-padded three-digit numbers or {page:2} for space padding. A template
+// that does not start with {dir} is taken relative to the output directory.
+//
+// Without a template, per-page outputs are named "<input> - page NN.pdf".
+// DefaultDocumentTemplate names whole-document outputs as crop_all_pdf
+// always has.
+const DefaultDocumentTemplate = "{dir}/cropped_{name}{ext}"
+
+// noPage is passed as the page number when a template names a whole
+// document.
+const noPage = -1
+
+// ValidTemplate returns an error if template is malformed. With perPage unset
+// the page placeholders are rejected, since there is one output per document.
+func ValidTemplate(template string, perPage bool) error {
+	page := 0
+	if !perPage {
+		page = noPage
+	}
+	_, err := ExpandTemplate(template, "input.pdf", "", page, time.Time{})
+	return err
+}
+
+// ExpandTemplate returns the output path for page pageNo of inputFile. A
+// negative pageNo names a whole-document output; the page placeholders are
+// then an error. An empty outputDir means the directory of inputFile.
+func ExpandTemplate(template, inputFile, outputDir string, pageNo int, now time.Time) (string, error) {
+	if template == "" {
+		return "", fmt.Errorf("empty output template")
+	}
+	if outputDir == "" {
+		outputDir = filepath.Dir(inputFile)
+	}
+	ext := filepath.Ext(inputFile)
+	name := strings.TrimSuffix(filepath.Base(inputFile), ext)
+
+	var out strings.Builder
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			out.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed placeholder in template %q", template)
+		}
+		out.WriteString(rest[:open])
+		field := rest[open+1 : open+end]
+		rest = rest[open+end+1:]
+
+		key, width, _ := strings.Cut(field, ":")
+		if width != "" && strings.Trim(width, "0123456789") != "" {
+			return "", fmt.Errorf("invalid width in {%s}", field)
+		}
+		switch key {
+		case "dir", "name", "stem", "ext", "date":
+			if width != "" {
+				return "", fmt.Errorf("{%s} does not take a width", key)
+			}
+		}
+
+		switch key {
+		case "dir":
+			out.WriteString(outputDir)
+		case "name", "stem":
+			out.WriteString(name)
+		case "ext":
+			out.WriteString(ext)
+		case "date":
+			out.WriteString(now.Format("2006-01-02"))
+		case "page", "page1":
+			if pageNo < 0 {
+				return "", fmt.Errorf("{%s} is not available for whole-document outputs", key)
+			}
+			n := pageNo
+			if key == "page1" {
+				n++
+			}
+			fmt.Fprintf(&out, "%"+width+"d", n)
+		default:
+			return "", fmt.Errorf("unknown placeholder {%s}", field)
+		}
+	}
+
+	path := out.String()
+	if !strings.HasPrefix(template, "{dir}") && !filepath.IsAbs(path) {
+		path = filepath.Join(outputDir, path)
+	}
+	return filepath.Clean(path), nil
+}
@@ -0,0 +1,181 @@
+package crop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Info dictionary entries that hold the provenance of a cropped document.
+// The values are text, so that any PDF viewer can show them as custom
+// document properties.
+const (
+	provenanceVersion   = "PDFCropVersion"
+	provenanceTime      = "PDFCropDate"
+	provenanceMode      = "PDFCropMode"
+	provenanceDPI       = "PDFCropDPI"
+	provenanceThreshold = "PDFCropThreshold"
+	// provenancePages holds a JSON array with one object per page.
+	provenancePages = "PDFCropPages"
+)
+
+// ErrNoProvenance is returned by ReadProvenance for a document without a
+// record of how it was cropped.
+var ErrNoProvenance = errors.New("no crop provenance recorded")
+
+// Provenance records how a document was cropped. Options.Provenance stores
+// it in the Info dictionary of every output; ReadProvenance reads it back.
+type Provenance struct {
+	// Version is the Version of this package that cropped the document.
+	Version string
+	// Time is when the document was cropped, to the second.
+	Time time.Time
+	// Mode is the detection mode, Options.CropFrom, followed by the
+	// Options.CenterMode for "center", as in "center/median".
+	Mode string
+	// DPI is the resolution pages were rendered at for detection.
+	DPI       float64
+	Threshold float64
+	// Pages lists the cropped pages in the order they appear in the
+	// output.
+	Pages []ProvenancePage
+}
+
+// ProvenancePage is the crop applied to one page.
+type ProvenancePage struct {
+	// PageNo is the zero-based page number in the input document.
+	PageNo int
+	Crop   *types.Rectangle
+	// Auto is set when the rectangle was detected rather than given.
+	Auto bool
+}
+
+// provenancePageJSON leaves out the crop of a page that has none.
+type provenancePageJSON struct {
+	Page int         `json:"page"`
+	Crop *[4]float64 `json:"crop,omitempty"`
+	Auto bool        `json:"auto"`
+}
+
+// newProvenance describes results cropped with opts at t.
+func newProvenance(results []PageResult, opts Options, t time.Time) Provenance {
+	normalizeOptions(&opts)
+	mode := opts.CropFrom
+	if mode == "center" {
+		mode += "/" + opts.CenterMode
+	}
+	p := Provenance{
+		Version:   Version,
+		Time:      t.UTC().Truncate(time.Second),
+		Mode:      mode,
+		DPI:       opts.DPI,
+		Threshold: opts.Threshold,
+	}
+	for _, res := range results {
+		p.Pages = append(p.Pages, ProvenancePage{PageNo: res.PageNo, Crop: res.Crop, Auto: res.WasAuto})
+	}
+	return p
+}
+
+// properties returns the Info dictionary entries for p.
+func (p Provenance) properties() (map[string]string, error) {
+	pages := make([]provenancePageJSON, 0, len(p.Pages))
+	for _, page := range p.Pages {
+		entry := provenancePageJSON{Page: page.PageNo, Auto: page.Auto}
+		if page.Crop != nil {
+			entry.Crop = &[4]float64{page.Crop.LL.X, page.Crop.LL.Y, page.Crop.UR.X, page.Crop.UR.Y}
+		}
+		pages = append(pages, entry)
+	}
+	data, err := json.Marshal(pages)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		provenanceVersion:   p.Version,
+		provenanceTime:      p.Time.Format(time.RFC3339),
+		provenanceMode:      p.Mode,
+		provenanceDPI:       strconv.FormatFloat(p.DPI, 'f', -1, 64),
+		provenanceThreshold: strconv.FormatFloat(p.Threshold, 'f', -1, 64),
+		provenancePages:     string(data),
+	}, nil
+}
+
+// addProvenance records in ctx how results were cropped when opts asks
+// for it.
+func addProvenance(ctx *model.Context, results []PageResult, opts Options) error {
+	if !opts.Provenance {
+		return nil
+	}
+	props, err := newProvenance(results, opts, time.Now()).properties()
+	if err != nil {
+		return err
+	}
+	return pdfcpu.PropertiesAdd(ctx, props)
+}
+
+// ReadProvenance returns the provenance recorded in the PDF at path, or
+// ErrNoProvenance if it has none.
+func ReadProvenance(path string) (*Provenance, error) {
+	return ReadProvenanceWithPassword(path, "", "")
+}
+
+// ReadProvenanceWithPassword is like ReadProvenance for a PDF that may be
+// encrypted. It fails with ErrPassword if neither password opens it.
+func ReadProvenanceWithPassword(path, password, ownerPassword string) (*Provenance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := readContext(data, password, ownerPassword)
+	if err != nil {
+		return nil, err
+	}
+	return parseProvenance(ctx.Properties)
+}
+
+// parseProvenance reads a Provenance from Info dictionary entries.
+func parseProvenance(props map[string]string) (*Provenance, error) {
+	version, ok := props[provenanceVersion]
+	if !ok {
+		return nil, ErrNoProvenance
+	}
+	p := &Provenance{Version: version, Mode: props[provenanceMode]}
+	var err error
+	if s := props[provenanceTime]; s != "" {
+		if p.Time, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, fmt.Errorf("%s: %w", provenanceTime, err)
+		}
+	}
+	if s := props[provenanceDPI]; s != "" {
+		if p.DPI, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("%s: %w", provenanceDPI, err)
+		}
+	}
+	if s := props[provenanceThreshold]; s != "" {
+		if p.Threshold, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("%s: %w", provenanceThreshold, err)
+		}
+	}
+	if s := props[provenancePages]; s != "" {
+		var pages []provenancePageJSON
+		if err := json.Unmarshal([]byte(s), &pages); err != nil {
+			return nil, fmt.Errorf("%s: %w", provenancePages, err)
+		}
+		for _, page := range pages {
+			entry := ProvenancePage{PageNo: page.Page, Auto: page.Auto}
+			if page.Crop != nil {
+				entry.Crop = types.NewRectangle(page.Crop[0], page.Crop[1], page.Crop[2], page.Crop[3])
+			}
+			p.Pages = append(p.Pages, entry)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,138 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestFirstContentRowAndCol(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.Set(4, 3, color.Black)
+	img.Set(15, 7, color.Black)
+
+	if got := firstContentRow(img, false); got != 3 {
+		t.Errorf("first row from top: got %d", got)
+	}
+	if got := firstContentRow(img, true); got != 7 {
+		t.Errorf("first row from bottom: got %d", got)
+	}
+	if got := firstContentCol(img, false); got != 4 {
+		t.Errorf("first col from left: got %d", got)
+	}
+	if got := firstContentCol(img, true); got != 15 {
+		t.Errorf("first col from right: got %d", got)
+	}
+
+	blank := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	for i := range blank.Pix {
+		blank.Pix[i] = 255
+	}
+	if firstContentRow(blank, false) != -1 || firstContentCol(blank, true) != -1 {
+		t.Errorf("expected -1 for blank image")
+	}
+}
+
+func TestRoundOutward(t *testing.T) {
+	media := types.NewRectangle(0, 0, 100, 100)
+	got := roundOutward(types.NewRectangle(10.123, 20.987, 30.001, 99.999), media)
+	if got.LL.X != 10.12 || got.LL.Y != 20.98 || got.UR.X != 30.01 || got.UR.Y != 100 {
+		t.Errorf("unexpected rounding: %s", RectString(got))
+	}
+	clamped := roundOutward(types.NewRectangle(-0.5, -0.5, 100.5, 100.5), media)
+	if clamped.LL.X != 0 || clamped.LL.Y != 0 || clamped.UR.X != 100 || clamped.UR.Y != 100 {
+		t.Errorf("expected clamp to media, got %s", RectString(clamped))
+	}
+}
+
+func TestRefineFrame_TightensCoarseDetection(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "refine.png")
+	pdfPath := filepath.Join(tdir, "refine.pdf")
+
+	// Content occupies x 180..420 and y 240..560 of a 600x800 pt page.
+	writePNG(t, pngPath, makeTestImage(600, 800))
+	createPDFViaImport(t, pngPath, pdfPath)
+
+	ctx, err := api.ReadContextFile(pdfPath)
+	if err != nil {
+		t.Fatalf("read ctx: %v", err)
+	}
+	media, _, err := pageMediaBox(ctx, 1)
+	if err != nil {
+		t.Fatalf("mediabox: %v", err)
+	}
+
+	opts := Options{DPI: 36, Threshold: 0.01, Space: 5, CropFrom: "center", RefineDPI: 288}
+	coarse := types.NewRectangle(170, 230, 430, 570)
+	refined, err := refineFrame(ctx, 1, media, coarse, opts)
+	if err != nil {
+		t.Fatalf("refineFrame: %v", err)
+	}
+	want := types.NewRectangle(180, 240, 420, 560)
+	if refined.LL.X > want.LL.X || refined.LL.Y > want.LL.Y || refined.UR.X < want.UR.X || refined.UR.Y < want.UR.Y {
+		t.Errorf("refined frame clips content: got %s want %s", RectString(refined), RectString(want))
+	}
+	if refined.LL.X < want.LL.X-1 || refined.LL.Y < want.LL.Y-1 || refined.UR.X > want.UR.X+1 || refined.UR.Y > want.UR.Y+1 {
+		t.Errorf("refined frame not tight: got %s want %s", RectString(refined), RectString(want))
+	}
+}
+
+// makeTextImage returns a w x h page of text-like content: lines of word
+// blocks of varying width filling all but a narrow margin, so that the
+// detected frame spans most of the page.
+func makeTextImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	marginX, marginY := w/12, h/14
+	for y, line := marginY, 0; y+7 <= h-marginY; y, line = y+12, line+1 {
+		for x, word := marginX, line; x < w-marginX; word++ {
+			end := min(x+12+(word*7)%30, w-marginX)
+			for py := y; py < y+7; py++ {
+				for px := x; px < end; px++ {
+					img.Set(px, py, color.Black)
+				}
+			}
+			x = end + 5
+		}
+	}
+	return img
+}
+
+func TestCropAllPagesToSingleFile_CoarseToFineMatchesSinglePassOnText(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "text.png")
+	pdfPath := filepath.Join(tdir, "text.pdf")
+	writePNG(t, pngPath, makeTextImage(600, 800))
+	createPDFViaImport(t, pngPath, pdfPath)
+
+	// Detection steps Space pixels at a time, so a Space of 1 keeps the
+	// single pass frame exact to the pixel.
+	opts := Options{DPI: 300, Threshold: 0.008, Space: 1, CropFrom: "center"}
+	single, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "single.pdf"), opts)
+	if err != nil {
+		t.Fatalf("single pass: %v", err)
+	}
+	opts.CoarseDPI = 50
+	c2f, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "c2f.pdf"), opts)
+	if err != nil {
+		t.Fatalf("coarse to fine: %v", err)
+	}
+
+	// Two pixels at 300 DPI.
+	const tolerance = 2 * 72.0 / 300
+	s, c := single[0].Crop, c2f[0].Crop
+	if math.Abs(s.LL.X-c.LL.X) > tolerance || math.Abs(s.LL.Y-c.LL.Y) > tolerance || math.Abs(s.UR.X-c.UR.X) > tolerance || math.Abs(s.UR.Y-c.UR.Y) > tolerance {
+		t.Errorf("coarse-to-fine crop %s differs from single pass %s", RectString(c), RectString(s))
+	}
+}
@@ -0,0 +1,5 @@
+package crop
+
+// Version is the library version. Override at build time with:
+// go build -ldflags "-X pdf-crop/internal/crop.Version=vX.Y.Z"
+var Version = "v0.0.1"
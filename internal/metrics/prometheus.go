@@ -0,0 +1,89 @@
+// Package metrics exports the crop pipeline's instrumentation to Prometheus.
+// It implements crop.Metrics so that the crop packages themselves do not
+// depend on the Prometheus client.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus collects crop metrics in its own registry.
+type Prometheus struct {
+	registry *prometheus.Registry
+	pages    prometheus.Counter
+	stages   *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+	bytesIn  prometheus.Counter
+	bytesOut prometheus.Counter
+}
+
+// NewPrometheus returns a collector registered together with the Go runtime
+// and process collectors.
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		pages: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pdf_crop_pages_processed_total",
+			Help: "Pages whose crop was set.",
+		}),
+		stages: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pdf_crop_stage_duration_seconds",
+			Help:    "Time spent rendering, detecting and writing.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+		}, []string{"stage"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pdf_crop_failures_total",
+			Help: "Failed operations by kind.",
+		}, []string{"kind"}),
+		bytesIn: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pdf_crop_input_bytes_total",
+			Help: "Size of the input documents.",
+		}),
+		bytesOut: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pdf_crop_output_bytes_total",
+			Help: "Size of the output documents.",
+		}),
+	}
+	p.registry.MustRegister(
+		p.pages, p.stages, p.failures, p.bytesIn, p.bytesOut,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return p
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+func (p *Prometheus) PageProcessed() { p.pages.Inc() }
+
+func (p *Prometheus) ObserveStage(stage string, d time.Duration) {
+	p.stages.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+func (p *Prometheus) Failure(kind string) { p.failures.WithLabelValues(kind).Inc() }
+
+func (p *Prometheus) BytesRead(n int) { p.bytesIn.Add(float64(n)) }
+
+func (p *Prometheus) BytesWritten(n int) { p.bytesOut.Add(float64(n)) }
+
+// Serve exposes the metrics at /metrics on addr until the returned server is
+// shut down. Listen errors are passed to errorf.
+func (p *Prometheus) Serve(addr string, errorf func(error)) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", p.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errorf(err)
+		}
+	}()
+	return srv
+}
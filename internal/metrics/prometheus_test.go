@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pdf-crop/internal/crop"
+)
+
+var _ crop.Metrics = (*Prometheus)(nil)
+
+func TestPrometheus_Exposition(t *testing.T) {
+	p := NewPrometheus()
+	p.PageProcessed()
+	p.PageProcessed()
+	p.ObserveStage(crop.StageRender, 20*time.Millisecond)
+	p.Failure(crop.FailureOpen)
+	p.BytesRead(1000)
+	p.BytesWritten(400)
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		"pdf_crop_pages_processed_total 2",
+		`pdf_crop_stage_duration_seconds_count{stage="render"} 1`,
+		`pdf_crop_failures_total{kind="open"} 1`,
+		"pdf_crop_input_bytes_total 1000",
+		"pdf_crop_output_bytes_total 400",
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("exposition missing %q", want)
+		}
+	}
+}
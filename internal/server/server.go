@@ -0,0 +1,551 @@
+// Package server exposes cropping over HTTP.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+
+	"pdf-crop/internal/crop"
+)
+
+// Defaults for the zero fields of Config.
+const (
+	DefaultMaxBodyBytes = 64 << 20
+	DefaultTimeout      = 60 * time.Second
+	DefaultMaxDPI       = 1200
+	DefaultMaxPixels    = 150_000_000
+)
+
+// Config limits the work a server accepts.
+type Config struct {
+	// MaxBodyBytes is the largest request body accepted. Larger requests
+	// fail with 413.
+	MaxBodyBytes int64
+	// MaxConcurrent is the number of documents processed at once. Requests
+	// beyond it wait for a slot, before their body is read, until their
+	// timeout, then fail with 503. Defaults to the number of CPUs.
+	MaxConcurrent int
+	// Timeout bounds each request, including the wait for a slot. Requests
+	// that run out of time fail with 504.
+	Timeout time.Duration
+	// MaxDPI caps the dpi, refine_dpi and coarse_dpi a request may ask for,
+	// since the render memory grows with their square. Larger values fail
+	// with 400.
+	MaxDPI float64
+	// MaxPixels caps the pixels of each page render, which take 4 bytes
+	// each, so that a small upload with a huge page cannot exhaust memory.
+	// Pages over it fail with 413 before they are rendered.
+	MaxPixels int64
+	// Options are the crop options requests start from.
+	Options crop.Options
+	// Metrics, when set, receives the pipeline instrumentation together
+	// with the sizes of uploads and responses.
+	Metrics crop.Metrics
+	// MetricsHandler, when set, is served at GET /metrics.
+	MetricsHandler http.Handler
+}
+
+// Server handles POST /crop, POST /detect and GET /healthz.
+type Server struct {
+	cfg     Config
+	metrics crop.Metrics
+	slots   chan struct{}
+	mux     *http.ServeMux
+}
+
+// New returns a server with the given limits.
+func New(cfg Config) *Server {
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = runtime.NumCPU()
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxDPI <= 0 {
+		cfg.MaxDPI = DefaultMaxDPI
+	}
+	if cfg.MaxPixels <= 0 {
+		cfg.MaxPixels = DefaultMaxPixels
+	}
+	cfg.Options.MaxPixels = cfg.MaxPixels
+	var m crop.Metrics = crop.NoMetrics{}
+	if cfg.Metrics != nil {
+		m = cfg.Metrics
+		cfg.Options.Metrics = cfg.Metrics
+	}
+	s := &Server{
+		cfg:     cfg,
+		metrics: m,
+		slots:   make(chan struct{}, cfg.MaxConcurrent),
+		mux:     http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /crop", s.handleCrop)
+	s.mux.HandleFunc("POST /detect", s.handleDetect)
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	if cfg.MetricsHandler != nil {
+		s.mux.Handle("GET /metrics", cfg.MetricsHandler)
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+func (s *Server) handleCrop(w http.ResponseWriter, r *http.Request) {
+	s.process(w, r, func(d *crop.Document, name string, _ []crop.PageResult) {
+		var buf bytes.Buffer
+		start := time.Now()
+		if err := d.Write(&buf); err != nil {
+			s.metrics.Failure(crop.FailureWrite)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.metrics.ObserveStage(crop.StageWrite, time.Since(start))
+		s.metrics.BytesWritten(buf.Len())
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "cropped_" + name}))
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	})
+}
+
+func (s *Server) handleDetect(w http.ResponseWriter, r *http.Request) {
+	s.process(w, r, func(_ *crop.Document, _ string, results []crop.PageResult) {
+		resp := planResponse{Pages: make([]pagePlan, 0, len(results))}
+		for _, res := range results {
+			page := pagePlan{
+				Page:     res.PageNo,
+				Media:    rectArray(res.Media),
+				Crop:     rectArray(res.Crop),
+				Skew:     res.Skew,
+				Warnings: res.Warnings,
+			}
+			if res.Bleed != nil {
+				bleed := rectArray(res.Bleed)
+				page.Bleed = &bleed
+			}
+			if res.Stripped.Total() > 0 {
+				page.Stripped = &strippedCounts{
+					Paths:       res.Stripped.Paths,
+					Text:        res.Stripped.Text,
+					Images:      res.Stripped.Images,
+					Forms:       res.Stripped.Forms,
+					Annotations: res.Stripped.Annotations,
+				}
+			}
+			for _, band := range res.Dropped {
+				page.Dropped = append(page.Dropped, droppedBand{Edge: band.Edge, Rect: rectArray(band.Rect)})
+			}
+			for _, annot := range res.Annotations {
+				page.Annotations = append(page.Annotations, annotationChange{
+					Kind:    annot.Kind,
+					Subtype: annot.Subtype,
+					Rect:    rectArray(annot.Rect),
+					Action:  annot.Action,
+				})
+			}
+			resp.Pages = append(resp.Pages, page)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// process reads the PDF and options from r, crops every page within the
+// request's limits and hands the result to respond.
+func (s *Server) process(w http.ResponseWriter, r *http.Request, respond func(d *crop.Document, name string, results []crop.PageResult)) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.Timeout)
+	defer cancel()
+
+	// The body is buffered only once a slot is free, so at most
+	// MaxConcurrent uploads are held in memory at a time. Reading it counts
+	// against the request's time limit.
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		s.metrics.Failure(crop.FailureCanceled)
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("server busy"))
+		return
+	}
+	release := true
+	defer func() {
+		if release {
+			<-s.slots
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		http.NewResponseController(w).SetReadDeadline(deadline)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)
+	data, name, req, err := readRequest(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		if ctx.Err() != nil {
+			writeError(w, http.StatusRequestTimeout, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts := s.cfg.Options
+	if err := req.apply(&opts, s.cfg.MaxDPI); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.metrics.BytesRead(len(data))
+
+	// MuPDF cannot be interrupted mid-page, so the work runs on its own and
+	// the request returns as soon as the deadline passes. The slot is
+	// released only once the work has actually stopped.
+	type outcome struct {
+		d       *crop.Document
+		results []crop.PageResult
+		err     error
+	}
+	done := make(chan outcome, 1)
+	release = false
+	go func() {
+		defer func() { <-s.slots }()
+		d, err := crop.NewDocument(data)
+		if err != nil {
+			s.metrics.Failure(crop.FailureOpen)
+			done <- outcome{err: err}
+			return
+		}
+		results, err := d.AutoCrop(ctx, opts)
+		if err != nil {
+			d.Close()
+			done <- outcome{err: err}
+			return
+		}
+		done <- outcome{d: d, results: results}
+	}()
+
+	select {
+	case out := <-done:
+		if out.err != nil {
+			if ctx.Err() != nil {
+				writeError(w, http.StatusGatewayTimeout, ctx.Err())
+				return
+			}
+			if errors.Is(out.err, crop.ErrPageTooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, out.err)
+				return
+			}
+			writeError(w, http.StatusUnprocessableEntity, out.err)
+			return
+		}
+		defer out.d.Close()
+		respond(out.d, name, out.results)
+	case <-ctx.Done():
+		go func() {
+			if out := <-done; out.d != nil {
+				out.d.Close()
+			}
+		}()
+		writeError(w, http.StatusGatewayTimeout, ctx.Err())
+	}
+}
+
+// readRequest returns the PDF, its file name and the crop options of r. The
+// PDF is either the raw body or the "file" part of a multipart form, which
+// may carry the options as JSON in an "options" part. Query parameters
+// override both.
+func readRequest(r *http.Request) ([]byte, string, optionsRequest, error) {
+	var req optionsRequest
+	name := "document.pdf"
+	var data []byte
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, "", req, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, "", req, err
+			}
+			switch part.FormName() {
+			case "file":
+				data, err = io.ReadAll(part)
+				if err != nil {
+					return nil, "", req, err
+				}
+				if fn := part.FileName(); fn != "" {
+					name = filepath.Base(fn)
+				}
+			case "options":
+				if err := json.NewDecoder(part).Decode(&req); err != nil {
+					return nil, "", req, fmt.Errorf("invalid options: %w", err)
+				}
+			}
+			part.Close()
+		}
+		if data == nil {
+			return nil, "", req, fmt.Errorf("missing \"file\" part")
+		}
+	} else {
+		var err error
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, "", req, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, "", req, fmt.Errorf("empty document")
+	}
+	if err := req.parseQuery(r); err != nil {
+		return nil, "", req, err
+	}
+	return data, name, req, nil
+}
+
+// optionsRequest holds the crop options a request may set. Nil fields keep
+// the server's defaults.
+type optionsRequest struct {
+	DPI          *float64 `json:"dpi"`
+	Threshold    *float64 `json:"threshold"`
+	Space        *int     `json:"space"`
+	CropFrom     *string  `json:"crop_from"`
+	Center       *string  `json:"center"`
+	MinBlockArea *float64 `json:"min_block_area"`
+	DropHeaders  *bool    `json:"drop_headers"`
+	Deskew       *string  `json:"deskew"`
+	RefineDPI    *float64 `json:"refine_dpi"`
+	CoarseDPI    *float64 `json:"coarse_dpi"`
+	Boxes        *string  `json:"boxes"`
+	Bleed        *float64 `json:"bleed"`
+	HardCrop     *bool    `json:"hard_crop"`
+	StripHidden  *bool    `json:"strip_hidden"`
+	Annotations  *string  `json:"annotations"`
+	DetectAnnots *bool    `json:"detect_annotations"`
+}
+
+// parseQuery sets the options given as query parameters, which use the
+// same names as the JSON fields.
+func (o *optionsRequest) parseQuery(r *http.Request) error {
+	q := r.URL.Query()
+	floats := map[string]**float64{
+		"dpi":            &o.DPI,
+		"threshold":      &o.Threshold,
+		"min_block_area": &o.MinBlockArea,
+		"refine_dpi":     &o.RefineDPI,
+		"coarse_dpi":     &o.CoarseDPI,
+		"bleed":          &o.Bleed,
+	}
+	for key, field := range floats {
+		if !q.Has(key) {
+			continue
+		}
+		v, err := strconv.ParseFloat(q.Get(key), 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*field = &v
+	}
+	strs := map[string]**string{
+		"crop_from":   &o.CropFrom,
+		"center":      &o.Center,
+		"deskew":      &o.Deskew,
+		"boxes":       &o.Boxes,
+		"annotations": &o.Annotations,
+	}
+	for key, field := range strs {
+		if q.Has(key) {
+			v := q.Get(key)
+			*field = &v
+		}
+	}
+	if q.Has("space") {
+		v, err := strconv.Atoi(q.Get("space"))
+		if err != nil {
+			return fmt.Errorf("invalid space: %w", err)
+		}
+		o.Space = &v
+	}
+	bools := map[string]**bool{
+		"drop_headers":       &o.DropHeaders,
+		"hard_crop":          &o.HardCrop,
+		"strip_hidden":       &o.StripHidden,
+		"detect_annotations": &o.DetectAnnots,
+	}
+	for key, field := range bools {
+		if !q.Has(key) {
+			continue
+		}
+		v, err := strconv.ParseBool(q.Get(key))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*field = &v
+	}
+	return nil
+}
+
+// apply validates the requested options and sets them in opts. Resolutions
+// above maxDPI are rejected.
+func (o optionsRequest) apply(opts *crop.Options, maxDPI float64) error {
+	for _, dpi := range []struct {
+		name  string
+		value *float64
+	}{{"dpi", o.DPI}, {"refine_dpi", o.RefineDPI}, {"coarse_dpi", o.CoarseDPI}} {
+		if dpi.value != nil && *dpi.value > maxDPI {
+			return fmt.Errorf("invalid %s: %g exceeds the limit of %g", dpi.name, *dpi.value, maxDPI)
+		}
+	}
+	if o.CropFrom != nil && !crop.ValidCropFrom(*o.CropFrom) {
+		return fmt.Errorf("invalid crop_from: %s", *o.CropFrom)
+	}
+	if o.Center != nil && !crop.ValidCenterMode(*o.Center) {
+		return fmt.Errorf("invalid center: %s", *o.Center)
+	}
+	if o.Deskew != nil && !crop.ValidDeskew(*o.Deskew) {
+		return fmt.Errorf("invalid deskew: %s", *o.Deskew)
+	}
+	if o.Boxes != nil {
+		boxes, err := crop.ParseBoxes(*o.Boxes)
+		if err != nil {
+			return fmt.Errorf("invalid boxes: %w", err)
+		}
+		opts.Boxes = boxes
+	}
+	if o.Annotations != nil {
+		policies, err := crop.ParseAnnotationPolicies(*o.Annotations)
+		if err != nil {
+			return fmt.Errorf("invalid annotations: %w", err)
+		}
+		opts.Annotations = policies
+	}
+	if o.Bleed != nil && *o.Bleed < 0 {
+		return fmt.Errorf("invalid bleed: %g", *o.Bleed)
+	}
+	setFloat(&opts.Bleed, o.Bleed)
+	setFloat(&opts.DPI, o.DPI)
+	setFloat(&opts.Threshold, o.Threshold)
+	setFloat(&opts.MinBlockArea, o.MinBlockArea)
+	setFloat(&opts.RefineDPI, o.RefineDPI)
+	setFloat(&opts.CoarseDPI, o.CoarseDPI)
+	if o.Space != nil {
+		opts.Space = *o.Space
+	}
+	if o.CropFrom != nil {
+		opts.CropFrom = *o.CropFrom
+	}
+	if o.Center != nil {
+		opts.CenterMode = *o.Center
+	}
+	if o.Deskew != nil {
+		opts.Deskew = *o.Deskew
+	}
+	if o.DropHeaders != nil {
+		opts.DropHeaders = *o.DropHeaders
+	}
+	if o.HardCrop != nil {
+		opts.HardCrop = *o.HardCrop
+	}
+	if o.StripHidden != nil {
+		opts.StripHidden = *o.StripHidden
+	}
+	if o.DetectAnnots != nil {
+		opts.DetectAnnotations = *o.DetectAnnots
+	}
+	if opts.StripHidden && !opts.HardCrop {
+		return fmt.Errorf("strip_hidden requires hard_crop")
+	}
+	return nil
+}
+
+func setFloat(dst *float64, v *float64) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// planResponse is the body of a /detect response. Rectangles are
+// [llx, lly, urx, ury] in PDF points.
+type planResponse struct {
+	Pages []pagePlan `json:"pages"`
+}
+
+type pagePlan struct {
+	Page        int                `json:"page"`
+	Media       [4]float64         `json:"media"`
+	Crop        [4]float64         `json:"crop"`
+	Skew        float64            `json:"skew,omitempty"`
+	Bleed       *[4]float64        `json:"bleed,omitempty"`
+	Dropped     []droppedBand      `json:"dropped,omitempty"`
+	Stripped    *strippedCounts    `json:"stripped,omitempty"`
+	Annotations []annotationChange `json:"annotations,omitempty"`
+	Warnings    []string           `json:"warnings,omitempty"`
+}
+
+// strippedCounts reports what hard cropping with strip_hidden removed.
+type strippedCounts struct {
+	Paths       int `json:"paths"`
+	Text        int `json:"text"`
+	Images      int `json:"images"`
+	Forms       int `json:"forms"`
+	Annotations int `json:"annotations"`
+}
+
+// annotationChange reports an annotation the annotations policy removed or
+// clipped.
+type annotationChange struct {
+	Kind    string     `json:"kind"`
+	Subtype string     `json:"subtype"`
+	Rect    [4]float64 `json:"rect"`
+	Action  string     `json:"action"`
+}
+
+type droppedBand struct {
+	Edge string     `json:"edge"`
+	Rect [4]float64 `json:"rect"`
+}
+
+func rectArray(r *types.Rectangle) [4]float64 {
+	if r == nil {
+		return [4]float64{}
+	}
+	return [4]float64{r.LL.X, r.LL.Y, r.UR.X, r.UR.Y}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
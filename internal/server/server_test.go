@@ -0,0 +1,311 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+
+	"pdf-crop/internal/crop"
+	"pdf-crop/internal/metrics"
+)
+
+// fixturePDF returns a one-page PDF with a black square in the middle of a
+// white 300x300 page.
+func fixturePDF(t *testing.T) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 300, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.RGBA{255, 255, 255, 255}
+			if x >= 100 && x < 200 && y >= 100 && y < 200 {
+				c = color.RGBA{0, 0, 0, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	pngPath := filepath.Join(dir, "f.png")
+	f, err := os.Create(pngPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	pdfPath := filepath.Join(dir, "f.pdf")
+	imp, err := api.Import("", types.POINTS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ImportImagesFile([]string{pngPath}, pdfPath, imp, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	if cfg.Options.DPI == 0 {
+		cfg.Options = crop.DefaultOptions()
+	}
+	ts := httptest.NewServer(New(cfg))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestHealthz(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	resp, err := http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+}
+
+func TestCrop_RawBody(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	resp, err := http.Post(ts.URL+"/crop?space=0", "application/pdf", bytes.NewReader(fixturePDF(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	d, err := crop.NewDocument(buf.Bytes())
+	if err != nil {
+		t.Fatalf("response is not a PDF: %v", err)
+	}
+	defer d.Close()
+	if d.NumPage() != 1 {
+		t.Fatalf("pages = %d", d.NumPage())
+	}
+}
+
+func TestDetect_MultipartWithOptions(t *testing.T) {
+	ts := newTestServer(t, Config{})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "scan.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(fixturePDF(t))
+	mw.WriteField("options", `{"space": 0, "crop_from": "border"}`)
+	mw.Close()
+
+	resp, err := http.Post(ts.URL+"/detect", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var plan planResponse
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Pages) != 1 {
+		t.Fatalf("pages = %d", len(plan.Pages))
+	}
+	page := plan.Pages[0]
+	if page.Media != [4]float64{0, 0, 300, 300} {
+		t.Fatalf("media = %v", page.Media)
+	}
+	for i, want := range []float64{100, 100, 200, 200} {
+		if d := page.Crop[i] - want; d < -3 || d > 3 {
+			t.Fatalf("crop = %v, want about %v", page.Crop, []float64{100, 100, 200, 200})
+		}
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	pdf := fixturePDF(t)
+	tests := []struct {
+		name   string
+		cfg    Config
+		method string
+		path   string
+		body   []byte
+		busy   bool
+		status int
+	}{
+		{name: "bad option", path: "/crop?crop_from=nowhere", body: pdf, status: http.StatusBadRequest},
+		{name: "bad number", path: "/detect?dpi=high", body: pdf, status: http.StatusBadRequest},
+		{name: "bad annotations", path: "/detect?annotations=hide", body: pdf, status: http.StatusBadRequest},
+		{name: "dpi too high", path: "/detect?dpi=5000", body: pdf, status: http.StatusBadRequest},
+		{name: "refine dpi too high", cfg: Config{MaxDPI: 300}, path: "/detect?refine_dpi=600", body: pdf, status: http.StatusBadRequest},
+		{name: "empty body", path: "/crop", status: http.StatusBadRequest},
+		{name: "not a pdf", path: "/crop", body: []byte("hello"), status: http.StatusUnprocessableEntity},
+		{name: "too large", cfg: Config{MaxBodyBytes: 100}, path: "/crop", body: pdf, status: http.StatusRequestEntityTooLarge},
+		{name: "too many pixels", cfg: Config{MaxPixels: 1000}, path: "/detect", body: pdf, status: http.StatusRequestEntityTooLarge},
+		{name: "wrong method", method: http.MethodGet, path: "/crop", status: http.StatusMethodNotAllowed},
+		{name: "busy", cfg: Config{MaxConcurrent: 1, Timeout: 50 * time.Millisecond}, path: "/crop", body: pdf, busy: true, status: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Options = crop.DefaultOptions()
+			s := New(tt.cfg)
+			if tt.busy {
+				s.slots <- struct{}{}
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, tt.path, bytes.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := New(Config{Timeout: time.Nanosecond, Options: crop.DefaultOptions()})
+	req := httptest.NewRequest(http.MethodPost, "/crop", bytes.NewReader(fixturePDF(t)))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGatewayTimeout && rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want a timeout", rec.Code)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	prom := metrics.NewPrometheus()
+	ts := newTestServer(t, Config{Metrics: prom, MetricsHandler: prom.Handler()})
+
+	resp, err := http.Post(ts.URL+"/crop", "application/pdf", bytes.NewReader(fixturePDF(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(ts.URL+"/crop", "application/pdf", strings.NewReader("not a pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	for _, want := range []string{
+		"pdf_crop_pages_processed_total 1",
+		`pdf_crop_stage_duration_seconds_count{stage="write"} 1`,
+		`pdf_crop_failures_total{kind="open"} 1`,
+	} {
+		if !strings.Contains(body.String(), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+// unreadBody fails the test if the server reads the request body.
+type unreadBody struct{ t *testing.T }
+
+func (b unreadBody) Read(p []byte) (int, error) {
+	b.t.Error("body read while waiting for a slot")
+	return 0, io.EOF
+}
+
+func TestBusy_BodyNotBuffered(t *testing.T) {
+	s := New(Config{MaxConcurrent: 1, Timeout: 50 * time.Millisecond, Options: crop.DefaultOptions()})
+	s.slots <- struct{}{}
+	req := httptest.NewRequest(http.MethodPost, "/crop", unreadBody{t})
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// slowBody sends its data only after delay.
+type slowBody struct {
+	data  *bytes.Reader
+	delay time.Duration
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	time.Sleep(b.delay)
+	b.delay = 0
+	return b.data.Read(p)
+}
+
+func TestSlowUpload_TimesOut(t *testing.T) {
+	ts := newTestServer(t, Config{Timeout: 100 * time.Millisecond})
+	body := &slowBody{data: bytes.NewReader(fixturePDF(t)), delay: time.Second}
+	resp, err := http.Post(ts.URL+"/crop", "application/pdf", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusRequestTimeout)
+	}
+}
+
+func TestHugePage_Rejected(t *testing.T) {
+	// A small upload whose page is 200 inches on a side: 207 million pixels
+	// even at 72 DPI.
+	ctx, err := api.ReadAndValidate(bytes.NewReader(fixturePDF(t)), model.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page["MediaBox"] = types.NewRectangle(0, 0, 14400, 14400).Array()
+	var huge bytes.Buffer
+	if err := api.WriteContext(ctx, &huge); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServer(t, Config{})
+	resp, err := http.Post(ts.URL+"/detect?dpi=72", "application/pdf", &huge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,534 @@
+package crop
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/matrix"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Annotation policies select what happens to annotations that the crop
+// leaves partly or entirely outside the CropBox.
+const (
+	// AnnotationsKeep leaves annotations as they are. This is the default.
+	AnnotationsKeep = "keep"
+	// AnnotationsDrop removes annotations that lie entirely outside the
+	// CropBox.
+	AnnotationsDrop = "drop"
+	// AnnotationsClip removes annotations that lie entirely outside the
+	// CropBox and cuts the rectangle and appearance of those that cross
+	// its edge back to it.
+	AnnotationsClip = "clip"
+)
+
+// Annotation kinds, each of which has its own policy.
+const (
+	// AnnotationLinks are link annotations.
+	AnnotationLinks = "links"
+	// AnnotationWidgets are form field widgets. Removing one also removes
+	// it from the form.
+	AnnotationWidgets = "widgets"
+	// AnnotationComments are all other annotations: notes, highlights,
+	// stamps, drawings and so on. Popups follow the annotation they
+	// belong to.
+	AnnotationComments = "comments"
+)
+
+// Actions reported in AnnotationChange.
+const (
+	AnnotationDropped = "dropped"
+	AnnotationClipped = "clipped"
+)
+
+// AnnotationPolicies holds the policy for each kind of annotation. Empty
+// policies keep the annotations of that kind.
+type AnnotationPolicies struct {
+	Links    string
+	Widgets  string
+	Comments string
+}
+
+// ParseAnnotationPolicies parses a comma-separated list of policies such as
+// "drop" or "clip,widgets=keep". A bare policy applies to every kind and
+// kind=policy to one kind; later entries override earlier ones.
+func ParseAnnotationPolicies(list string) (AnnotationPolicies, error) {
+	var p AnnotationPolicies
+	for _, entry := range strings.Split(list, ",") {
+		kind, policy, perKind := strings.Cut(strings.TrimSpace(strings.ToLower(entry)), "=")
+		if !perKind {
+			kind, policy = "", kind
+		}
+		switch policy {
+		case AnnotationsKeep, AnnotationsDrop, AnnotationsClip:
+		default:
+			return AnnotationPolicies{}, fmt.Errorf("unknown annotation policy %q", policy)
+		}
+		switch kind {
+		case "":
+			p = AnnotationPolicies{Links: policy, Widgets: policy, Comments: policy}
+		case AnnotationLinks:
+			p.Links = policy
+		case AnnotationWidgets:
+			p.Widgets = policy
+		case AnnotationComments:
+			p.Comments = policy
+		default:
+			return AnnotationPolicies{}, fmt.Errorf("unknown annotation kind %q", kind)
+		}
+	}
+	return p, nil
+}
+
+// policy returns the policy for annotations of kind.
+func (p AnnotationPolicies) policy(kind string) string {
+	switch kind {
+	case AnnotationLinks:
+		return p.Links
+	case AnnotationWidgets:
+		return p.Widgets
+	}
+	return p.Comments
+}
+
+// active reports whether any kind of annotation may be changed.
+func (p AnnotationPolicies) active() bool {
+	for _, policy := range []string{p.Links, p.Widgets, p.Comments} {
+		if policy != "" && policy != AnnotationsKeep {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnotationChange records an annotation that a policy removed or clipped.
+type AnnotationChange struct {
+	// Kind is AnnotationLinks, AnnotationWidgets or AnnotationComments.
+	Kind string
+	// Subtype is the annotation subtype, such as Link, Widget or Text.
+	Subtype string
+	// Rect is the annotation rectangle before the change.
+	Rect *types.Rectangle
+	// Action is AnnotationDropped or AnnotationClipped.
+	Action string
+}
+
+// annotationKind returns the kind of an annotation of subtype.
+func annotationKind(subtype string) string {
+	switch subtype {
+	case "Link":
+		return AnnotationLinks
+	case "Widget":
+		return AnnotationWidgets
+	}
+	return AnnotationComments
+}
+
+// applyAnnotationPolicies applies policies to the annotations of a page
+// whose CropBox is target. Annotations whose appearance cannot be clipped
+// are left as they are and reported as warnings.
+func applyAnnotationPolicies(ctx *model.Context, pageNumber int, target *types.Rectangle, policies AnnotationPolicies) ([]AnnotationChange, []string, error) {
+	d, _, _, err := ctx.PageDict(pageNumber, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d == nil {
+		return nil, nil, fmt.Errorf("page %d not found", pageNumber)
+	}
+	obj, found := d.Find("Annots")
+	if !found {
+		return nil, nil, nil
+	}
+	annots, err := ctx.DereferenceArray(obj)
+	if err != nil || len(annots) == 0 {
+		return nil, nil, err
+	}
+
+	var changes []AnnotationChange
+	var warnings []string
+	dropped := make([]bool, len(annots))
+	for i, entry := range annots {
+		annot, err := ctx.DereferenceDict(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		if annot == nil {
+			continue
+		}
+		subtype := ""
+		if s := annot.NameEntry("Subtype"); s != nil {
+			subtype = *s
+		}
+		kind := annotationKind(subtype)
+		policy := policies.policy(kind)
+		// Popups are removed with the annotation they belong to.
+		if policy == "" || policy == AnnotationsKeep || subtype == "Popup" {
+			continue
+		}
+		rectObj, found := annot.Find("Rect")
+		if !found {
+			continue
+		}
+		rect, err := dictRect(ctx, rectObj)
+		if err != nil || containsRect(target, rect) {
+			continue
+		}
+
+		change := AnnotationChange{Kind: kind, Subtype: subtype, Rect: rect}
+		if !overlaps(rect, target) {
+			if kind == AnnotationWidgets {
+				ir, ok := entry.(types.IndirectRef)
+				if !ok {
+					continue
+				}
+				if err := removeField(ctx, ir, annot); err != nil {
+					return nil, nil, fmt.Errorf("form field: %w", err)
+				}
+			}
+			dropped[i] = true
+			change.Action = AnnotationDropped
+			changes = append(changes, change)
+			continue
+		}
+		if policy != AnnotationsClip {
+			continue
+		}
+		clipped := intersectRect(rect, target)
+		if clipped == nil {
+			continue
+		}
+		ok, err := clipAnnotation(ctx, annot, rect, clipped)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s annotation at %s not clipped: its appearance is rotated", subtype, preciseRectString(rect)))
+			continue
+		}
+		change.Action = AnnotationClipped
+		changes = append(changes, change)
+	}
+	removeAnnotations(ctx, d, annots, dropped)
+	return changes, warnings, nil
+}
+
+// removeField removes the widget annotation ir from the form. A field left
+// without widgets is removed too, and so on up the field tree.
+func removeField(ctx *model.Context, ir types.IndirectRef, widget types.Dict) error {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	form, err := ctx.DereferenceDict(root["AcroForm"])
+	if err != nil || form == nil {
+		return err
+	}
+	for {
+		if _, err := removeRef(ctx, form, "CO", ir); err != nil {
+			return err
+		}
+		parentRef, ok := widget["Parent"].(types.IndirectRef)
+		if !ok {
+			_, err := removeRef(ctx, form, "Fields", ir)
+			return err
+		}
+		parent, err := ctx.DereferenceDict(parentRef)
+		if err != nil || parent == nil {
+			return err
+		}
+		left, err := removeRef(ctx, parent, "Kids", ir)
+		if err != nil || left > 0 {
+			return err
+		}
+		ir, widget = parentRef, parent
+	}
+}
+
+// removeRef removes ir from the array under key in d, which may be an
+// indirect object, and returns how many entries are left.
+func removeRef(ctx *model.Context, d types.Dict, key string, ir types.IndirectRef) (int, error) {
+	obj, found := d.Find(key)
+	if !found {
+		return 0, nil
+	}
+	a, err := ctx.DereferenceArray(obj)
+	if err != nil {
+		return 0, err
+	}
+	kept := make(types.Array, 0, len(a))
+	for _, o := range a {
+		if r, ok := o.(types.IndirectRef); ok && r.ObjectNumber == ir.ObjectNumber {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	if len(kept) == len(a) {
+		return len(kept), nil
+	}
+	if r, ok := obj.(types.IndirectRef); ok {
+		entry, found := ctx.FindTableEntryForIndRef(&r)
+		if !found {
+			return 0, fmt.Errorf("%s: object %d not found", key, r.ObjectNumber)
+		}
+		entry.Object = kept
+	} else {
+		d[key] = kept
+	}
+	return len(kept), nil
+}
+
+// clipAnnotation cuts the rectangle of annot from rect to clipped, and its
+// appearances with it, so that what remains is drawn where it was. It
+// reports false, leaving annot unchanged, when an appearance is rotated
+// by other than a multiple of 90 degrees.
+func clipAnnotation(ctx *model.Context, annot types.Dict, rect, clipped *types.Rectangle) (bool, error) {
+	ap, err := ctx.DereferenceDict(annot["AP"])
+	if err != nil {
+		return false, err
+	}
+	var appearances types.Dict
+	if ap != nil {
+		appearances = types.Dict{}
+		for key, obj := range ap {
+			clippedObj, ok, err := clipAppearance(ctx, obj, rect, clipped)
+			if err != nil || !ok {
+				return false, err
+			}
+			appearances[key] = clippedObj
+		}
+	}
+	if appearances != nil {
+		// The appearance dictionary may be shared with other annotations,
+		// so this one gets its own.
+		annot["AP"] = appearances
+	}
+	annot["Rect"] = clipped.Array()
+	return true, nil
+}
+
+// clipAppearance returns a copy of the appearance stream obj, or of each
+// stream in a dictionary of appearance states, whose BBox is cut back to
+// what is drawn in clipped once the annotation rectangle is.
+func clipAppearance(ctx *model.Context, obj types.Object, rect, clipped *types.Rectangle) (types.Object, bool, error) {
+	o, err := ctx.Dereference(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	switch o := o.(type) {
+	case types.Dict:
+		states := types.Dict{}
+		for name, state := range o {
+			clippedState, ok, err := clipAppearance(ctx, state, rect, clipped)
+			if err != nil || !ok {
+				return nil, ok, err
+			}
+			states[name] = clippedState
+		}
+		return states, true, nil
+
+	case types.StreamDict:
+		bboxObj, found := o.Dict.Find("BBox")
+		if !found {
+			return obj, true, nil
+		}
+		bbox, err := dictRect(ctx, bboxObj)
+		if err != nil {
+			return nil, false, err
+		}
+		m := matrix.IdentMatrix
+		if mObj, found := o.Dict.Find("Matrix"); found {
+			if m, err = dictMatrix(ctx, mObj); err != nil {
+				return nil, false, err
+			}
+		}
+		inverse, ok := invertAxisAligned(m)
+		if !ok {
+			return nil, false, nil
+		}
+		// The appearance is drawn by mapping its transformed BBox onto the
+		// annotation rectangle, so the part of it that lands in clipped is
+		// found by mapping back.
+		var a deviceBox
+		a.addRect(bbox.LL.X, bbox.LL.Y, bbox.UR.X, bbox.UR.Y, m)
+		if a.urx-a.llx <= 0 || a.ury-a.lly <= 0 {
+			return obj, true, nil
+		}
+		sx := rect.Width() / (a.urx - a.llx)
+		sy := rect.Height() / (a.ury - a.lly)
+		var region deviceBox
+		region.addRect(
+			a.llx+(clipped.LL.X-rect.LL.X)/sx,
+			a.lly+(clipped.LL.Y-rect.LL.Y)/sy,
+			a.llx+(clipped.UR.X-rect.LL.X)/sx,
+			a.lly+(clipped.UR.Y-rect.LL.Y)/sy,
+			inverse)
+
+		sd := o.Clone().(types.StreamDict)
+		sd.Dict["BBox"] = types.NewRectangle(region.llx, region.lly, region.urx, region.ury).Array()
+		ir, err := ctx.IndRefForNewObject(sd)
+		if err != nil {
+			return nil, false, err
+		}
+		return *ir, true, nil
+	}
+	return obj, true, nil
+}
+
+// invertAxisAligned returns the inverse of m if m maps rectangles onto
+// rectangles, that is if it rotates by a multiple of 90 degrees at most.
+func invertAxisAligned(m matrix.Matrix) (matrix.Matrix, bool) {
+	a, b, c, d, e, f := m[0][0], m[0][1], m[1][0], m[1][1], m[2][0], m[2][1]
+	det := a*d - b*c
+	if det == 0 || (b != 0 || c != 0) && (a != 0 || d != 0) {
+		return matrix.Matrix{}, false
+	}
+	return newMatrix([]float64{d / det, -b / det, -c / det, a / det, (c*f - d*e) / det, (b*e - a*f) / det}), true
+}
+
+// drawAnnotations adds the normal appearances of the visible annotations
+// of a page to its content, so that rendering the page shows them. It is
+// meant for throwaway copies: MuPDF, as used here, renders only the page
+// content.
+func drawAnnotations(ctx *model.Context, pageNumber int) error {
+	d, _, inhPAttrs, err := ctx.PageDict(pageNumber, false)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return fmt.Errorf("page %d not found", pageNumber)
+	}
+	annots, err := ctx.DereferenceArray(d["Annots"])
+	if err != nil || len(annots) == 0 {
+		return err
+	}
+
+	var buf bytes.Buffer
+	xobjects := types.Dict{}
+	for i, entry := range annots {
+		annot, err := ctx.DereferenceDict(entry)
+		if err != nil {
+			return err
+		}
+		ir, sd := normalAppearance(ctx, annot)
+		if sd == nil {
+			continue
+		}
+		rect, err := dictRect(ctx, annot["Rect"])
+		if err != nil {
+			continue
+		}
+		bbox, err := dictRect(ctx, sd.Dict["BBox"])
+		if err != nil {
+			continue
+		}
+		m := matrix.IdentMatrix
+		if mObj, found := sd.Dict.Find("Matrix"); found {
+			if m, err = dictMatrix(ctx, mObj); err != nil {
+				continue
+			}
+		}
+		var a deviceBox
+		a.addRect(bbox.LL.X, bbox.LL.Y, bbox.UR.X, bbox.UR.Y, m)
+		if a.urx-a.llx <= 0 || a.ury-a.lly <= 0 {
+			continue
+		}
+		sx := rect.Width() / (a.urx - a.llx)
+		sy := rect.Height() / (a.ury - a.lly)
+		name := fmt.Sprintf("PdfCropAnnot%d", i)
+		xobjects[name] = ir
+		fmt.Fprintf(&buf, "q %.5f 0 0 %.5f %.5f %.5f cm /%s Do Q\n", sx, sy, rect.LL.X-a.llx*sx, rect.LL.Y-a.lly*sy, name)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	content, err := ctx.PageContent(d, pageNumber)
+	if err != nil && err != model.ErrNoContent {
+		return err
+	}
+	var page bytes.Buffer
+	page.WriteString("q\n")
+	page.Write(content)
+	page.WriteString("\nQ\n")
+	page.Write(buf.Bytes())
+	sd, err := ctx.NewStreamDictForBuf(page.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	ir, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+	d["Contents"] = *ir
+
+	resources := inhPAttrs.Resources
+	if resources == nil {
+		resources = types.Dict{}
+	}
+	if existing, err := ctx.DereferenceDict(resources["XObject"]); err == nil && existing != nil {
+		for name, obj := range existing {
+			xobjects[name] = obj
+		}
+	}
+	resources["XObject"] = xobjects
+	d["Resources"] = resources
+	return nil
+}
+
+// Annotation flags that keep an annotation from being shown.
+const (
+	annotFlagHidden = 1 << 1
+	annotFlagNoView = 1 << 5
+)
+
+// normalAppearance returns the normal appearance stream of a visible
+// annotation, picking the current state for annotations that have
+// several, or nil if there is none to draw.
+func normalAppearance(ctx *model.Context, annot types.Dict) (types.IndirectRef, *types.StreamDict) {
+	if annot == nil {
+		return types.IndirectRef{}, nil
+	}
+	if s := annot.NameEntry("Subtype"); s != nil && *s == "Popup" {
+		return types.IndirectRef{}, nil
+	}
+	if f := annot.IntEntry("F"); f != nil && *f&(annotFlagHidden|annotFlagNoView) != 0 {
+		return types.IndirectRef{}, nil
+	}
+	ap, err := ctx.DereferenceDict(annot["AP"])
+	if err != nil || ap == nil {
+		return types.IndirectRef{}, nil
+	}
+	n := ap["N"]
+	if states, err := ctx.DereferenceDict(n); err == nil && states != nil {
+		as := annot.NameEntry("AS")
+		if as == nil {
+			return types.IndirectRef{}, nil
+		}
+		n = states[*as]
+	}
+	ir, ok := n.(types.IndirectRef)
+	if !ok {
+		return types.IndirectRef{}, nil
+	}
+	sd, _, err := ctx.DereferenceStreamDict(ir)
+	if err != nil || sd == nil {
+		return types.IndirectRef{}, nil
+	}
+	return ir, sd
+}
+
+// renderWithAnnotations rasterizes region of page pageNumber of ctx at dpi
+// with the annotation appearances drawn in.
+func renderWithAnnotations(ctx *model.Context, pageNumber int, region *types.Rectangle, dpi float64) (*image.RGBA, error) {
+	r, err := newRegionRenderer(ctx, pageNumber, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.render(region, dpi)
+}
@@ -0,0 +1,93 @@
+package crop
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkFixture writes a single-page PDF of img for the detection
+// benchmarks and returns its path.
+func benchmarkFixture(b *testing.B, img image.Image) string {
+	b.Helper()
+	dir := b.TempDir()
+	pngPath := filepath.Join(dir, "bench.png")
+	pdfPath := filepath.Join(dir, "bench.pdf")
+
+	writePNG(b, pngPath, img)
+	createPDFViaImport(b, pngPath, pdfPath)
+	return pdfPath
+}
+
+func benchmarkCrop(b *testing.B, img image.Image, opts Options) {
+	pdfPath := benchmarkFixture(b, img)
+	out := filepath.Join(b.TempDir(), "out.pdf")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CropAllPagesToSingleFile(pdfPath, out, opts); err != nil {
+			b.Fatalf("crop: %v", err)
+		}
+	}
+}
+
+// BenchmarkCrop_SinglePass renders the whole page at 300 DPI with
+// doc.ImageDPI and detects on that raster. The content is a centered block
+// covering 40% of each side of the page.
+func BenchmarkCrop_SinglePass(b *testing.B) {
+	benchmarkCrop(b, makeTestImage(1200, 1600), Options{DPI: 300, Threshold: 0.008, Space: 5, CropFrom: "center"})
+}
+
+// BenchmarkCrop_CoarseToFine renders the page of BenchmarkCrop_SinglePass at
+// 50 DPI and renders only a strip along each edge of the frame at 300 DPI.
+func BenchmarkCrop_CoarseToFine(b *testing.B) {
+	benchmarkCrop(b, makeTestImage(1200, 1600), Options{DPI: 300, CoarseDPI: 50, Threshold: 0.008, Space: 5, CropFrom: "center"})
+}
+
+// BenchmarkCrop_SinglePassText is BenchmarkCrop_SinglePass on a page of
+// text-like content filling all but a narrow margin.
+func BenchmarkCrop_SinglePassText(b *testing.B) {
+	benchmarkCrop(b, makeTextImage(1200, 1600), Options{DPI: 300, Threshold: 0.008, Space: 5, CropFrom: "center"})
+}
+
+// BenchmarkCrop_CoarseToFineText is BenchmarkCrop_CoarseToFine on the page
+// of BenchmarkCrop_SinglePassText, where the frame spans most of the page
+// and only the edge strips keep the 300 DPI renders small.
+func BenchmarkCrop_CoarseToFineText(b *testing.B) {
+	benchmarkCrop(b, makeTextImage(1200, 1600), Options{DPI: 300, CoarseDPI: 50, Threshold: 0.008, Space: 5, CropFrom: "center"})
+}
+
+// makeA0Raster returns an A0 page (2384x3370 pt) rendered at dpi with a few
+// blocks of content, built directly on the pixel buffer.
+func makeA0Raster(dpi float64) *image.RGBA {
+	w := int(2384 * dpi / 72)
+	h := int(3370 * dpi / 72)
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for y := h / 10; y < 9*h/10; y += 12 {
+		for dy := 0; dy < 6; dy++ {
+			row := img.Pix[(y+dy)*img.Stride:]
+			for x := w / 8; x < 7*w/8; x++ {
+				row[x*4] = 0
+				row[x*4+1] = 0
+				row[x*4+2] = 0
+			}
+		}
+	}
+	return img
+}
+
+// BenchmarkDetect_A0 measures detection on an A0 page at 100 DPI. The
+// reported B/op is the memory detection allocates per page on top of the
+// rendered raster.
+func BenchmarkDetect_A0(b *testing.B) {
+	img := makeA0Raster(100)
+	opts := Options{Threshold: 0.008, Space: 5, CropFrom: "center", CenterMode: CenterMedian}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		analyzeFrame(img, opts)
+	}
+	b.ReportMetric(float64(img.Bounds().Dx()*img.Bounds().Dy()), "px/page")
+}
@@ -0,0 +1,91 @@
+package crop
+
+// contentBlock is a rectangular region of content in pixel coordinates,
+// with exclusive upper bounds.
+type contentBlock struct {
+	x0, y0, x1, y1 int
+}
+
+func (b contentBlock) area() int {
+	return (b.x1 - b.x0) * (b.y1 - b.y0)
+}
+
+// splitProfile returns the [start, end) runs of counts that carry content,
+// treating runs of at least gap empty entries as separators. Shorter empty
+// runs are absorbed into the surrounding content.
+func splitProfile(counts []int, gap int) [][2]int {
+	if gap < 1 {
+		gap = 1
+	}
+	var runs [][2]int
+	start := -1
+	empty := 0
+	for i, c := range counts {
+		if c > 0 {
+			if start < 0 {
+				start = i
+			}
+			empty = 0
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		empty++
+		if empty >= gap {
+			runs = append(runs, [2]int{start, i - empty + 1})
+			start = -1
+			empty = 0
+		}
+	}
+	if start >= 0 {
+		runs = append(runs, [2]int{start, len(counts) - empty})
+	}
+	return runs
+}
+
+// detectBlocks finds all content blocks on the page: horizontal bands are
+// split on row gaps of gapH pixels, each band is split on column gaps of
+// gapW pixels, and every block is tightened to the rows it actually uses.
+func detectBlocks(d detectData, gapW, gapH int) []contentBlock {
+	var blocks []contentBlock
+	for _, band := range splitProfile(d.rowCounts, gapH) {
+		cols := make([]int, d.width)
+		for x := 0; x < d.width; x++ {
+			cols[x] = d.countNonZero(x, band[0], x+1, band[1])
+		}
+		for _, span := range splitProfile(cols, gapW) {
+			y0, y1 := band[0], band[1]
+			for y0 < y1 && d.countNonZero(span[0], y0, span[1], y0+1) == 0 {
+				y0++
+			}
+			for y1 > y0 && d.countNonZero(span[0], y1-1, span[1], y1) == 0 {
+				y1--
+			}
+			blocks = append(blocks, contentBlock{x0: span[0], y0: y0, x1: span[1], y1: y1})
+		}
+	}
+	return blocks
+}
+
+// unionBlocks returns the bounding box of all blocks whose area is at least
+// minArea pixels, and false when no block qualifies.
+func unionBlocks(blocks []contentBlock, minArea int) (contentBlock, bool) {
+	var union contentBlock
+	found := false
+	for _, b := range blocks {
+		if b.area() < minArea {
+			continue
+		}
+		if !found {
+			union = b
+			found = true
+			continue
+		}
+		union.x0 = min(union.x0, b.x0)
+		union.y0 = min(union.y0, b.y0)
+		union.x1 = max(union.x1, b.x1)
+		union.y1 = max(union.y1, b.y1)
+	}
+	return union, found
+}
@@ -0,0 +1,184 @@
+package crop
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Page boxes that Options.Boxes can select.
+const (
+	// BoxCrop is the CropBox, the region viewers show. When BoxBleed is
+	// also selected it is set to the BleedBox so that the bleed stays
+	// visible; otherwise it is set to the detected frame.
+	BoxCrop = "crop"
+	// BoxTrim is the TrimBox, the finished page size, set to the frame.
+	BoxTrim = "trim"
+	// BoxBleed is the BleedBox, the frame grown by Options.Bleed on every
+	// side and kept inside the MediaBox.
+	BoxBleed = "bleed"
+	// BoxArt is the ArtBox, the meaningful content, set to the frame.
+	BoxArt = "art"
+)
+
+// ErrBoxNesting is returned when the boxes to be set would not lie inside
+// each other and the MediaBox: the CropBox inside the MediaBox, the other
+// boxes inside the CropBox, and the TrimBox inside the BleedBox.
+var ErrBoxNesting = errors.New("page boxes do not nest")
+
+// boxEpsilon absorbs rounding when comparing box edges, in points.
+const boxEpsilon = 0.01
+
+// ParseBoxes parses a comma-separated list of box names such as
+// "trim,bleed". Names may repeat; the result lists each box once.
+func ParseBoxes(list string) ([]string, error) {
+	var boxes []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		switch name {
+		case BoxCrop, BoxTrim, BoxBleed, BoxArt:
+		default:
+			return nil, fmt.Errorf("unknown page box %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			boxes = append(boxes, name)
+		}
+	}
+	return boxes, nil
+}
+
+// pageBoxes are the boxes to set on a page. Nil boxes are left as they are.
+type pageBoxes struct {
+	crop, trim, bleed, art *types.Rectangle
+}
+
+// planBoxes returns the boxes that opts selects for a page with the given
+// MediaBox and detected frame, and a warning when the bleed had to be cut
+// back to fit the MediaBox.
+func planBoxes(media, frame *types.Rectangle, opts Options) (pageBoxes, []string) {
+	selected := opts.Boxes
+	if len(selected) == 0 {
+		selected = []string{BoxCrop}
+	}
+	has := func(name string) bool {
+		for _, s := range selected {
+			if s == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	var b pageBoxes
+	var warnings []string
+	if has(BoxBleed) {
+		grown := types.NewRectangle(frame.LL.X-opts.Bleed, frame.LL.Y-opts.Bleed, frame.UR.X+opts.Bleed, frame.UR.Y+opts.Bleed)
+		b.bleed = types.NewRectangle(
+			math.Max(grown.LL.X, media.LL.X),
+			math.Max(grown.LL.Y, media.LL.Y),
+			math.Min(grown.UR.X, media.UR.X),
+			math.Min(grown.UR.Y, media.UR.Y),
+		)
+		if !b.bleed.Equals(*grown) {
+			warnings = append(warnings, fmt.Sprintf("bleed of %g reduced to fit the MediaBox", opts.Bleed))
+		}
+	}
+	if has(BoxCrop) {
+		b.crop = frame
+		if b.bleed != nil {
+			b.crop = b.bleed
+		}
+	}
+	if has(BoxTrim) {
+		b.trim = frame
+	}
+	if has(BoxArt) {
+		b.art = frame
+	}
+	return b, warnings
+}
+
+// visible returns the region of the page that b leaves visible: the CropBox
+// it sets, else the BleedBox it sets, else frame. Hard crops and annotation
+// policies cut the page to it, so they follow the planned boxes rather than
+// a CropBox that Options.Boxes leaves alone.
+func (b pageBoxes) visible(frame *types.Rectangle) *types.Rectangle {
+	switch {
+	case b.crop != nil:
+		return b.crop
+	case b.bleed != nil:
+		return b.bleed
+	}
+	return frame
+}
+
+// validate checks that b nests inside media and, for boxes b does not
+// change, inside the page's current CropBox.
+func (b pageBoxes) validate(media, current *types.Rectangle) error {
+	crop := current
+	if b.crop != nil {
+		if !containsRect(media, b.crop) {
+			return fmt.Errorf("%w: CropBox %s extends past the MediaBox %s", ErrBoxNesting, preciseRectString(b.crop), preciseRectString(media))
+		}
+		crop = b.crop
+	}
+	for _, box := range []struct {
+		name string
+		rect *types.Rectangle
+	}{{"TrimBox", b.trim}, {"BleedBox", b.bleed}, {"ArtBox", b.art}} {
+		if box.rect != nil && !containsRect(crop, box.rect) {
+			return fmt.Errorf("%w: %s %s extends past the CropBox %s", ErrBoxNesting, box.name, preciseRectString(box.rect), preciseRectString(crop))
+		}
+	}
+	if b.trim != nil && b.bleed != nil && !containsRect(b.bleed, b.trim) {
+		return fmt.Errorf("%w: TrimBox %s extends past the BleedBox %s", ErrBoxNesting, preciseRectString(b.trim), preciseRectString(b.bleed))
+	}
+	return nil
+}
+
+// containsRect reports whether inner lies within outer, allowing for
+// rounding.
+func containsRect(outer, inner *types.Rectangle) bool {
+	return inner.LL.X >= outer.LL.X-boxEpsilon && inner.LL.Y >= outer.LL.Y-boxEpsilon &&
+		inner.UR.X <= outer.UR.X+boxEpsilon && inner.UR.Y <= outer.UR.Y+boxEpsilon
+}
+
+// pageCropBox returns the effective CropBox of a page, which is the
+// MediaBox when the page has none.
+func pageCropBox(ctx *model.Context, pageNumber int, media *types.Rectangle) *types.Rectangle {
+	pages, err := ctx.PageBoundaries(types.IntSet{pageNumber: true})
+	if err != nil || pageNumber < 1 || pageNumber > len(pages) {
+		return media
+	}
+	if crop := pages[pageNumber-1].CropBox(); crop != nil {
+		return crop
+	}
+	return media
+}
+
+// setPageBoxes writes the boxes of b to the page.
+func setPageBoxes(ctx *model.Context, pageNumber int, b pageBoxes) error {
+	pb := &model.PageBoundaries{}
+	if b.crop != nil {
+		pb.Crop = &model.Box{Rect: b.crop}
+	}
+	if b.trim != nil {
+		pb.Trim = &model.Box{Rect: b.trim}
+	}
+	if b.bleed != nil {
+		pb.Bleed = &model.Box{Rect: b.bleed}
+	}
+	if b.art != nil {
+		pb.Art = &model.Box{Rect: b.art}
+	}
+	if pb.Crop == nil && pb.Trim == nil && pb.Bleed == nil && pb.Art == nil {
+		return nil
+	}
+	return ctx.AddPageBoundaries(types.IntSet{pageNumber: true}, pb)
+}
@@ -0,0 +1,316 @@
+package crop
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// tokenKind is the kind of a content stream token.
+type tokenKind int
+
+const (
+	tokNumber tokenKind = iota
+	tokName
+	tokString
+	tokArray
+	tokDict
+	// tokLiteral is true, false or null.
+	tokLiteral
+	tokOperator
+)
+
+// contentToken is an operand or operator of a content stream.
+type contentToken struct {
+	kind tokenKind
+	// raw is the token as it appears in the stream.
+	raw []byte
+	// num is the value of a number.
+	num float64
+	// name is the name without its slash, or the operator.
+	name string
+	// text is the decoded value of a string.
+	text []byte
+	// elems are the elements of an array.
+	elems []contentToken
+}
+
+// contentOp is an operator with its operands. start and end delimit the
+// operator and its operands in the stream, so an op can be copied as is.
+type contentOp struct {
+	op         string
+	operands   []contentToken
+	start, end int
+}
+
+// parseContent splits a page content stream into operators. Inline images
+// become a single "BI" op that spans up to and including their EI.
+func parseContent(src []byte) ([]contentOp, error) {
+	l := &contentLexer{src: src}
+	var ops []contentOp
+	var operands []contentToken
+	start := -1
+	for {
+		l.skipSpace()
+		if l.pos >= len(src) {
+			break
+		}
+		if start < 0 {
+			start = l.pos
+		}
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind != tokOperator {
+			operands = append(operands, tok)
+			continue
+		}
+		if tok.name == "BI" {
+			if err := l.skipInlineImage(); err != nil {
+				return nil, err
+			}
+		}
+		ops = append(ops, contentOp{op: tok.name, operands: operands, start: start, end: l.pos})
+		operands = nil
+		start = -1
+	}
+	if len(operands) > 0 {
+		return nil, fmt.Errorf("content ends with operands but no operator")
+	}
+	return ops, nil
+}
+
+type contentLexer struct {
+	src []byte
+	pos int
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips white space and comments.
+func (l *contentLexer) skipSpace() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '%' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// regular returns the run of regular characters at the current position.
+func (l *contentLexer) regular() []byte {
+	start := l.pos
+	for l.pos < len(l.src) && !isSpace(l.src[l.pos]) && !isDelimiter(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+// next returns the token at the current position, which must not be white
+// space.
+func (l *contentLexer) next() (contentToken, error) {
+	start := l.pos
+	tok, err := l.token()
+	tok.raw = l.src[start:l.pos]
+	return tok, err
+}
+
+func (l *contentLexer) token() (contentToken, error) {
+	c := l.src[l.pos]
+	switch {
+	case c == '/':
+		l.pos++
+		return contentToken{kind: tokName, name: string(l.regular())}, nil
+	case c == '(':
+		text, err := l.literalString()
+		return contentToken{kind: tokString, text: text}, err
+	case c == '<' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '<':
+		l.pos += 2
+		elems, err := l.until(">>")
+		return contentToken{kind: tokDict, elems: elems}, err
+	case c == '<':
+		text, err := l.hexString()
+		return contentToken{kind: tokString, text: text}, err
+	case c == '[':
+		l.pos++
+		elems, err := l.until("]")
+		return contentToken{kind: tokArray, elems: elems}, err
+	case isDelimiter(c):
+		return contentToken{}, fmt.Errorf("unexpected %q at offset %d", c, l.pos)
+	}
+
+	word := l.regular()
+	if n, err := strconv.ParseFloat(string(word), 64); err == nil && (word[0] == '.' || word[0] == '-' || word[0] == '+' || (word[0] >= '0' && word[0] <= '9')) {
+		return contentToken{kind: tokNumber, num: n}, nil
+	}
+	switch string(word) {
+	case "true", "false", "null":
+		return contentToken{kind: tokLiteral, name: string(word)}, nil
+	}
+	return contentToken{kind: tokOperator, name: string(word)}, nil
+}
+
+// until reads tokens up to the closing delimiter of an array or dictionary.
+func (l *contentLexer) until(end string) ([]contentToken, error) {
+	var elems []contentToken
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.src) {
+			return nil, fmt.Errorf("unterminated %q", end)
+		}
+		if bytes.HasPrefix(l.src[l.pos:], []byte(end)) {
+			l.pos += len(end)
+			return elems, nil
+		}
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, tok)
+	}
+}
+
+// literalString decodes a string in parentheses, including nested
+// parentheses and escapes.
+func (l *contentLexer) literalString() ([]byte, error) {
+	l.pos++
+	var text []byte
+	depth := 1
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return text, nil
+			}
+		case '\\':
+			if l.pos >= len(l.src) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			e := l.src[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.src[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		text = append(text, c)
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// hexString decodes a string in angle brackets.
+func (l *contentLexer) hexString() ([]byte, error) {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			text := make([]byte, len(digits)/2)
+			for i := range text {
+				v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+				text[i] = byte(v)
+			}
+			return text, nil
+		}
+		if isSpace(c) {
+			continue
+		}
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return nil, fmt.Errorf("invalid hex string digit %q", c)
+		}
+		digits = append(digits, c)
+	}
+	return nil, fmt.Errorf("unterminated hex string")
+}
+
+// skipInlineImage moves past the parameters and data of an inline image
+// whose BI has just been read. The data ends at the first EI that stands
+// on its own between white space.
+func (l *contentLexer) skipInlineImage() error {
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.src) {
+			return fmt.Errorf("inline image without ID")
+		}
+		tok, err := l.next()
+		if err != nil {
+			return err
+		}
+		if tok.kind == tokOperator && tok.name == "ID" {
+			break
+		}
+	}
+	// A single white-space character separates ID from the data.
+	l.pos++
+	for i := l.pos; i+2 <= len(l.src); i++ {
+		if l.src[i] != 'E' || l.src[i+1] != 'I' {
+			continue
+		}
+		if i > 0 && !isSpace(l.src[i-1]) {
+			continue
+		}
+		if i+2 < len(l.src) && !isSpace(l.src[i+2]) && !isDelimiter(l.src[i+2]) {
+			continue
+		}
+		l.pos = i + 2
+		return nil
+	}
+	return fmt.Errorf("inline image without EI")
+}
@@ -1,25 +1,136 @@
 package crop
 
 import (
+	"context"
 	"fmt"
 	"image"
+	"log/slog"
 	"math"
-	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gen2brain/go-fitz"
-	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
 )
 
 type Options struct {
-	DPI       float64
-	Threshold float64
-	Space     int
-	CropFrom  string
+	DPI        float64
+	Threshold  float64
+	Space      int
+	CropFrom   string
+	CenterMode string
+	// DropHeaders excludes isolated narrow bands near the top and bottom
+	// edges, such as running heads, footers and page numbers, from detection.
+	DropHeaders bool
+	// HeaderZone is the distance from the top and bottom edges, as a
+	// fraction of the page height, in which header and footer bands are
+	// looked for. Defaults to 0.1.
+	HeaderZone float64
+	// Deskew estimates the skew angle of each auto-cropped page and, when
+	// set to DeskewCorrect, rotates the page content to straighten it
+	// before cropping. Empty disables it.
+	Deskew string
+	// RefineDPI, when set, re-renders narrow strips along each detected edge
+	// at this resolution and tightens the edge to the content found there.
+	// Zero disables refinement.
+	RefineDPI float64
+	// CoarseDPI, when set below DPI, switches to coarse-to-fine detection:
+	// the page is rendered at CoarseDPI to find the approximate frame, and
+	// only strips around each edge are then rendered at DPI (or RefineDPI
+	// if higher) to place the edges precisely.
+	CoarseDPI float64
+	// MaxPixels, when set, caps the pixels of every render: a page whose
+	// CropBox at DPI, or a refinement strip at RefineDPI, would take more
+	// fails with ErrPageTooLarge instead of being rendered. It bounds the
+	// render memory at about 4 bytes per pixel.
+	MaxPixels int64 `json:"-"`
+	// MinBlockArea is the smallest block, as a fraction of the page area,
+	// that "blocks" cropping keeps. Smaller blocks such as specks or stray
+	// marks are ignored. Zero keeps every block.
+	MinBlockArea float64
+	// Overwrite is the policy for output files that already exist; see
+	// OverwriteReplace, the default, and ValidOverwrite.
+	Overwrite string
+	// InPlace allows an output to replace the input file. Outputs are
+	// written to a temporary file and renamed, so the input stays intact
+	// if cropping fails.
+	InPlace bool
+	// OutputTemplate names per-page outputs of CropPages whose page option
+	// has no Output; see ExpandTemplate for the placeholders. Empty keeps
+	// the "<input> - page NN.pdf" names.
+	OutputTemplate string
+	// OutputDir places outputs named by OutputTemplate, or by default, in
+	// this directory instead of next to the input.
+	OutputDir string
+	// Boxes selects the page boxes set from the detected frame; see BoxCrop
+	// and ParseBoxes. Empty sets only the CropBox. Leaving BoxCrop out
+	// keeps the existing CropBox.
+	Boxes []string
+	// Bleed is how far, in points, the BleedBox extends past the frame on
+	// each side when Boxes includes BoxBleed.
+	Bleed float64
+	// HardCrop also sets the MediaBox to the crop rectangle, so that the
+	// page truly ends there and printers that ignore the CropBox print only
+	// the visible region. TrimBox, BleedBox and ArtBox are cut back to it.
+	HardCrop bool
+	// StripHidden, with HardCrop, removes paths, text, images, form
+	// XObjects and annotations that lie entirely outside the crop
+	// rectangle. Content whose extent cannot be worked out is kept.
+	StripHidden bool
+	// Provenance records in the Info dictionary of each output how it was
+	// cropped: the package Version, the time, the detection settings and
+	// the crop of every page. ReadProvenance reads it back.
+	Provenance bool
+	// Links is the policy for internal links to pages left out of an
+	// output of CropPages or CropPagesToFile; see LinksRemove, the default,
+	// and LinksExternal. Such outputs keep the document information and
+	// language of the input, the outline entries that lead to their pages
+	// and the links between them.
+	Links string
+	// Annotations is the policy for annotations that the crop leaves
+	// partly or entirely outside the CropBox, per kind of annotation; see
+	// AnnotationsKeep, the default, AnnotationsDrop, AnnotationsClip and
+	// ParseAnnotationPolicies. PageResult.Annotations lists the changes.
+	Annotations AnnotationPolicies
+	// DetectAnnotations draws the appearances of visible annotations, such
+	// as comments, stamps and filled-in form fields, into the pages
+	// rendered for detection, so that the crop keeps them.
+	DetectAnnotations bool
+	// Password and OwnerPassword open encrypted inputs. Either one is
+	// enough if the document accepts it; a document that needs one and
+	// gets neither fails with ErrPassword.
+	Password      string `json:"-"`
+	OwnerPassword string `json:"-"`
+	// Encryption is the policy for protecting outputs; see EncryptionKeep,
+	// the default, EncryptionDecrypt and EncryptionEncrypt.
+	Encryption string
+	// NewPassword and NewOwnerPassword are the passwords of outputs
+	// under EncryptionEncrypt. They default to Password and OwnerPassword.
+	NewPassword      string `json:"-"`
+	NewOwnerPassword string `json:"-"`
+	// Metrics, when set, receives page counts, stage latencies, failures
+	// and document sizes.
+	Metrics Metrics `json:"-"`
+	// Logger, when set, receives a debug record per page with the render
+	// size, detection thresholds and the resulting boxes.
+	Logger *slog.Logger `json:"-"`
 }
 
+// Center modes select how the starting point for "center" cropping is found.
+const (
+	// CenterDensest picks the single densest row and column. This is the
+	// original behaviour and is kept for compatibility.
+	CenterDensest = "densest"
+	// CenterCentroid uses the mean position of the content.
+	CenterCentroid = "centroid"
+	// CenterMedian uses the weighted median of the row and column projections.
+	CenterMedian = "median"
+	// CenterProfile uses the peak of a smoothed projection profile.
+	CenterProfile = "profile"
+)
+
 type PageOption struct {
 	Number int
 	Left   int
@@ -35,14 +146,35 @@ type PageResult struct {
 	Crop    *types.Rectangle
 	Output  string
 	WasAuto bool
+	Dropped []DroppedBand
+	// Skew is the estimated counter-clockwise skew of the page content in
+	// degrees when deskewing is enabled.
+	Skew float64
+	// Bleed is the BleedBox set on the page, if any.
+	Bleed *types.Rectangle
+	// Stripped counts what Options.StripHidden removed from the page.
+	Stripped StripStats
+	// Annotations lists the annotations Options.Annotations removed or
+	// clipped.
+	Annotations []AnnotationChange
+	// Warnings describe problems that did not stop the page from being
+	// cropped but may make the result wrong, such as a missing MediaBox.
+	Warnings []string
+}
+
+// DroppedBand is a header or footer band that was left out of the crop.
+type DroppedBand struct {
+	Edge string // "header" or "footer"
+	Rect *types.Rectangle
 }
 
 func DefaultOptions() Options {
 	return Options{
-		DPI:       128,
-		Threshold: 0.008,
-		Space:     5,
-		CropFrom:  "center",
+		DPI:        128,
+		Threshold:  0.008,
+		Space:      5,
+		CropFrom:   "center",
+		CenterMode: CenterMedian,
 	}
 }
 
@@ -59,64 +191,82 @@ func normalizeOptions(opts *Options) {
 	if opts.CropFrom == "" {
 		opts.CropFrom = "center"
 	}
+	if opts.CenterMode == "" {
+		opts.CenterMode = CenterMedian
+	}
+	if opts.HeaderZone <= 0 {
+		opts.HeaderZone = 0.1
+	}
+	if opts.CoarseDPI > 0 && opts.CoarseDPI < opts.DPI {
+		// From here on DPI is the resolution pages are rendered at for
+		// detection; the requested resolution is reached by refinement.
+		opts.RefineDPI = math.Max(opts.RefineDPI, opts.DPI)
+		opts.DPI = opts.CoarseDPI
+	}
+}
+
+// logger returns opts.Logger, or a logger that discards every record.
+func (opts Options) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return opts.Logger
+}
+
+// ValidCropFrom reports whether mode names a known crop detection mode.
+func ValidCropFrom(mode string) bool {
+	switch mode {
+	case "center", "border", "blocks":
+		return true
+	}
+	return false
+}
+
+// ValidCenterMode reports whether mode names a known center detection mode.
+func ValidCenterMode(mode string) bool {
+	switch mode {
+	case CenterDensest, CenterCentroid, CenterMedian, CenterProfile:
+		return true
+	}
+	return false
 }
 
 func CropDocument(inputFile, outputFile string, opts Options) error {
 	if outputFile == "" {
 		return fmt.Errorf("output file is required")
 	}
-	normalizeOptions(&opts)
-
-	doc, err := fitz.New(inputFile)
-	if err != nil {
+	if err := checkOutput(inputFile, outputFile, opts); err != nil {
 		return err
 	}
-	defer doc.Close()
 
-	ctx, err := api.ReadContextFile(inputFile)
+	d, err := openDocument(inputFile, opts)
 	if err != nil {
 		return err
 	}
+	defer d.Close()
 
-	pageCount := doc.NumPage()
-	for pageNo := 0; pageNo < pageCount; pageNo++ {
-		img, err := doc.ImageDPI(pageNo, opts.DPI)
-		if err != nil {
-			return fmt.Errorf("render page %d: %w", pageNo, err)
-		}
-		media, err := pageMediaBox(ctx, pageNo+1)
-		if err != nil {
-			return fmt.Errorf("page %d mediabox: %w", pageNo, err)
-		}
-		cropBox := rectFromImage(img, media, opts)
-		if err := setCropBox(ctx, pageNo+1, cropBox); err != nil {
-			return fmt.Errorf("page %d crop: %w", pageNo, err)
-		}
+	results, err := d.AutoCrop(context.Background(), opts)
+	if err != nil {
+		return err
 	}
-
-	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+	if err := addProvenance(d.ctx, results, opts); err != nil {
 		return err
 	}
-	return api.WriteContextFile(ctx, outputFile)
+	return writeOutput(d.ctx, inputFile, outputFile, opts)
 }
 
 func CropPages(inputFile string, pageOptions []PageOption, opts Options) ([]PageResult, error) {
 	normalizeOptions(&opts)
 
-	doc, err := fitz.New(inputFile)
-	if err != nil {
-		return nil, err
-	}
-	defer doc.Close()
-
-	ctx, err := api.ReadContextFile(inputFile)
+	d, err := openDocument(inputFile, opts)
 	if err != nil {
 		return nil, err
 	}
+	defer d.Close()
 
 	if len(pageOptions) == 0 {
-		pageOptions = make([]PageOption, 0, doc.NumPage())
-		for i := 0; i < doc.NumPage(); i++ {
+		pageOptions = make([]PageOption, 0, d.NumPage())
+		for i := 0; i < d.NumPage(); i++ {
 			pageOptions = append(pageOptions, PageOption{Number: i})
 		}
 	}
@@ -124,47 +274,28 @@ func CropPages(inputFile string, pageOptions []PageOption, opts Options) ([]Page
 	results := make([]PageResult, 0, len(pageOptions))
 	for _, option := range pageOptions {
 		pageNo := option.Number
-		if pageNo < 0 || pageNo >= doc.NumPage() {
-			return nil, fmt.Errorf("page no exceed the page number")
-		}
-		media, err := pageMediaBox(ctx, pageNo+1)
+		res, err := cropPage(d, option, opts)
 		if err != nil {
-			return nil, fmt.Errorf("page %d mediabox: %w", pageNo, err)
+			return nil, err
 		}
 
-		var cropBox *types.Rectangle
-		wasAuto := false
-		if option.Left == option.Right || option.Top == option.Bottom {
-			img, err := doc.ImageDPI(pageNo, opts.DPI)
+		output := option.Output
+		if output == "" {
+			output, err = pageOutputFile(inputFile, pageNo, opts)
 			if err != nil {
-				return nil, fmt.Errorf("render page %d: %w", pageNo, err)
+				return nil, err
 			}
-			cropBox = rectFromImage(img, media, opts)
-			wasAuto = true
-		} else {
-			cropBox = rectFromTopLeft(media, option.Left, option.Top, option.Right, option.Bottom)
 		}
-
-		if err := setCropBox(ctx, pageNo+1, cropBox); err != nil {
-			return nil, fmt.Errorf("page %d crop: %w", pageNo, err)
+		if opts.OutputTemplate == "" {
+			output = legacyPageFile(output, pageNo)
 		}
 
-		output := option.Output
-		if output == "" {
-			output = defaultOutputFile(inputFile, pageNo)
-		}
-
-		if err := writeSinglePage(ctx, pageNo+1, output); err != nil {
+		if err := writeSinglePage(d.ctx, res, inputFile, output, opts); err != nil {
 			return nil, fmt.Errorf("page %d write: %w", pageNo, err)
 		}
 
-		results = append(results, PageResult{
-			PageNo:  pageNo,
-			Media:   media,
-			Crop:    cropBox,
-			Output:  output,
-			WasAuto: wasAuto,
-		})
+		res.Output = output
+		results = append(results, res)
 	}
 
 	return results, nil
@@ -174,71 +305,269 @@ func CropAllPagesToSingleFile(inputFile, outputFile string, opts Options) ([]Pag
 	if outputFile == "" {
 		return nil, fmt.Errorf("output file is required")
 	}
-	normalizeOptions(&opts)
+	if err := checkOutput(inputFile, outputFile, opts); err != nil {
+		return nil, err
+	}
 
-	doc, err := fitz.New(inputFile)
+	d, err := openDocument(inputFile, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer doc.Close()
+	defer d.Close()
 
-	ctx, err := api.ReadContextFile(inputFile)
+	results, err := d.AutoCrop(context.Background(), opts)
 	if err != nil {
 		return nil, err
 	}
+	if err := addProvenance(d.ctx, results, opts); err != nil {
+		return nil, err
+	}
+	if err := writeOutput(d.ctx, inputFile, outputFile, opts); err != nil {
+		return nil, err
+	}
 
-	results := make([]PageResult, 0, doc.NumPage())
-	for pageNo := 0; pageNo < doc.NumPage(); pageNo++ {
-		img, err := doc.ImageDPI(pageNo, opts.DPI)
-		if err != nil {
-			return nil, fmt.Errorf("render page %d: %w", pageNo, err)
+	for i := range results {
+		results[i].Output = outputFile
+	}
+	return results, nil
+}
+
+// CropPagesToFile crops the pages selected by pageOptions, mixing manual and
+// auto crops like CropPages, and writes them into a single PDF at outputFile
+// in the order of pageOptions. The Output of each page option is ignored.
+// Without page options every page is auto-cropped in document order. A page
+// may be selected only once.
+func CropPagesToFile(inputFile, outputFile string, pageOptions []PageOption, opts Options) ([]PageResult, error) {
+	if outputFile == "" {
+		return nil, fmt.Errorf("output file is required")
+	}
+	if err := checkOutput(inputFile, outputFile, opts); err != nil {
+		return nil, err
+	}
+	normalizeOptions(&opts)
+
+	d, err := openDocument(inputFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	if len(pageOptions) == 0 {
+		pageOptions = make([]PageOption, 0, d.NumPage())
+		for i := 0; i < d.NumPage(); i++ {
+			pageOptions = append(pageOptions, PageOption{Number: i})
 		}
-		media, err := pageMediaBox(ctx, pageNo+1)
-		if err != nil {
-			return nil, fmt.Errorf("page %d mediabox: %w", pageNo, err)
+	}
+
+	results := make([]PageResult, 0, len(pageOptions))
+	pageNrs := make([]int, 0, len(pageOptions))
+	seen := make(map[int]bool, len(pageOptions))
+	for _, option := range pageOptions {
+		if seen[option.Number] {
+			return nil, fmt.Errorf("page %d selected more than once", option.Number)
 		}
-		cropBox := rectFromImage(img, media, opts)
-		if err := setCropBox(ctx, pageNo+1, cropBox); err != nil {
-			return nil, fmt.Errorf("page %d crop: %w", pageNo, err)
+		seen[option.Number] = true
+
+		res, err := cropPage(d, option, opts)
+		if err != nil {
+			return nil, err
 		}
-		results = append(results, PageResult{
-			PageNo: pageNo,
-			Media:  media,
-			Crop:   cropBox,
-		})
+		res.Output = outputFile
+		results = append(results, res)
+		pageNrs = append(pageNrs, option.Number+1)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+	out, err := extractPages(d.ctx, pageNrs, inputFile, outputFile, opts)
+	if err != nil {
 		return nil, err
 	}
-	if err := api.WriteContextFile(ctx, outputFile); err != nil {
+	if err := addProvenance(out, results, opts); err != nil {
 		return nil, err
 	}
-
-	for i := range results {
-		results[i].Output = outputFile
+	if err := writeOutput(out, inputFile, outputFile, opts); err != nil {
+		return nil, err
 	}
 	return results, nil
 }
 
+// cropPage sets the CropBox of the page selected by option, either to the
+// given rectangle or, when the rectangle is empty, to the detected one.
+func cropPage(d *Document, option PageOption, opts Options) (PageResult, error) {
+	pageNo := option.Number
+	if pageNo < 0 || pageNo >= d.NumPage() {
+		return PageResult{}, fmt.Errorf("page no exceed the page number")
+	}
+	m := opts.metrics()
+	log := opts.logger().With("page", pageNo)
+	opts.Logger = log
+	media, fallback, err := pageMediaBox(d.ctx, pageNo+1)
+	if err != nil {
+		m.Failure(FailureBoxes)
+		return PageResult{}, fmt.Errorf("page %d mediabox: %w", pageNo, err)
+	}
+	var warnings []string
+	if fallback {
+		var warning string
+		media, warning = fallbackMediaBox(d.doc, pageNo)
+		warnings = append(warnings, warning)
+		log.Warn(warning)
+	}
+
+	if err := recordOriginalBoxes(d.ctx, pageNo+1); err != nil {
+		m.Failure(FailureBoxes)
+		return PageResult{}, fmt.Errorf("page %d record boxes: %w", pageNo, err)
+	}
+
+	res := PageResult{PageNo: pageNo, Media: media}
+	if option.Left == option.Right || option.Top == option.Bottom {
+		start := time.Now()
+		img, err := d.render(pageNo, media, opts)
+		if err != nil {
+			m.Failure(FailureRender)
+			return PageResult{}, fmt.Errorf("render page %d: %w", pageNo, err)
+		}
+		m.ObserveStage(StageRender, time.Since(start))
+
+		start = time.Now()
+		res, err = autoCrop(d.ctx, pageNo+1, img, media, opts)
+		if err != nil {
+			m.Failure(FailureDetect)
+			return PageResult{}, fmt.Errorf("page %d: %w", pageNo, err)
+		}
+		m.ObserveStage(StageDetect, time.Since(start))
+		warnings = append(warnings, res.Warnings...)
+		res.WasAuto = true
+	} else {
+		res.Crop = rectFromTopLeft(media, option.Left, option.Top, option.Right, option.Bottom)
+	}
+
+	boxes, boxWarnings := planBoxes(media, res.Crop, opts)
+	warnings = append(warnings, boxWarnings...)
+	for _, warning := range boxWarnings {
+		log.Warn(warning)
+	}
+	if err := boxes.validate(media, pageCropBox(d.ctx, pageNo+1, media)); err != nil {
+		m.Failure(FailureBoxes)
+		return PageResult{}, fmt.Errorf("page %d: %w", pageNo, err)
+	}
+	if err := setPageBoxes(d.ctx, pageNo+1, boxes); err != nil {
+		m.Failure(FailureBoxes)
+		return PageResult{}, fmt.Errorf("page %d crop: %w", pageNo, err)
+	}
+	res.Bleed = boxes.bleed
+	target := boxes.visible(res.Crop)
+	if opts.HardCrop {
+		stripped, stripWarnings, err := hardCrop(d.ctx, pageNo+1, target, opts.StripHidden)
+		if err != nil {
+			m.Failure(FailureBoxes)
+			return PageResult{}, fmt.Errorf("page %d hard crop: %w", pageNo, err)
+		}
+		for _, warning := range stripWarnings {
+			log.Warn(warning)
+		}
+		warnings = append(warnings, stripWarnings...)
+		res.Stripped = stripped
+		log.Debug("hard crop", "media", preciseRectString(target), "stripped", stripped.String())
+	}
+	if opts.Annotations.active() {
+		changes, annotWarnings, err := applyAnnotationPolicies(d.ctx, pageNo+1, target, opts.Annotations)
+		if err != nil {
+			m.Failure(FailureBoxes)
+			return PageResult{}, fmt.Errorf("page %d annotations: %w", pageNo, err)
+		}
+		for _, warning := range annotWarnings {
+			log.Warn(warning)
+		}
+		warnings = append(warnings, annotWarnings...)
+		res.Annotations = changes
+		log.Debug("annotations", "changed", len(changes))
+	}
+	res.Warnings = warnings
+	m.PageProcessed()
+	log.Debug("page cropped",
+		"media", preciseRectString(media),
+		"media_fallback", fallback,
+		"crop", preciseRectString(res.Crop),
+		"auto", res.WasAuto)
+	return res, nil
+}
+
+// autoCrop detects the crop rectangle of a rendered page, straightening the
+// page first and refining the detected edges when the options ask for it.
+func autoCrop(ctx *model.Context, pageNumber int, img *image.RGBA, media *types.Rectangle, opts Options) (PageResult, error) {
+	img, skew, warning, err := straighten(ctx, pageNumber, img, media, opts)
+	if err != nil {
+		return PageResult{}, fmt.Errorf("deskew: %w", err)
+	}
+	var warnings []string
+	if warning != "" {
+		opts.logger().Warn(warning)
+		warnings = append(warnings, warning)
+	}
+	cropBox, dropped := detectPage(img, media, opts)
+	if opts.RefineDPI > 0 {
+		cropBox, err = refineFrame(ctx, pageNumber, media, cropBox, opts)
+		if err != nil {
+			return PageResult{}, fmt.Errorf("refine: %w", err)
+		}
+	}
+	return PageResult{
+		PageNo:   pageNumber - 1,
+		Media:    media,
+		Crop:     cropBox,
+		Dropped:  dropped,
+		Skew:     skew,
+		Warnings: warnings,
+	}, nil
+}
+
 func rectFromImage(img *image.RGBA, media *types.Rectangle, opts Options) *types.Rectangle {
-	leftF, topF, rightF, bottomF := detectFrame(img, opts.Space, opts.Threshold, opts.CropFrom)
+	rect, _ := detectPage(img, media, opts)
+	return rect
+}
+
+// detectPage returns the crop rectangle for a rendered page along with the
+// header and footer bands that were excluded from it.
+func detectPage(img *image.RGBA, media *types.Rectangle, opts Options) (*types.Rectangle, []DroppedBand) {
+	f := analyzeFrame(img, opts)
+	opts.logger().Debug("detect",
+		"dpi", opts.DPI,
+		"width", img.Bounds().Dx(),
+		"height", img.Bounds().Dy(),
+		"mode", opts.CropFrom,
+		"center_x", f.centerX,
+		"center_y", f.centerY,
+		"threshold_w", f.thresholdW,
+		"threshold_h", f.thresholdH,
+		"frame", []float64{f.left, f.top, f.right, f.bottom},
+		"dropped", len(f.dropped))
 	width := media.UR.X - media.LL.X
 	height := media.UR.Y - media.LL.Y
-	left := int(leftF * width)
-	top := int(topF * height)
-	right := int(rightF * width)
-	bottom := int(bottomF * height)
-	return rectFromTopLeft(media, left, top, right, bottom)
+
+	var dropped []DroppedBand
+	for _, band := range f.dropped {
+		dropped = append(dropped, DroppedBand{
+			Edge: band.edge,
+			Rect: roundOutward(rectFromFrame(media, 0, band.top*height, width, band.bottom*height), media),
+		})
+	}
+	rect := rectFromFrame(media, f.left*width, f.top*height, f.right*width, f.bottom*height)
+	return roundOutward(rect, media), dropped
 }
 
 func rectFromTopLeft(media *types.Rectangle, left, top, right, bottom int) *types.Rectangle {
+	return rectFromFrame(media, float64(left), float64(top), float64(right), float64(bottom))
+}
+
+// rectFromFrame converts offsets in points from the top-left corner of media
+// into a PDF rectangle.
+func rectFromFrame(media *types.Rectangle, left, top, right, bottom float64) *types.Rectangle {
 	height := media.UR.Y - media.LL.Y
 
-	leftX := media.LL.X + float64(left)
-	rightX := media.LL.X + float64(right)
-	upperY := media.LL.Y + (height - float64(top))
-	lowerY := media.LL.Y + (height - float64(bottom))
+	leftX := media.LL.X + left
+	rightX := media.LL.X + right
+	upperY := media.LL.Y + (height - top)
+	lowerY := media.LL.Y + (height - bottom)
 
 	llx := math.Min(leftX, rightX)
 	urx := math.Max(leftX, rightX)
@@ -248,43 +577,93 @@ func rectFromTopLeft(media *types.Rectangle, left, top, right, bottom int) *type
 	return types.NewRectangle(llx, lly, urx, ury)
 }
 
-func pageMediaBox(ctx *model.Context, pageNumber int) (*types.Rectangle, error) {
+// rectScale sets the grid detected rectangles snap to: 1/rectScale points.
+const rectScale = 100
+
+// roundOutward snaps rect outward to the 1/rectScale point grid so rounding
+// never clips content, without growing past media.
+func roundOutward(rect, media *types.Rectangle) *types.Rectangle {
+	llx := math.Floor(rect.LL.X*rectScale) / rectScale
+	lly := math.Floor(rect.LL.Y*rectScale) / rectScale
+	urx := math.Ceil(rect.UR.X*rectScale) / rectScale
+	ury := math.Ceil(rect.UR.Y*rectScale) / rectScale
+	return types.NewRectangle(
+		math.Max(llx, media.LL.X),
+		math.Max(lly, media.LL.Y),
+		math.Min(urx, media.UR.X),
+		math.Min(ury, media.UR.Y),
+	)
+}
+
+// pageMediaBox returns the MediaBox of a page. It falls back to A4, and
+// reports that it did, when pdfcpu cannot provide one.
+func pageMediaBox(ctx *model.Context, pageNumber int) (*types.Rectangle, bool, error) {
 	pages, err := ctx.PageBoundaries(types.IntSet{pageNumber: true})
 	if err != nil {
 		// Fallback to default A4 size.
-		return types.RectForDim(595, 842), nil
+		return types.RectForDim(595, 842), true, nil
 	}
-	if len(pages) == 0 {
-		return types.RectForDim(595, 842), nil
+	// PageBoundaries returns an entry for every page; only the selected one
+	// is filled in.
+	if pageNumber < 1 || pageNumber > len(pages) {
+		return types.RectForDim(595, 842), true, nil
 	}
-	media := pages[0].MediaBox()
+	media := pages[pageNumber-1].MediaBox()
 	if media == nil {
-		return types.RectForDim(595, 842), nil
+		return types.RectForDim(595, 842), true, nil
 	}
-	return media, nil
+	return media, false, nil
+}
+
+// fallbackMediaBox returns the page size MuPDF reports for a page whose
+// MediaBox pdfcpu could not read, or A4 when MuPDF cannot tell either, along
+// with a warning saying which one was used.
+func fallbackMediaBox(doc *fitz.Document, pageNo int) (*types.Rectangle, string) {
+	bound, err := doc.Bound(pageNo)
+	if err == nil && !bound.Empty() {
+		w, h := float64(bound.Dx()), float64(bound.Dy())
+		return types.RectForDim(w, h), fmt.Sprintf("MediaBox unreadable, using the %gx%g page size reported by MuPDF", w, h)
+	}
+	return types.RectForDim(595, 842), "MediaBox unreadable, assuming A4 (595x842)"
 }
 
 func setCropBox(ctx *model.Context, pageNumber int, rect *types.Rectangle) error {
 	if rect == nil {
 		return nil
 	}
-	pb := &model.PageBoundaries{
-		Crop: &model.Box{Rect: rect},
-	}
-	return ctx.AddPageBoundaries(types.IntSet{pageNumber: true}, pb)
+	return setPageBoxes(ctx, pageNumber, pageBoxes{crop: rect})
 }
 
-func writeSinglePage(ctx *model.Context, pageNumber int, output string) error {
-	reader, err := api.ExtractPage(ctx, pageNumber)
+// writeSinglePage writes the page of ctx cropped as res to output.
+func writeSinglePage(ctx *model.Context, res PageResult, inputFile, output string, opts Options) error {
+	out, err := extractPages(ctx, []int{res.PageNo + 1}, inputFile, output, opts)
 	if err != nil {
 		return err
 	}
-	outputDir := filepath.Dir(output)
-	outputName := filepath.Base(output)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
+	if err := addProvenance(out, []PageResult{res}, opts); err != nil {
 		return err
 	}
-	return api.WritePage(reader, outputDir, outputName, pageNumber)
+	return writeOutput(out, inputFile, output, opts)
+}
+
+// pageOutputFile names the output of page pageNo when the page option does
+// not, using opts.OutputTemplate and opts.OutputDir if set.
+func pageOutputFile(inputFile string, pageNo int, opts Options) (string, error) {
+	if opts.OutputTemplate != "" {
+		return ExpandTemplate(opts.OutputTemplate, inputFile, opts.OutputDir, pageNo, time.Now())
+	}
+	output := defaultOutputFile(inputFile, pageNo)
+	if opts.OutputDir != "" {
+		output = filepath.Join(opts.OutputDir, filepath.Base(output))
+	}
+	return output, nil
+}
+
+// legacyPageFile returns the file name api.WritePage, which CropPages used
+// to write with, made of output: it appends "_page_N.pdf", where N counts
+// pages from 1. Without an output template the old names are kept.
+func legacyPageFile(output string, pageNo int) string {
+	return fmt.Sprintf("%s_page_%d.pdf", output, pageNo+1)
 }
 
 func defaultOutputFile(inputFile string, pageNo int) string {
@@ -299,3 +678,17 @@ func RectString(rect *types.Rectangle) string {
 	}
 	return fmt.Sprintf("(%d, %d), (%d, %d)", int(rect.LL.X), int(rect.LL.Y), int(rect.UR.X), int(rect.UR.Y))
 }
+
+// preciseRectString is RectString with the coordinates at the 1/rectScale
+// point precision the crop is detected at, for logs and error messages.
+func preciseRectString(rect *types.Rectangle) string {
+	if rect == nil {
+		return "(0, 0), (0, 0)"
+	}
+	return fmt.Sprintf("(%s, %s), (%s, %s)", formatPoint(rect.LL.X), formatPoint(rect.LL.Y), formatPoint(rect.UR.X), formatPoint(rect.UR.Y))
+}
+
+// formatPoint prints a coordinate with up to two decimals and no trailing zeros.
+func formatPoint(v float64) string {
+	return strconv.FormatFloat(math.Round(v*rectScale)/rectScale, 'f', -1, 64)
+}
@@ -0,0 +1,72 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// makeSkewedTextImage draws dashed "text lines" rotated counter-clockwise by
+// angle degrees about the image center.
+func makeSkewedTextImage(w, h int, angle float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx, cy := float64(w)/2, float64(h)/2
+	for line := h / 4; line < 3*h/4; line += 16 {
+		for x := w / 5; x < 4*w/5; x++ {
+			if (x/12)%4 == 3 {
+				continue
+			}
+			for dy := 0; dy < 4; dy++ {
+				px, py := float64(x)-cx, float64(line+dy)-cy
+				rx := cx + px*cos + py*sin
+				ry := cy - px*sin + py*cos
+				img.Set(int(rx), int(ry), color.Black)
+			}
+		}
+	}
+	return img
+}
+
+func TestEstimateSkew(t *testing.T) {
+	for _, angle := range []float64{0, 1.5, -2.3, 4} {
+		got := estimateSkew(makeSkewedTextImage(400, 500, angle))
+		if math.Abs(got-angle) > 0.15 {
+			t.Errorf("estimateSkew for %.2f deg: got %.2f", angle, got)
+		}
+	}
+}
+
+func TestEstimateSkew_BlankImage(t *testing.T) {
+	if got := estimateSkew(makeSkewedTextImage(10, 10, 0)); got != 0 {
+		t.Errorf("expected 0 for image without lines, got %.2f", got)
+	}
+}
+
+func TestRotateImage_StraightensSkew(t *testing.T) {
+	img := makeSkewedTextImage(400, 500, 3)
+	straight := rotateImage(img, 3)
+	if got := estimateSkew(straight); math.Abs(got) > 0.15 {
+		t.Errorf("expected straightened image, got skew %.2f", got)
+	}
+	if straight.Bounds() != img.Bounds() {
+		t.Errorf("rotation changed bounds: %v", straight.Bounds())
+	}
+}
+
+func TestValidDeskew(t *testing.T) {
+	for _, mode := range []string{DeskewOff, DeskewDetect, DeskewCorrect} {
+		if !ValidDeskew(mode) {
+			t.Errorf("expected %q to be valid", mode)
+		}
+	}
+	if ValidDeskew("rotate") {
+		t.Errorf("expected unknown mode to be invalid")
+	}
+}
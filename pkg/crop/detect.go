@@ -1,26 +1,36 @@
 package crop
 
-import "image"
+import (
+	"image"
+	"math/bits"
+)
 
+// detectData is a packed 1-bit mask of the non-white pixels of a page,
+// together with its row and column projections. It takes 1/8 byte per pixel
+// plus O(width+height) for the projections, instead of an integral image.
 type detectData struct {
 	width     int
 	height    int
-	prefixSum []int
+	stride    int      // words per mask row
+	bits      []uint64 // bit x%64 of word y*stride+x/64 is set for non-white pixels
 	rowCounts []int
 	colCounts []int
+	rowPrefix []int // rowPrefix[y] is the number of non-white pixels above row y
+	colPrefix []int // colPrefix[x] is the number of non-white pixels left of column x
 }
 
 func buildDetectData(img *image.RGBA) detectData {
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-	ps := make([]int, (width+1)*(height+1))
+	stride := (width + 63) / 64
+	bits := make([]uint64, stride*height)
 	rowCounts := make([]int, height)
 	colCounts := make([]int, width)
 
 	for y := 0; y < height; y++ {
-		rowSum := 0
 		rowOffset := y * img.Stride
+		words := bits[y*stride : (y+1)*stride]
 		for x := 0; x < width; x++ {
 			idx := rowOffset + x*4
 			r := img.Pix[idx]
@@ -29,21 +39,56 @@ func buildDetectData(img *image.RGBA) detectData {
 			a := img.Pix[idx+3]
 			nonWhite := r != 255 || g != 255 || b != 255 || a != 255
 			if nonWhite {
-				rowSum++
+				words[x/64] |= 1 << (x % 64)
 				rowCounts[y]++
 				colCounts[x]++
 			}
-			ps[(y+1)*(width+1)+x+1] = ps[y*(width+1)+x+1] + rowSum
 		}
 	}
 
-	return detectData{
+	d := detectData{
 		width:     width,
 		height:    height,
-		prefixSum: ps,
+		stride:    stride,
+		bits:      bits,
 		rowCounts: rowCounts,
 		colCounts: colCounts,
 	}
+	d.updatePrefixes()
+	return d
+}
+
+// updatePrefixes recomputes rowPrefix and colPrefix from the projections.
+func (d *detectData) updatePrefixes() {
+	d.rowPrefix = cumulative(d.rowPrefix, d.rowCounts)
+	d.colPrefix = cumulative(d.colPrefix, d.colCounts)
+}
+
+func cumulative(dst, counts []int) []int {
+	if len(dst) != len(counts)+1 {
+		dst = make([]int, len(counts)+1)
+	}
+	for i, c := range counts {
+		dst[i+1] = dst[i] + c
+	}
+	return dst
+}
+
+// countRow returns the number of non-white pixels in row y within [x0, x1).
+func (d detectData) countRow(y, x0, x1 int) int {
+	words := d.bits[y*d.stride : (y+1)*d.stride]
+	first := x0 / 64
+	last := (x1 - 1) / 64
+	headMask := ^uint64(0) << (x0 % 64)
+	tailMask := ^uint64(0) >> (63 - (x1-1)%64)
+	if first == last {
+		return bits.OnesCount64(words[first] & headMask & tailMask)
+	}
+	n := bits.OnesCount64(words[first] & headMask)
+	for i := first + 1; i < last; i++ {
+		n += bits.OnesCount64(words[i])
+	}
+	return n + bits.OnesCount64(words[last]&tailMask)
 }
 
 func (d detectData) countNonZero(x0, y0, x1, y1 int) int {
@@ -63,8 +108,19 @@ func (d detectData) countNonZero(x0, y0, x1, y1 int) int {
 		return 0
 	}
 
-	w := d.width + 1
-	return d.prefixSum[y1*w+x1] - d.prefixSum[y1*w+x0] - d.prefixSum[y0*w+x1] + d.prefixSum[y0*w+x0]
+	// Full-width bands and full-height strips, the common queries, are
+	// answered from the projections.
+	if x0 == 0 && x1 == d.width {
+		return d.rowPrefix[y1] - d.rowPrefix[y0]
+	}
+	if y0 == 0 && y1 == d.height {
+		return d.colPrefix[x1] - d.colPrefix[x0]
+	}
+	n := 0
+	for y := y0; y < y1; y++ {
+		n += d.countRow(y, x0, x1)
+	}
+	return n
 }
 
 func detectCenter(d detectData) (int, int) {
@@ -90,6 +146,129 @@ func detectCenter(d detectData) (int, int) {
 	return centerX, centerY
 }
 
+// detectCenterCentroid returns the mean position of all non-white pixels,
+// snapped to the nearest row and column that carry content.
+func detectCenterCentroid(d detectData) (int, int) {
+	return snapToContent(d.colCounts, profileMean(d.colCounts)), snapToContent(d.rowCounts, profileMean(d.rowCounts))
+}
+
+// detectCenterMedian returns the weighted median of the row and column
+// projections, so a single dense rule or header bar cannot pull the center
+// away from the bulk of the content.
+func detectCenterMedian(d detectData) (int, int) {
+	return snapToContent(d.colCounts, profileMedian(d.colCounts)), snapToContent(d.rowCounts, profileMedian(d.rowCounts))
+}
+
+// detectCenterProfile returns the peak of the row and column projections
+// after box smoothing over a window proportional to the page size.
+func detectCenterProfile(d detectData) (int, int) {
+	cx := profilePeak(smoothProfile(d.colCounts, d.width/profileWindowDivisor))
+	cy := profilePeak(smoothProfile(d.rowCounts, d.height/profileWindowDivisor))
+	return snapToContent(d.colCounts, cx), snapToContent(d.rowCounts, cy)
+}
+
+// profileWindowDivisor sets the smoothing window of detectCenterProfile to
+// 1/8 of the page dimension, wide enough to average over several text lines.
+const profileWindowDivisor = 8
+
+func findCenter(d detectData, mode string) (int, int) {
+	switch mode {
+	case CenterDensest:
+		return detectCenter(d)
+	case CenterCentroid:
+		return detectCenterCentroid(d)
+	case CenterProfile:
+		return detectCenterProfile(d)
+	default:
+		return detectCenterMedian(d)
+	}
+}
+
+func profileMean(counts []int) int {
+	total := 0
+	weighted := 0
+	for i, c := range counts {
+		total += c
+		weighted += i * c
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
+
+func profileMedian(counts []int) int {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	half := (total + 1) / 2
+	acc := 0
+	for i, c := range counts {
+		acc += c
+		if acc >= half {
+			return i
+		}
+	}
+	return len(counts) - 1
+}
+
+func smoothProfile(counts []int, window int) []int {
+	if window < 1 {
+		window = 1
+	}
+	half := window / 2
+	prefix := make([]int, len(counts)+1)
+	for i, c := range counts {
+		prefix[i+1] = prefix[i] + c
+	}
+	smoothed := make([]int, len(counts))
+	for i := range counts {
+		lo := i - half
+		if lo < 0 {
+			lo = 0
+		}
+		hi := i + half + 1
+		if hi > len(counts) {
+			hi = len(counts)
+		}
+		smoothed[i] = prefix[hi] - prefix[lo]
+	}
+	return smoothed
+}
+
+func profilePeak(counts []int) int {
+	peak := 0
+	best := -1
+	for i, c := range counts {
+		if c > best {
+			peak = i
+			best = c
+		}
+	}
+	return peak
+}
+
+// snapToContent moves pos to the nearest index with a non-zero count so the
+// expansion in detectTop/detectBottom never starts inside a whitespace gap.
+func snapToContent(counts []int, pos int) int {
+	if pos < 0 || pos >= len(counts) {
+		return pos
+	}
+	for delta := 0; delta < len(counts); delta++ {
+		if i := pos - delta; i >= 0 && counts[i] > 0 {
+			return i
+		}
+		if i := pos + delta; i < len(counts) && counts[i] > 0 {
+			return i
+		}
+	}
+	return pos
+}
+
 func detectTop(d detectData, cy, space, threshold int) int {
 	for endY := cy; endY > 0; endY -= space {
 		startY := endY - threshold
@@ -191,13 +370,56 @@ func detectBorder(d detectData, space, thresholdW, thresholdH int) (int, int, in
 	return top, bottom, left, right
 }
 
-func detectFrame(img *image.RGBA, space int, threshold float64, cropFrom string) (float64, float64, float64, float64) {
+// frameDetection is the detected frame as fractions of the image size,
+// together with any header or footer bands that were left out of it. The
+// center and thresholds are in pixels and are kept for logging; the center
+// is -1 outside "center" mode.
+type frameDetection struct {
+	left, top, right, bottom float64
+	dropped                  []edgeBand
+	centerX, centerY         int
+	thresholdW, thresholdH   int
+}
+
+// edgeBand is a dropped header or footer band as fractions of the image height.
+type edgeBand struct {
+	edge        string
+	top, bottom float64
+}
+
+func detectFrame(img *image.RGBA, opts Options) (float64, float64, float64, float64) {
+	f := analyzeFrame(img, opts)
+	return f.left, f.top, f.right, f.bottom
+}
+
+func analyzeFrame(img *image.RGBA, opts Options) frameDetection {
 	d := buildDetectData(img)
 	if d.width == 0 || d.height == 0 {
-		return 0, 0, 0, 0
+		return frameDetection{}
 	}
 
-	if cropFrom == "center" {
+	var f frameDetection
+	if opts.DropHeaders {
+		for _, band := range findHeaderBands(d, opts.HeaderZone) {
+			d.excludeRows(band.y0, band.y1)
+			f.dropped = append(f.dropped, edgeBand{
+				edge:   band.edge,
+				top:    float64(band.y0) / float64(d.height),
+				bottom: float64(band.y1) / float64(d.height),
+			})
+		}
+	}
+	frameFromData(d, opts, &f)
+	return f
+}
+
+// frameFromData sets the frame of f, along with the center and thresholds
+// used to find it.
+func frameFromData(d detectData, opts Options, f *frameDetection) {
+	space := opts.Space
+	threshold := opts.Threshold
+	f.centerX, f.centerY = -1, -1
+	if opts.CropFrom == "center" {
 		thresholdH := int(float64(d.height) * threshold)
 		thresholdW := int(float64(d.width) * threshold)
 		if thresholdH < 1 {
@@ -206,12 +428,35 @@ func detectFrame(img *image.RGBA, space int, threshold float64, cropFrom string)
 		if thresholdW < 1 {
 			thresholdW = 1
 		}
-		cx, cy := detectCenter(d)
+		cx, cy := findCenter(d, opts.CenterMode)
 		top := detectTop(d, cy, space, thresholdH)
 		bottom := detectBottom(d, cy, space, thresholdH)
 		left := detectLeft(d, cx, top, bottom, space, thresholdW)
 		right := detectRight(d, cx, top, bottom, space, thresholdW)
-		return float64(left) / float64(d.width), float64(top) / float64(d.height), float64(right) / float64(d.width), float64(bottom) / float64(d.height)
+		f.centerX, f.centerY = cx, cy
+		f.thresholdW, f.thresholdH = thresholdW, thresholdH
+		f.setFrame(d, left, top, right, bottom)
+		return
+	}
+
+	if opts.CropFrom == "blocks" {
+		thresholdH := int(float64(d.height) * threshold)
+		thresholdW := int(float64(d.width) * threshold)
+		if thresholdH < 1 {
+			thresholdH = 1
+		}
+		if thresholdW < 1 {
+			thresholdW = 1
+		}
+		minArea := int(opts.MinBlockArea * float64(d.width*d.height))
+		f.thresholdW, f.thresholdH = thresholdW, thresholdH
+		union, ok := unionBlocks(detectBlocks(d, thresholdW, thresholdH), minArea)
+		if !ok {
+			f.left, f.top, f.right, f.bottom = 0, 0, 0, 0
+			return
+		}
+		f.setFrame(d, union.x0, union.y0, union.x1, union.y1)
+		return
 	}
 
 	thresholdH := int(float64(d.height) * threshold)
@@ -230,5 +475,14 @@ func detectFrame(img *image.RGBA, space int, threshold float64, cropFrom string)
 	}
 
 	top, bottom, left, right := detectBorder(d, space, thresholdW, thresholdH)
-	return float64(left) / float64(d.width), float64(top) / float64(d.height), float64(right) / float64(d.width), float64(bottom) / float64(d.height)
+	f.thresholdW, f.thresholdH = thresholdW, thresholdH
+	f.setFrame(d, left, top, right, bottom)
+}
+
+// setFrame sets the frame of f from pixel edges.
+func (f *frameDetection) setFrame(d detectData, left, top, right, bottom int) {
+	f.left = float64(left) / float64(d.width)
+	f.top = float64(top) / float64(d.height)
+	f.right = float64(right) / float64(d.width)
+	f.bottom = float64(bottom) / float64(d.height)
 }
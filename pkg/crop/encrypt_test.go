@@ -0,0 +1,183 @@
+package crop
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// writeEncryptedFixture writes the fixture PDF encrypted with AES-256 under
+// the user password "user" and the owner password "owner".
+func writeEncryptedFixture(t *testing.T, dir string) string {
+	t.Helper()
+	plain := writeFixturePDF(t, dir)
+	encrypted := filepath.Join(dir, "encrypted.pdf")
+	conf := model.NewAESConfiguration("user", "owner", 256)
+	if err := api.EncryptFile(plain, encrypted, conf); err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
+
+// readEncrypted reads the PDF at path with password.
+func readEncrypted(t *testing.T, path, password string) (*model.Context, error) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readContext(data, password, "")
+}
+
+func TestOpenDocument_Password(t *testing.T) {
+	pdfPath := writeEncryptedFixture(t, t.TempDir())
+	if _, err := OpenDocument(pdfPath); !errors.Is(err, ErrPassword) {
+		t.Fatalf("without a password: %v", err)
+	}
+	if _, err := OpenDocumentWithPassword(pdfPath, "wrong", ""); !errors.Is(err, ErrPassword) {
+		t.Fatalf("with a wrong password: %v", err)
+	}
+	for _, pw := range [][2]string{{"user", ""}, {"", "owner"}} {
+		d, err := OpenDocumentWithPassword(pdfPath, pw[0], pw[1])
+		if err != nil {
+			t.Fatalf("passwords %q: %v", pw, err)
+		}
+		d.Close()
+	}
+}
+
+func TestCropAllPagesToSingleFile_EncryptionPolicies(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeEncryptedFixture(t, tdir)
+
+	tests := []struct {
+		encryption string
+		opens      string // a password that must open the output
+		rejects    string // one that must not; empty for unencrypted outputs
+	}{
+		{encryption: "", opens: "user", rejects: "new"},
+		{encryption: EncryptionDecrypt, opens: ""},
+		{encryption: EncryptionEncrypt, opens: "new", rejects: "user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encryption, func(t *testing.T) {
+			out := filepath.Join(tdir, "out-"+tt.encryption+".pdf")
+			opts := DefaultOptions()
+			opts.Password = "user"
+			opts.Encryption = tt.encryption
+			opts.NewPassword, opts.NewOwnerPassword = "new", "newowner"
+			results, err := CropAllPagesToSingleFile(pdfPath, out, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !results[0].WasAuto || results[0].Crop.Equals(*types.RectForDim(300, 300)) {
+				t.Errorf("crop = %v, want one detected inside the page", results[0].Crop)
+			}
+
+			ctx, err := readEncrypted(t, out, tt.opens)
+			if err != nil {
+				t.Fatalf("password %q: %v", tt.opens, err)
+			}
+			if encrypted := ctx.Encrypt != nil; encrypted != (tt.rejects != "") {
+				t.Errorf("encrypted = %t", encrypted)
+			}
+			if !pagesCropped(t, ctx, results[0].Crop) {
+				t.Errorf("CropBox not set")
+			}
+			if tt.rejects != "" {
+				if _, err := readEncrypted(t, out, tt.rejects); !errors.Is(err, ErrPassword) {
+					t.Errorf("password %q: %v", tt.rejects, err)
+				}
+			}
+		})
+	}
+}
+
+// pagesCropped reports whether the first page of ctx has the CropBox want.
+func pagesCropped(t *testing.T, ctx *model.Context, want *types.Rectangle) bool {
+	t.Helper()
+	pages, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pages[0].CropBox().Equals(*want)
+}
+
+func TestCropPages_KeepsEncryption(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeEncryptedFixture(t, tdir)
+	out := filepath.Join(tdir, "page.pdf")
+
+	opts := DefaultOptions()
+	opts.OwnerPassword = "owner"
+	page := PageOption{Number: 0, Left: 10, Top: 20, Right: 110, Bottom: 120, Output: out}
+	results, err := CropPages(pdfPath, []PageOption{page}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = results[0].Output
+	if _, err := readEncrypted(t, out, ""); !errors.Is(err, ErrPassword) {
+		t.Fatalf("per-page output opened without a password: %v", err)
+	}
+	ctx, err := readEncrypted(t, out, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pagesCropped(t, ctx, types.NewRectangle(10, 180, 110, 280)) {
+		t.Error("CropBox not set")
+	}
+
+	opts.Encryption = EncryptionEncrypt
+	opts.OwnerPassword = ""
+	opts.Password = "user"
+	if _, err := CropPages(pdfPath, []PageOption{page}, opts); err == nil {
+		t.Error("encrypting without an owner password: expected error")
+	}
+}
+
+func TestCropPagesToFile_EncryptsPlainInput(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	out := filepath.Join(tdir, "out.pdf")
+
+	opts := DefaultOptions()
+	opts.Encryption = EncryptionEncrypt
+	opts.NewPassword, opts.NewOwnerPassword = "new", "newowner"
+	if _, err := CropPagesToFile(pdfPath, out, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEncrypted(t, out, ""); !errors.Is(err, ErrPassword) {
+		t.Fatalf("output opened without a password: %v", err)
+	}
+	if _, err := readEncrypted(t, out, "new"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadProvenanceWithPassword(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeEncryptedFixture(t, tdir)
+	out := filepath.Join(tdir, "out.pdf")
+
+	opts := DefaultOptions()
+	opts.Password = "user"
+	opts.Provenance = true
+	if _, err := CropAllPagesToSingleFile(pdfPath, out, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadProvenance(out); !errors.Is(err, ErrPassword) {
+		t.Fatalf("without a password: %v", err)
+	}
+	p, err := ReadProvenanceWithPassword(out, "user", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Pages) != 1 {
+		t.Errorf("pages = %+v", p.Pages)
+	}
+}
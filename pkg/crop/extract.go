@@ -0,0 +1,608 @@
+package crop
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Link policies select what happens to an internal link whose target page
+// is not part of an output that holds only some of the pages.
+const (
+	// LinksRemove removes such links. This is the default.
+	LinksRemove = "remove"
+	// LinksExternal turns them into links to the target page in the input
+	// file, which must then stay where it is for the links to work.
+	LinksExternal = "external"
+)
+
+// ValidLinks reports whether policy names a known link policy. The empty
+// string selects LinksRemove.
+func ValidLinks(policy string) bool {
+	switch policy {
+	case "", LinksRemove, LinksExternal:
+		return true
+	}
+	return false
+}
+
+// catalogMetadataKeys are the document catalog entries carried over to
+// outputs that hold only some of the pages.
+var catalogMetadataKeys = []string{"Lang", "Metadata", "ViewerPreferences", "PageLayout", "PageMode"}
+
+// extraction builds an output from some pages of an input document. Page
+// numbers are one-based, as in pdfcpu.
+type extraction struct {
+	src, dst *model.Context
+	pageNrs  []int
+	// position maps an input page number to its index in pageNrs.
+	position map[int]int
+	// copied maps input object numbers to the objects copied into dst.
+	copied map[int]types.IndirectRef
+	// remote is the input file as external links refer to it.
+	remote string
+	links  string
+
+	outlineEntries int
+	linksRemapped  int
+	linksRemoved   int
+	linksExternal  int
+}
+
+// extractPages returns a new document holding the given pages of ctx in the
+// given order. Unlike pdfcpu.ExtractPages it keeps the document information,
+// language and other catalog metadata, the outline entries that lead to the
+// extracted pages, and internal links between them. Links to other pages
+// are handled as opts.Links says, with external links pointing from output
+// to inputFile.
+func extractPages(ctx *model.Context, pageNrs []int, inputFile, output string, opts Options) (*model.Context, error) {
+	// pdfcpu copies named destinations by rewriting the name tree of the
+	// input in place, which breaks it for the next extraction. Links and
+	// outline entries are given explicit destinations below instead.
+	dests, hasDests := ctx.Names["Dests"]
+	if hasDests {
+		delete(ctx.Names, "Dests")
+	}
+	out, err := pdfcpu.ExtractPages(ctx, pageNrs, false)
+	if hasDests {
+		ctx.Names["Dests"] = dests
+	}
+	if err != nil {
+		return nil, err
+	}
+	// pdfcpu leaves the count at zero, which PageDict rejects.
+	out.PageCount = len(pageNrs)
+
+	x := &extraction{
+		src:      ctx,
+		dst:      out,
+		pageNrs:  pageNrs,
+		position: make(map[int]int, len(pageNrs)),
+		copied:   map[int]types.IndirectRef{},
+		remote:   remoteFile(inputFile, output),
+		links:    opts.Links,
+	}
+	for i, pageNr := range pageNrs {
+		x.position[pageNr] = i
+	}
+	if opts.Encryption == "" || opts.Encryption == EncryptionKeep {
+		if err := keepEncryption(ctx, out); err != nil {
+			return nil, fmt.Errorf("encryption: %w", err)
+		}
+	}
+	if err := x.copyMetadata(); err != nil {
+		return nil, fmt.Errorf("document metadata: %w", err)
+	}
+	if err := x.copyOutlines(); err != nil {
+		return nil, fmt.Errorf("outlines: %w", err)
+	}
+	for i := range pageNrs {
+		if err := x.fixLinks(i); err != nil {
+			return nil, fmt.Errorf("page %d links: %w", pageNrs[i]-1, err)
+		}
+	}
+	opts.logger().Debug("pages extracted", "output", output, "pages", len(pageNrs),
+		"outline_items", x.outlineEntries, "links_remapped", x.linksRemapped,
+		"links_removed", x.linksRemoved, "links_external", x.linksExternal)
+	return out, nil
+}
+
+// remoteFile names inputFile as seen from the directory of output, for the
+// file specification of external links.
+func remoteFile(inputFile, output string) string {
+	in, err := filepath.Abs(inputFile)
+	if err != nil {
+		return filepath.ToSlash(inputFile)
+	}
+	dir, err := filepath.Abs(filepath.Dir(output))
+	if err != nil {
+		return filepath.ToSlash(in)
+	}
+	if rel, err := filepath.Rel(dir, in); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(in)
+}
+
+// copyMetadata copies the Info dictionary and the catalog entries in
+// catalogMetadataKeys from the input.
+func (x *extraction) copyMetadata() error {
+	if x.src.Info != nil {
+		info, err := x.src.DereferenceDict(*x.src.Info)
+		if err != nil {
+			return err
+		}
+		if info != nil {
+			o, err := x.copy(info)
+			if err != nil {
+				return err
+			}
+			if x.dst.Info, err = x.dst.IndRefForNewObject(o); err != nil {
+				return err
+			}
+		}
+	}
+
+	srcRoot, err := x.src.Catalog()
+	if err != nil {
+		return err
+	}
+	dstRoot, err := x.dst.Catalog()
+	if err != nil {
+		return err
+	}
+	for _, key := range catalogMetadataKeys {
+		o, found := srcRoot.Find(key)
+		if !found {
+			continue
+		}
+		if dstRoot[key], err = x.copy(o); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// copy returns a deep copy of o for the output, copying each indirect
+// object once. Pages are never copied, so that a stray reference cannot
+// drag the whole input along; references to them become null.
+func (x *extraction) copy(o types.Object) (types.Object, error) {
+	switch o := o.(type) {
+	case types.IndirectRef:
+		if ir, ok := x.copied[o.ObjectNumber.Value()]; ok {
+			return ir, nil
+		}
+		obj, err := x.src.Dereference(o)
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := obj.(types.Dict); ok && d.Type() != nil && *d.Type() == "Page" {
+			return nil, nil
+		}
+		if obj == nil {
+			return nil, nil
+		}
+		// Take the object number first, so that a cycle ends here.
+		objNr, err := x.dst.InsertObject(nil)
+		if err != nil {
+			return nil, err
+		}
+		ir := *types.NewIndirectRef(objNr, 0)
+		x.copied[o.ObjectNumber.Value()] = ir
+		if obj, err = x.copy(obj.Clone()); err != nil {
+			return nil, err
+		}
+		entry, _ := x.dst.FindTableEntryLight(objNr)
+		entry.Object = obj
+		return ir, nil
+
+	case types.Dict:
+		d := types.Dict{}
+		for k, v := range o {
+			v, err := x.copy(v)
+			if err != nil {
+				return nil, err
+			}
+			d[k] = v
+		}
+		return d, nil
+
+	case types.StreamDict:
+		sd := o.Clone().(types.StreamDict)
+		for k, v := range sd.Dict {
+			v, err := x.copy(v)
+			if err != nil {
+				return nil, err
+			}
+			sd.Dict[k] = v
+		}
+		return sd, nil
+
+	case types.Array:
+		a := make(types.Array, len(o))
+		for i, v := range o {
+			v, err := x.copy(v)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = v
+		}
+		return a, nil
+	}
+	return o, nil
+}
+
+// internalDest returns the destination of d, a link annotation or outline
+// item, if it leads to a page of the same document: its Dest entry or the D
+// entry of a GoTo action.
+func internalDest(ctx *model.Context, d types.Dict) (types.Object, bool) {
+	if o, found := d.Find("Dest"); found {
+		return o, true
+	}
+	o, found := d.Find("A")
+	if !found {
+		return nil, false
+	}
+	action, err := ctx.DereferenceDict(o)
+	if err != nil || action == nil {
+		return nil, false
+	}
+	if s := action.NameEntry("S"); s == nil || *s != "GoTo" {
+		return nil, false
+	}
+	return action.Find("D")
+}
+
+// resolveDest returns the one-based page number a destination in ctx leads
+// to and its view, the rest of the explicit destination such as
+// /XYZ left top zoom. Named destinations are looked up.
+func resolveDest(ctx *model.Context, o types.Object) (int, types.Array, error) {
+	o, err := ctx.Dereference(o)
+	if err != nil {
+		return 0, nil, err
+	}
+	var arr types.Array
+	switch o := o.(type) {
+	case types.Array:
+		arr = o
+	case types.Dict:
+		arr, err = ctx.DereferenceArray(o["D"])
+	case types.Name:
+		arr, err = ctx.DereferenceDestArray(o.Value())
+	case types.StringLiteral, types.HexLiteral:
+		var name *string
+		if name, err = types.StringOrHexLiteral(o); err == nil {
+			arr, err = ctx.DereferenceDestArray(*name)
+		}
+	default:
+		err = fmt.Errorf("invalid destination %v", o)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(arr) == 0 {
+		return 0, nil, fmt.Errorf("empty destination")
+	}
+
+	view := arr[1:]
+	switch page := arr[0].(type) {
+	case types.IndirectRef:
+		pageNr, err := ctx.PageNumber(page.ObjectNumber.Value())
+		if err != nil {
+			return 0, nil, err
+		}
+		if pageNr == 0 {
+			return 0, nil, fmt.Errorf("destination %v is not a page", page)
+		}
+		return pageNr, view, nil
+	case types.Integer:
+		// Some producers write the zero-based page number of a remote
+		// destination here.
+		return page.Value() + 1, view, nil
+	}
+	return 0, nil, fmt.Errorf("invalid destination page %v", arr[0])
+}
+
+// localDest returns an explicit destination of the output page at index i
+// with the given view.
+func (x *extraction) localDest(i int, view types.Array) (types.Array, error) {
+	_, ir, _, err := x.dst.PageDict(i+1, false)
+	if err != nil {
+		return nil, err
+	}
+	if ir == nil {
+		return nil, fmt.Errorf("output page %d not found", i+1)
+	}
+	return append(types.Array{*ir}, x.view(view)...), nil
+}
+
+// view copies the view of a destination, defaulting to fitting the page.
+func (x *extraction) view(view types.Array) types.Array {
+	if len(view) == 0 {
+		return types.Array{types.Name("Fit")}
+	}
+	return view.Clone().(types.Array)
+}
+
+// remoteAction returns a GoToR action to page pageNr of the input file.
+func (x *extraction) remoteAction(pageNr int, view types.Array) (types.Dict, error) {
+	file, err := types.EscapedUTF16String(x.remote)
+	if err != nil {
+		return nil, err
+	}
+	return types.Dict{
+		"Type": types.Name("Action"),
+		"S":    types.Name("GoToR"),
+		"F":    types.StringLiteral(*file),
+		// Remote destinations give the zero-based page number.
+		"D": append(types.Array{types.Integer(pageNr - 1)}, x.view(view)...),
+	}, nil
+}
+
+// fixLinks points the internal links of the output page at index i to the
+// output pages they led to in the input, and handles links to pages that
+// were not extracted as x.links says. pdfcpu copies the annotations of a
+// page in order, so the input and output arrays line up.
+func (x *extraction) fixLinks(i int) error {
+	srcPage, _, _, err := x.src.PageDict(x.pageNrs[i], false)
+	if err != nil {
+		return err
+	}
+	dstPage, _, _, err := x.dst.PageDict(i+1, false)
+	if err != nil {
+		return err
+	}
+	srcAnnots, err := x.src.DereferenceArray(srcPage["Annots"])
+	if err != nil {
+		return err
+	}
+	dstAnnots, err := x.dst.DereferenceArray(dstPage["Annots"])
+	if err != nil {
+		return err
+	}
+	if len(srcAnnots) == 0 || len(srcAnnots) != len(dstAnnots) {
+		return nil
+	}
+
+	kept := make(types.Array, 0, len(dstAnnots))
+	for j, entry := range dstAnnots {
+		keep, err := x.fixLink(srcAnnots[j], entry)
+		if err != nil {
+			return err
+		}
+		if keep {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		dstPage.Delete("Annots")
+	} else {
+		dstPage["Annots"] = kept
+	}
+	return nil
+}
+
+// fixLink rewrites the destination of dstAnnot, the copy of srcAnnot, if it
+// is an internal link. It reports whether the annotation stays.
+func (x *extraction) fixLink(srcAnnot, dstAnnot types.Object) (bool, error) {
+	src, err := x.src.DereferenceDict(srcAnnot)
+	if err != nil || src == nil {
+		return true, err
+	}
+	if subtype := src.NameEntry("Subtype"); subtype == nil || *subtype != "Link" {
+		return true, nil
+	}
+	dest, ok := internalDest(x.src, src)
+	if !ok {
+		return true, nil
+	}
+	dst, err := x.dst.DereferenceDict(dstAnnot)
+	if err != nil || dst == nil {
+		return true, err
+	}
+
+	pageNr, view, err := resolveDest(x.src, dest)
+	if err != nil {
+		// The link was broken in the input already.
+		x.linksRemoved++
+		return false, nil
+	}
+	if i, ok := x.position[pageNr]; ok {
+		arr, err := x.localDest(i, view)
+		if err != nil {
+			return false, err
+		}
+		if _, found := dst.Find("Dest"); found {
+			dst["Dest"] = arr
+		} else if action, err := x.dst.DereferenceDict(dst["A"]); err == nil && action != nil {
+			action["D"] = arr
+		} else {
+			dst["A"] = types.Dict{"Type": types.Name("Action"), "S": types.Name("GoTo"), "D": arr}
+		}
+		x.linksRemapped++
+		return true, nil
+	}
+	if x.links != LinksExternal {
+		x.linksRemoved++
+		return false, nil
+	}
+	action, err := x.remoteAction(pageNr, view)
+	if err != nil {
+		return false, err
+	}
+	dst.Delete("Dest")
+	dst["A"] = action
+	x.linksExternal++
+	return true, nil
+}
+
+// outlineItem is an outline entry of the output before it is linked into
+// the outline tree.
+type outlineItem struct {
+	d    types.Dict
+	open bool
+	kids []*outlineItem
+}
+
+// copyOutlines copies the outline of the input to the output, keeping the
+// entries that lead to an extracted page, those with actions other than
+// going to a page, such as opening a web page, and the parents of kept
+// entries, so that the hierarchy stays intact.
+func (x *extraction) copyOutlines() error {
+	root, err := x.src.Catalog()
+	if err != nil {
+		return err
+	}
+	o, found := root.Find("Outlines")
+	if !found {
+		return nil
+	}
+	outlines, err := x.src.DereferenceDict(o)
+	if err != nil || outlines == nil {
+		return err
+	}
+	items, err := x.outlineItems(outlines["First"], map[int]bool{})
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	d := types.Dict{"Type": types.Name("Outlines")}
+	ir, err := x.dst.IndRefForNewObject(d)
+	if err != nil {
+		return err
+	}
+	first, last, count, err := x.writeOutlineItems(items, *ir)
+	if err != nil {
+		return err
+	}
+	d["First"], d["Last"], d["Count"] = first, last, types.Integer(count)
+	dstRoot, err := x.dst.Catalog()
+	if err != nil {
+		return err
+	}
+	dstRoot["Outlines"] = *ir
+	return nil
+}
+
+// outlineItems returns the output entries for the input outline entry
+// first and its siblings. seen guards against loops in broken outlines.
+func (x *extraction) outlineItems(first types.Object, seen map[int]bool) ([]*outlineItem, error) {
+	var items []*outlineItem
+	for o := first; o != nil; {
+		ir, ok := o.(types.IndirectRef)
+		if !ok || seen[ir.ObjectNumber.Value()] {
+			break
+		}
+		seen[ir.ObjectNumber.Value()] = true
+		d, err := x.src.DereferenceDict(ir)
+		if err != nil {
+			return nil, err
+		}
+		if d == nil {
+			break
+		}
+		kids, err := x.outlineItems(d["First"], seen)
+		if err != nil {
+			return nil, err
+		}
+		item, err := x.outlineItem(d, kids)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			items = append(items, item)
+		}
+		o = d["Next"]
+	}
+	return items, nil
+}
+
+// outlineItem returns the output entry for the input entry d with the
+// given output children, or nil if it is dropped.
+func (x *extraction) outlineItem(d types.Dict, kids []*outlineItem) (*outlineItem, error) {
+	item := &outlineItem{d: types.Dict{}, kids: kids}
+	if count := d.IntEntry("Count"); count != nil && *count > 0 {
+		item.open = true
+	}
+	for _, key := range []string{"Title", "C", "F"} {
+		if o, found := d.Find(key); found {
+			o, err := x.copy(o)
+			if err != nil {
+				return nil, err
+			}
+			item.d[key] = o
+		}
+	}
+
+	if dest, ok := internalDest(x.src, d); ok {
+		if pageNr, view, err := resolveDest(x.src, dest); err == nil {
+			if i, ok := x.position[pageNr]; ok {
+				arr, err := x.localDest(i, view)
+				if err != nil {
+					return nil, err
+				}
+				item.d["Dest"] = arr
+				return item, nil
+			}
+		}
+	} else if o, found := d.Find("A"); found {
+		a, err := x.copy(o)
+		if err != nil {
+			return nil, err
+		}
+		item.d["A"] = a
+		return item, nil
+	}
+	// Without a destination of its own, the entry only groups its children.
+	if len(kids) == 0 {
+		return nil, nil
+	}
+	return item, nil
+}
+
+// writeOutlineItems adds items to the output as the children of parent and
+// returns the first and last of them and the number of entries shown below
+// parent when it is open.
+func (x *extraction) writeOutlineItems(items []*outlineItem, parent types.IndirectRef) (types.IndirectRef, types.IndirectRef, int, error) {
+	refs := make([]types.IndirectRef, len(items))
+	for i, item := range items {
+		ir, err := x.dst.IndRefForNewObject(item.d)
+		if err != nil {
+			return types.IndirectRef{}, types.IndirectRef{}, 0, err
+		}
+		refs[i] = *ir
+	}
+
+	count := 0
+	for i, item := range items {
+		item.d["Parent"] = parent
+		if i > 0 {
+			item.d["Prev"] = refs[i-1]
+		}
+		if i < len(items)-1 {
+			item.d["Next"] = refs[i+1]
+		}
+		count++
+		if len(item.kids) > 0 {
+			first, last, n, err := x.writeOutlineItems(item.kids, refs[i])
+			if err != nil {
+				return types.IndirectRef{}, types.IndirectRef{}, 0, err
+			}
+			item.d["First"], item.d["Last"] = first, last
+			if item.open {
+				item.d["Count"] = types.Integer(n)
+				count += n
+			} else {
+				item.d["Count"] = types.Integer(-n)
+			}
+		}
+		x.outlineEntries++
+	}
+	return refs[0], refs[len(refs)-1], count, nil
+}
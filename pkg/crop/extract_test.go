@@ -0,0 +1,230 @@
+package crop
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// writeLinkedFixture writes a three page PDF with a title, a language, an
+// outline with an entry per page, the one for page 2 in a group, and on
+// page 1 links to pages 2 and 3, one of them by name.
+func writeLinkedFixture(t *testing.T, dir string) string {
+	t.Helper()
+	var imgs []string
+	for i := 0; i < 3; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("p%d.png", i))
+		writePNG(t, p, makeTestImage(300, 300))
+		imgs = append(imgs, p)
+	}
+	plain := filepath.Join(dir, "plain.pdf")
+	createMultiPagePDFViaImport(t, imgs, plain)
+
+	ctx, err := api.ReadContextFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []types.IndirectRef
+	for i := 1; i <= 3; i++ {
+		_, ir, _, err := ctx.PageDict(i, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, *ir)
+	}
+	newObject := func(o types.Object) types.IndirectRef {
+		ir, err := ctx.IndRefForNewObject(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *ir
+	}
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root["Lang"] = types.StringLiteral("de-CH")
+	root["Dests"] = newObject(types.Dict{"third": types.Array{pages[2], types.Name("Fit")}})
+	info := newObject(types.Dict{"Title": types.StringLiteral("Linked")})
+	ctx.Info = &info
+
+	outlines := types.Dict{"Type": types.Name("Outlines")}
+	outlinesRef := newObject(outlines)
+	first := newObject(types.Dict{
+		"Title":  types.StringLiteral("One"),
+		"Parent": outlinesRef,
+		"Dest":   types.Array{pages[0], types.Name("XYZ"), types.Integer(0), types.Integer(300), types.Integer(0)},
+	})
+	group := types.Dict{"Title": types.StringLiteral("Group"), "Parent": outlinesRef, "Prev": first, "Count": types.Integer(1)}
+	groupRef := newObject(group)
+	second := newObject(types.Dict{"Title": types.StringLiteral("Two"), "Parent": groupRef, "Dest": types.Array{pages[1], types.Name("Fit")}})
+	group["First"], group["Last"] = second, second
+	third := newObject(types.Dict{
+		"Title":  types.StringLiteral("Three"),
+		"Parent": outlinesRef,
+		"Prev":   groupRef,
+		"A":      types.Dict{"S": types.Name("GoTo"), "D": types.Array{pages[2], types.Name("Fit")}},
+	})
+	group["Next"] = third
+	firstDict, _ := ctx.DereferenceDict(first)
+	firstDict["Next"] = groupRef
+	outlines["First"], outlines["Last"], outlines["Count"] = first, third, types.Integer(4)
+	root["Outlines"] = outlinesRef
+
+	page, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page["Annots"] = types.Array{
+		newObject(types.Dict{
+			"Type": types.Name("Annot"), "Subtype": types.Name("Link"),
+			"Rect": types.Array{types.Integer(10), types.Integer(10), types.Integer(50), types.Integer(30)},
+			"Dest": types.Array{pages[1], types.Name("Fit")},
+		}),
+		newObject(types.Dict{
+			"Type": types.Name("Annot"), "Subtype": types.Name("Link"),
+			"Rect": types.Array{types.Integer(60), types.Integer(10), types.Integer(100), types.Integer(30)},
+			"A":    types.Dict{"S": types.Name("GoTo"), "D": types.Name("third")},
+		}),
+	}
+
+	pdfPath := filepath.Join(dir, "linked.pdf")
+	if err := api.WriteContextFile(ctx, pdfPath); err != nil {
+		t.Fatal(err)
+	}
+	return pdfPath
+}
+
+// pageLinks returns the link annotations of a page of ctx.
+func pageLinks(t *testing.T, ctx *model.Context, pageNr int) []types.Dict {
+	t.Helper()
+	d, _, _, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annots, err := ctx.DereferenceArray(d["Annots"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []types.Dict
+	for _, o := range annots {
+		annot, err := ctx.DereferenceDict(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links = append(links, annot)
+	}
+	return links
+}
+
+// outlineTitles returns the titles of the outline of ctx, depth first, with
+// the titles of children indented.
+func outlineTitles(t *testing.T, ctx *model.Context) []string {
+	t.Helper()
+	root, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outlines, err := ctx.DereferenceDict(root["Outlines"])
+	if err != nil || outlines == nil {
+		return nil
+	}
+	var titles []string
+	var walk func(o types.Object, indent string)
+	walk = func(o types.Object, indent string) {
+		for o != nil {
+			d, err := ctx.DereferenceDict(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			title, _ := types.StringOrHexLiteral(d["Title"])
+			titles = append(titles, indent+*title)
+			walk(d["First"], indent+"  ")
+			o = d["Next"]
+		}
+	}
+	walk(outlines["First"], "")
+	return titles
+}
+
+func TestCropPagesToFile_KeepsOutlineLinksAndMetadata(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeLinkedFixture(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	if _, err := CropPagesToFile(pdfPath, outPath, []PageOption{{Number: 0}, {Number: 1}}, DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := api.ReadContextFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Title != "Linked" {
+		t.Errorf("title = %q", ctx.Title)
+	}
+	root, _ := ctx.Catalog()
+	if lang, _ := types.StringOrHexLiteral(root["Lang"]); lang == nil || *lang != "de-CH" {
+		t.Errorf("Lang = %v", root["Lang"])
+	}
+	if got, want := fmt.Sprint(outlineTitles(t, ctx)), "[One Group   Two]"; got != want {
+		t.Errorf("outline = %s, want %s", got, want)
+	}
+
+	links := pageLinks(t, ctx, 1)
+	if len(links) != 1 {
+		t.Fatalf("links = %v, want only the link to page 2", links)
+	}
+	dest, err := ctx.DereferenceArray(links[0]["Dest"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, _, _ := ctx.PageDict(2, false)
+	if ir, ok := dest[0].(types.IndirectRef); !ok || ir.ObjectNumber != second.ObjectNumber {
+		t.Errorf("link goes to %v, want output page 2 %v", dest[0], second)
+	}
+}
+
+func TestCropPages_ExternalLinks(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeLinkedFixture(t, tdir)
+	out := filepath.Join(tdir, "pages", "first.pdf")
+	if err := os.Mkdir(filepath.Dir(out), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Links = LinksExternal
+	results, err := CropPages(pdfPath, []PageOption{{Number: 0, Output: out}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = results[0].Output
+	ctx, err := api.ReadContextFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(outlineTitles(t, ctx)), "[One]"; got != want {
+		t.Errorf("outline = %s, want %s", got, want)
+	}
+	links := pageLinks(t, ctx, 1)
+	if len(links) != 2 {
+		t.Fatalf("links = %v", links)
+	}
+	for i, wantPage := range []int{1, 2} {
+		action, err := ctx.DereferenceDict(links[i]["A"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, _ := types.StringOrHexLiteral(action["F"])
+		d := action.ArrayEntry("D")
+		if s := action.NameEntry("S"); s == nil || *s != "GoToR" || file == nil || *file != "../linked.pdf" || len(d) == 0 || d[0] != types.Integer(wantPage) {
+			t.Errorf("link %d action = %v", i, action)
+		}
+	}
+}
@@ -0,0 +1,756 @@
+package crop
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/matrix"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// StripStats counts what hard cropping removed from a page because it lay
+// entirely outside the crop rectangle.
+type StripStats struct {
+	// Paths are filled or stroked paths.
+	Paths int
+	// Text counts text showing operators.
+	Text int
+	// Images counts image XObjects and inline images.
+	Images int
+	// Forms counts form XObjects.
+	Forms int
+	// Annotations excludes form field widgets, which are always kept.
+	Annotations int
+}
+
+// Total returns the number of removed items.
+func (s StripStats) Total() int {
+	return s.Paths + s.Text + s.Images + s.Forms + s.Annotations
+}
+
+func (s StripStats) String() string {
+	return fmt.Sprintf("paths=%d text=%d images=%d forms=%d annotations=%d", s.Paths, s.Text, s.Images, s.Forms, s.Annotations)
+}
+
+// hardCrop sets the MediaBox and CropBox of a page to target and cuts the
+// TrimBox, BleedBox and ArtBox back to it. With strip it also removes the
+// content and annotations that lie entirely outside target. Content that
+// cannot be parsed is left alone and reported as a warning.
+func hardCrop(ctx *model.Context, pageNumber int, target *types.Rectangle, strip bool) (StripStats, []string, error) {
+	d, _, inhPAttrs, err := ctx.PageDict(pageNumber, false)
+	if err != nil {
+		return StripStats{}, nil, err
+	}
+	if d == nil {
+		return StripStats{}, nil, fmt.Errorf("page %d not found", pageNumber)
+	}
+
+	d.Update("MediaBox", target.Array())
+	d.Update("CropBox", target.Array())
+	for _, key := range []string{"TrimBox", "BleedBox", "ArtBox"} {
+		obj, found := d.Find(key)
+		if !found {
+			continue
+		}
+		box, err := dictRect(ctx, obj)
+		if err != nil {
+			return StripStats{}, nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if clipped := intersectRect(box, target); clipped != nil {
+			d.Update(key, clipped.Array())
+		} else {
+			d.Delete(key)
+		}
+	}
+	if !strip {
+		return StripStats{}, nil, nil
+	}
+
+	var stats StripStats
+	var warnings []string
+	if err := stripPageContent(ctx, pageNumber, d, inhPAttrs.Resources, target, &stats); err != nil {
+		warnings = append(warnings, fmt.Sprintf("content outside the crop not removed: %v", err))
+	}
+	if stats.Annotations, err = stripAnnotations(ctx, d, target); err != nil {
+		return StripStats{}, nil, fmt.Errorf("annotations: %w", err)
+	}
+	return stats, warnings, nil
+}
+
+// stripPageContent replaces the content of a page with a copy that leaves
+// out what lies outside target, and drops the XObjects that are no longer
+// drawn from the page resources.
+func stripPageContent(ctx *model.Context, pageNumber int, d, resources types.Dict, target *types.Rectangle, stats *StripStats) error {
+	src, err := ctx.PageContent(d, pageNumber)
+	if err == model.ErrNoContent {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := readContentResources(ctx, resources)
+	if err != nil {
+		return err
+	}
+	out, err := stripContent(src, target, res)
+	if err != nil {
+		return err
+	}
+	if out.stats.Total() == 0 {
+		return nil
+	}
+
+	// The resources of the page may be shared with other pages or inherited
+	// from the page tree, so the page gets its own copy without the
+	// XObjects it no longer draws.
+	var own types.Dict
+	if len(out.unused) > 0 && resources != nil {
+		xobjects, err := ctx.DereferenceDict(resources["XObject"])
+		if err != nil {
+			return err
+		}
+		if xobjects != nil {
+			kept := xobjects.Clone().(types.Dict)
+			for _, name := range out.unused {
+				kept.Delete(name)
+			}
+			own = resources.Clone().(types.Dict)
+			own["XObject"] = kept
+		}
+	}
+
+	sd, err := ctx.NewStreamDictForBuf(out.content)
+	if err != nil {
+		return err
+	}
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	ir, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+	d["Contents"] = *ir
+	if own != nil {
+		d["Resources"] = own
+	}
+	*stats = out.stats
+	return nil
+}
+
+// stripAnnotations removes the annotations of a page whose Rect lies
+// entirely outside target, along with the popups of removed annotations.
+// Widgets belong to form fields and are kept.
+func stripAnnotations(ctx *model.Context, d types.Dict, target *types.Rectangle) (int, error) {
+	obj, found := d.Find("Annots")
+	if !found {
+		return 0, nil
+	}
+	annots, err := ctx.DereferenceArray(obj)
+	if err != nil || len(annots) == 0 {
+		return 0, err
+	}
+
+	var dropped []bool
+	for _, entry := range annots {
+		annot, err := ctx.DereferenceDict(entry)
+		if err != nil {
+			return 0, err
+		}
+		drop := false
+		if annot != nil && !isWidget(annot) {
+			if rectObj, ok := annot.Find("Rect"); ok {
+				if rect, err := dictRect(ctx, rectObj); err == nil && !overlaps(rect, target) {
+					drop = true
+				}
+			}
+		}
+		dropped = append(dropped, drop)
+	}
+	return removeAnnotations(ctx, d, annots, dropped), nil
+}
+
+// removeAnnotations removes the annotations of a page that dropped marks,
+// along with the popups of removed annotations, and returns how many it
+// removed. annots is the Annots array of the page dict d.
+func removeAnnotations(ctx *model.Context, d types.Dict, annots types.Array, dropped []bool) int {
+	removed := map[types.IndirectRef]bool{}
+	for i, entry := range annots {
+		if ir, ok := entry.(types.IndirectRef); ok && dropped[i] {
+			removed[ir] = true
+		}
+	}
+	for i, entry := range annots {
+		if dropped[i] {
+			continue
+		}
+		annot, _ := ctx.DereferenceDict(entry)
+		if annot == nil || annot.NameEntry("Subtype") == nil || *annot.NameEntry("Subtype") != "Popup" {
+			continue
+		}
+		if parent, ok := annot["Parent"].(types.IndirectRef); ok && removed[parent] {
+			dropped[i] = true
+		}
+	}
+
+	var kept types.Array
+	for i, entry := range annots {
+		if !dropped[i] {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(annots) {
+		return 0
+	}
+	if len(kept) == 0 {
+		d.Delete("Annots")
+	} else {
+		d["Annots"] = kept
+	}
+	return len(annots) - len(kept)
+}
+
+func isWidget(annot types.Dict) bool {
+	subtype := annot.NameEntry("Subtype")
+	return subtype != nil && *subtype == "Widget"
+}
+
+// dictRect reads a rectangle array, which may be an indirect object.
+func dictRect(ctx *model.Context, obj types.Object) (*types.Rectangle, error) {
+	a, err := ctx.DereferenceArray(obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) != 4 {
+		return nil, fmt.Errorf("rectangle with %d values", len(a))
+	}
+	var v [4]float64
+	for i, o := range a {
+		if v[i], err = ctx.DereferenceNumber(o); err != nil {
+			return nil, err
+		}
+	}
+	return types.NewRectangle(math.Min(v[0], v[2]), math.Min(v[1], v[3]), math.Max(v[0], v[2]), math.Max(v[1], v[3])), nil
+}
+
+// dictMatrix reads a matrix array, which may be an indirect object.
+func dictMatrix(ctx *model.Context, obj types.Object) (matrix.Matrix, error) {
+	a, err := ctx.DereferenceArray(obj)
+	if err != nil {
+		return matrix.Matrix{}, err
+	}
+	if len(a) != 6 {
+		return matrix.Matrix{}, fmt.Errorf("matrix with %d values", len(a))
+	}
+	var v [6]float64
+	for i, o := range a {
+		if v[i], err = ctx.DereferenceNumber(o); err != nil {
+			return matrix.Matrix{}, err
+		}
+	}
+	return newMatrix(v[:]), nil
+}
+
+// intersectRect returns the part of a inside b, or nil if they do not
+// overlap.
+func intersectRect(a, b *types.Rectangle) *types.Rectangle {
+	r := types.NewRectangle(
+		math.Max(a.LL.X, b.LL.X),
+		math.Max(a.LL.Y, b.LL.Y),
+		math.Min(a.UR.X, b.UR.X),
+		math.Min(a.UR.Y, b.UR.Y),
+	)
+	if r.LL.X >= r.UR.X || r.LL.Y >= r.UR.Y {
+		return nil
+	}
+	return r
+}
+
+// overlaps reports whether a and b share any point, edges included.
+func overlaps(a, b *types.Rectangle) bool {
+	return a.LL.X <= b.UR.X+boxEpsilon && a.UR.X >= b.LL.X-boxEpsilon &&
+		a.LL.Y <= b.UR.Y+boxEpsilon && a.UR.Y >= b.LL.Y-boxEpsilon
+}
+
+// xobjectExtent is what an XObject can paint, in its own coordinates: the
+// unit square for images and the BBox for forms.
+type xobjectExtent struct {
+	form   bool
+	bbox   *types.Rectangle
+	matrix matrix.Matrix
+	// sharesResources is set for forms without their own resources, which
+	// use those of the page.
+	sharesResources bool
+}
+
+// contentResources are the parts of the page resources that stripping
+// needs.
+type contentResources struct {
+	xobjects map[string]xobjectExtent
+	// opaqueFonts are fonts whose glyphs cannot be placed from the font
+	// size alone: Type 3 fonts and fonts for vertical writing.
+	opaqueFonts map[string]bool
+}
+
+func readContentResources(ctx *model.Context, resources types.Dict) (contentResources, error) {
+	res := contentResources{xobjects: map[string]xobjectExtent{}, opaqueFonts: map[string]bool{}}
+	if resources == nil {
+		return res, nil
+	}
+
+	if obj, found := resources.Find("XObject"); found {
+		xobjects, err := ctx.DereferenceDict(obj)
+		if err != nil {
+			return res, err
+		}
+		for name, obj := range xobjects {
+			sd, _, err := ctx.DereferenceStreamDict(obj)
+			if err != nil || sd == nil {
+				continue
+			}
+			ext := xobjectExtent{bbox: types.NewRectangle(0, 0, 1, 1), matrix: matrix.IdentMatrix}
+			if subtype := sd.Dict.NameEntry("Subtype"); subtype != nil && *subtype == "Form" {
+				bboxObj, found := sd.Dict.Find("BBox")
+				if !found {
+					continue
+				}
+				if ext.bbox, err = dictRect(ctx, bboxObj); err != nil {
+					continue
+				}
+				ext.form = true
+				if m, found := sd.Dict.Find("Matrix"); found {
+					if ext.matrix, err = dictMatrix(ctx, m); err != nil {
+						continue
+					}
+				}
+				_, hasResources := sd.Dict.Find("Resources")
+				ext.sharesResources = !hasResources
+			}
+			res.xobjects[name] = ext
+		}
+	}
+
+	if obj, found := resources.Find("Font"); found {
+		fonts, err := ctx.DereferenceDict(obj)
+		if err != nil {
+			return res, err
+		}
+		for name, obj := range fonts {
+			font, err := ctx.DereferenceDict(obj)
+			if err != nil || font == nil {
+				res.opaqueFonts[name] = true
+				continue
+			}
+			if subtype := font.NameEntry("Subtype"); subtype != nil && *subtype == "Type3" {
+				res.opaqueFonts[name] = true
+			}
+			if encoding, found := font.Find("Encoding"); found {
+				encoding, _ = ctx.Dereference(encoding)
+				switch e := encoding.(type) {
+				case types.Name:
+					if strings.HasSuffix(string(e), "-V") {
+						res.opaqueFonts[name] = true
+					}
+				case types.StreamDict:
+					if wmode := e.Dict.IntEntry("WMode"); wmode != nil && *wmode == 1 {
+						res.opaqueFonts[name] = true
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// strippedContent is the result of stripContent.
+type strippedContent struct {
+	content []byte
+	stats   StripStats
+	// unused are XObjects that were only drawn by removed operators.
+	unused []string
+}
+
+// newMatrix returns the matrix of the operands a b c d e f.
+func newMatrix(v []float64) matrix.Matrix {
+	return matrix.Matrix{{v[0], v[1], 0}, {v[2], v[3], 0}, {v[4], v[5], 1}}
+}
+
+// deviceBox is a bounding box in default user space that grows as points
+// are added.
+type deviceBox struct {
+	set                bool
+	llx, lly, urx, ury float64
+}
+
+func (b *deviceBox) add(p types.Point) {
+	if !b.set {
+		*b = deviceBox{set: true, llx: p.X, lly: p.Y, urx: p.X, ury: p.Y}
+		return
+	}
+	b.llx = math.Min(b.llx, p.X)
+	b.lly = math.Min(b.lly, p.Y)
+	b.urx = math.Max(b.urx, p.X)
+	b.ury = math.Max(b.ury, p.Y)
+}
+
+// addRect adds the corners of r transformed by m.
+func (b *deviceBox) addRect(llx, lly, urx, ury float64, m matrix.Matrix) {
+	for _, p := range []types.Point{{X: llx, Y: lly}, {X: urx, Y: lly}, {X: llx, Y: ury}, {X: urx, Y: ury}} {
+		b.add(m.Transform(p))
+	}
+}
+
+// outside reports whether the box, grown by margin, misses target.
+func (b deviceBox) outside(target *types.Rectangle, margin float64) bool {
+	if !b.set {
+		return false
+	}
+	r := types.NewRectangle(b.llx-margin, b.lly-margin, b.urx+margin, b.ury+margin)
+	return !overlaps(r, target)
+}
+
+// graphicsState holds the parameters stripping follows across q and Q.
+type graphicsState struct {
+	ctm       matrix.Matrix
+	lineWidth float64
+	font      string
+	fontSize  float64
+	charSpace float64
+	wordSpace float64
+	hScale    float64
+	leading   float64
+	rise      float64
+	render    int
+}
+
+// Assumed glyph extents, in text space units of the font size, for deciding
+// whether text can be visible. They are generous so that no visible glyph
+// is taken for hidden.
+const (
+	glyphMaxAdvance = 1.5
+	glyphDescent    = 0.5
+	glyphAscent     = 1.5
+)
+
+// textBlock tracks the text objects between BT and ET. The text position
+// within the current line is known only as a range, because glyph widths
+// are not read from the fonts.
+type textBlock struct {
+	lineMatrix   matrix.Matrix
+	offLo, offHi float64
+	shows        []int
+	visible      bool
+}
+
+// stripContent returns src without the paths, text, images and forms that
+// are drawn entirely outside target. Paths that clip, text in a clipping
+// render mode and anything whose extent is unknown are kept. Text is
+// removed a whole text object at a time, keeping its state operators, so
+// that the position of the remaining text never changes.
+func stripContent(src []byte, target *types.Rectangle, res contentResources) (strippedContent, error) {
+	ops, err := parseContent(src)
+	if err != nil {
+		return strippedContent{}, err
+	}
+
+	drop := make([]bool, len(ops))
+	replace := map[int][]byte{}
+	var stats StripStats
+	gs := graphicsState{ctm: matrix.IdentMatrix, lineWidth: 1, hScale: 1}
+	var stack []graphicsState
+	var path []int
+	var pathBox deviceBox
+	var clip bool
+	var text *textBlock
+	drawn := map[string]bool{}
+	droppedDraws := map[string]bool{}
+	// Once an operator cannot be followed, positions are unknown and
+	// nothing more is removed.
+	lost := false
+
+	nums := func(op contentOp, n int) ([]float64, bool) {
+		if len(op.operands) < n {
+			return nil, false
+		}
+		v := make([]float64, n)
+		for i, tok := range op.operands[len(op.operands)-n:] {
+			if tok.kind != tokNumber {
+				return nil, false
+			}
+			v[i] = tok.num
+		}
+		return v, true
+	}
+	moveLine := func(tx, ty float64) {
+		if text == nil {
+			return
+		}
+		text.lineMatrix = newMatrix([]float64{1, 0, 0, 1, tx, ty}).Multiply(text.lineMatrix)
+		text.offLo, text.offHi = 0, 0
+	}
+	show := func(i int, strs []contentToken) {
+		if text == nil {
+			return
+		}
+		text.shows = append(text.shows, i)
+		if lost || gs.render >= 4 || res.opaqueFonts[gs.font] {
+			text.visible = true
+			return
+		}
+		fs := gs.fontSize
+		lo, hi := text.offLo, text.offHi
+		minPos, maxPos := lo, hi
+		for _, tok := range strs {
+			if tok.kind == tokNumber {
+				adj := -tok.num / 1000 * fs * gs.hScale
+				lo += adj
+				hi += adj
+				minPos = math.Min(minPos, lo)
+				maxPos = math.Max(maxPos, hi)
+				continue
+			}
+			for _, c := range tok.text {
+				extra := gs.charSpace
+				if c == ' ' {
+					extra += gs.wordSpace
+				}
+				a := extra * gs.hScale
+				b := (glyphMaxAdvance*fs + extra) * gs.hScale
+				lo += math.Min(a, b)
+				hi += math.Max(a, b)
+				minPos = math.Min(minPos, lo)
+				maxPos = math.Max(maxPos, hi)
+			}
+		}
+		text.offLo, text.offHi = lo, hi
+
+		pad := glyphMaxAdvance * math.Abs(fs*gs.hScale)
+		y0 := gs.rise - glyphDescent*fs
+		y1 := gs.rise + glyphAscent*fs
+		var box deviceBox
+		box.addRect(minPos-pad, math.Min(y0, y1), maxPos+pad, math.Max(y0, y1), text.lineMatrix.Multiply(gs.ctm))
+		if !box.outside(target, 0) {
+			text.visible = true
+		}
+	}
+	nextLine := func() {
+		moveLine(0, -gs.leading)
+	}
+
+	for i, op := range ops {
+		switch op.op {
+		case "q":
+			stack = append(stack, gs)
+		case "Q":
+			if len(stack) > 0 {
+				gs = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			v, ok := nums(op, 6)
+			if !ok {
+				lost = true
+				continue
+			}
+			gs.ctm = newMatrix(v).Multiply(gs.ctm)
+		case "w":
+			if v, ok := nums(op, 1); ok {
+				gs.lineWidth = v[0]
+			}
+
+		case "m", "l", "c", "v", "y", "re":
+			path = append(path, i)
+			v, ok := nums(op, len(op.operands))
+			if !ok {
+				lost = true
+				continue
+			}
+			if op.op == "re" && len(v) == 4 {
+				pathBox.addRect(v[0], v[1], v[0]+v[2], v[1]+v[3], gs.ctm)
+				continue
+			}
+			for j := 0; j+1 < len(v); j += 2 {
+				pathBox.add(gs.ctm.Transform(types.Point{X: v[j], Y: v[j+1]}))
+			}
+		case "h":
+			path = append(path, i)
+		case "W", "W*":
+			path = append(path, i)
+			clip = true
+		case "S", "s", "f", "F", "f*", "B", "B*", "b", "b*", "n":
+			path = append(path, i)
+			margin := 0.0
+			if op.op != "f" && op.op != "F" && op.op != "f*" && op.op != "n" {
+				// Miter joins reach out up to half the default miter
+				// limit of 10 times the line width.
+				margin = 5*math.Max(gs.lineWidth, 1)*matrixScale(gs.ctm) + 1
+			}
+			if !lost && !clip && op.op != "n" && pathBox.outside(target, margin) {
+				for _, j := range path {
+					drop[j] = true
+				}
+				stats.Paths++
+			}
+			path, pathBox, clip = nil, deviceBox{}, false
+
+		case "Do":
+			if len(op.operands) != 1 || op.operands[0].kind != tokName {
+				continue
+			}
+			name := op.operands[0].name
+			ext, known := res.xobjects[name]
+			var box deviceBox
+			if known {
+				box.addRect(ext.bbox.LL.X, ext.bbox.LL.Y, ext.bbox.UR.X, ext.bbox.UR.Y, ext.matrix.Multiply(gs.ctm))
+			}
+			if !lost && box.outside(target, 0) {
+				drop[i] = true
+				droppedDraws[name] = true
+				if ext.form {
+					stats.Forms++
+				} else {
+					stats.Images++
+				}
+				continue
+			}
+			drawn[name] = true
+		case "BI":
+			var box deviceBox
+			box.addRect(0, 0, 1, 1, gs.ctm)
+			if !lost && box.outside(target, 0) {
+				drop[i] = true
+				stats.Images++
+			}
+
+		case "BT":
+			text = &textBlock{lineMatrix: matrix.IdentMatrix}
+		case "ET":
+			if text != nil && !text.visible {
+				for _, j := range text.shows {
+					switch ops[j].op {
+					case "'":
+						replace[j] = []byte("T*")
+					case "\"":
+						aw, ac := ops[j].operands[0].raw, ops[j].operands[1].raw
+						replace[j] = fmt.Appendf(nil, "%s Tw %s Tc T*", aw, ac)
+					default:
+						drop[j] = true
+					}
+				}
+				stats.Text += len(text.shows)
+			}
+			text = nil
+		case "Tf":
+			if len(op.operands) == 2 && op.operands[0].kind == tokName && op.operands[1].kind == tokNumber {
+				gs.font, gs.fontSize = op.operands[0].name, op.operands[1].num
+			} else {
+				lost = true
+			}
+		case "Tc", "Tw", "Tz", "TL", "Ts", "Tr":
+			v, ok := nums(op, 1)
+			if !ok {
+				lost = true
+				continue
+			}
+			switch op.op {
+			case "Tc":
+				gs.charSpace = v[0]
+			case "Tw":
+				gs.wordSpace = v[0]
+			case "Tz":
+				gs.hScale = v[0] / 100
+			case "TL":
+				gs.leading = v[0]
+			case "Ts":
+				gs.rise = v[0]
+			case "Tr":
+				gs.render = int(v[0])
+			}
+		case "Td", "TD":
+			v, ok := nums(op, 2)
+			if !ok {
+				lost = true
+				continue
+			}
+			if op.op == "TD" {
+				gs.leading = -v[1]
+			}
+			moveLine(v[0], v[1])
+		case "Tm":
+			v, ok := nums(op, 6)
+			if !ok {
+				lost = true
+				continue
+			}
+			if text != nil {
+				text.lineMatrix = newMatrix(v)
+				text.offLo, text.offHi = 0, 0
+			}
+		case "T*":
+			nextLine()
+		case "Tj", "'":
+			if op.op == "'" {
+				nextLine()
+			}
+			show(i, op.operands)
+		case "\"":
+			v, ok := nums(op, 3)
+			if !ok || len(op.operands) != 3 || op.operands[2].kind != tokString {
+				lost = true
+				if text != nil {
+					text.visible = true
+				}
+				continue
+			}
+			gs.wordSpace, gs.charSpace = v[0], v[1]
+			nextLine()
+			show(i, op.operands[2:])
+		case "TJ":
+			if len(op.operands) == 1 && op.operands[0].kind == tokArray {
+				show(i, op.operands[0].elems)
+			} else {
+				show(i, op.operands)
+			}
+		}
+	}
+
+	if stats.Total() == 0 {
+		return strippedContent{content: src}, nil
+	}
+
+	var buf bytes.Buffer
+	for i, op := range ops {
+		if drop[i] {
+			continue
+		}
+		if r, ok := replace[i]; ok {
+			buf.Write(r)
+		} else {
+			buf.Write(src[op.start:op.end])
+		}
+		buf.WriteByte('\n')
+	}
+	out := strippedContent{content: buf.Bytes(), stats: stats}
+	// Forms without resources of their own draw with the page resources,
+	// so none of them can be removed while such a form is still drawn.
+	for name := range drawn {
+		if res.xobjects[name].sharesResources {
+			return out, nil
+		}
+	}
+	for name := range droppedDraws {
+		if !drawn[name] {
+			out.unused = append(out.unused, name)
+		}
+	}
+	return out, nil
+}
+
+// matrixScale returns the largest factor by which m stretches a length.
+func matrixScale(m matrix.Matrix) float64 {
+	return math.Max(math.Hypot(m[0][0], m[0][1]), math.Hypot(m[1][0], m[1][1]))
+}
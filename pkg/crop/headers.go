@@ -0,0 +1,75 @@
+package crop
+
+import "math/bits"
+
+// Limits for treating an edge band as a running head, footer or page number,
+// as fractions of the page height. A band qualifies when it starts or ends
+// within Options.HeaderZone of the edge, is no taller than headerMaxHeight,
+// and is separated from the rest of the content by at least headerMinGap.
+const (
+	headerMaxHeight = 0.05
+	headerMinGap    = 0.02
+)
+
+// rowBand is a horizontal band of rows [y0, y1) in pixel coordinates.
+type rowBand struct {
+	edge   string
+	y0, y1 int
+}
+
+// findHeaderBands returns the isolated bands at the top and bottom edges
+// that look like running heads, footers or page numbers. At least one band of
+// content is always left behind.
+func findHeaderBands(d detectData, zone float64) []rowBand {
+	zonePx := int(zone * float64(d.height))
+	maxPx := int(headerMaxHeight * float64(d.height))
+	gapPx := int(headerMinGap * float64(d.height))
+	if gapPx < 1 {
+		gapPx = 1
+	}
+
+	runs := splitProfile(d.rowCounts, gapPx)
+	var bands []rowBand
+	first, last := 0, len(runs)-1
+	if first < last {
+		r := runs[first]
+		if r[1] <= zonePx && r[1]-r[0] <= maxPx {
+			bands = append(bands, rowBand{edge: "header", y0: r[0], y1: r[1]})
+			first++
+		}
+	}
+	if first < last {
+		r := runs[last]
+		if r[0] >= d.height-zonePx && r[1]-r[0] <= maxPx {
+			bands = append(bands, rowBand{edge: "footer", y0: r[0], y1: r[1]})
+		}
+	}
+	return bands
+}
+
+// excludeRows removes rows [y0, y1) from the detection data as if they were
+// blank, updating the mask and the projections in place.
+func (d *detectData) excludeRows(y0, y1 int) {
+	if y0 < 0 {
+		y0 = 0
+	}
+	if y1 > d.height {
+		y1 = d.height
+	}
+	if y0 >= y1 {
+		return
+	}
+
+	for y := y0; y < y1; y++ {
+		words := d.bits[y*d.stride : (y+1)*d.stride]
+		for i, word := range words {
+			for word != 0 {
+				d.colCounts[i*64+bits.TrailingZeros64(word)]--
+				word &= word - 1
+			}
+			words[i] = 0
+		}
+		d.rowCounts[y] = 0
+	}
+	d.updatePrefixes()
+}
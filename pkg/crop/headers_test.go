@@ -0,0 +1,94 @@
+package crop
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// makeHeaderFooterImage draws a running head at the top, a page number at
+// the bottom and a body block in between, each separated by a wide gap.
+func makeHeaderFooterImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	fill := func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	fill(20, 8, 180, 16)    // running head
+	fill(40, 60, 160, 330)  // body
+	fill(95, 380, 105, 388) // page number
+	return img
+}
+
+func TestFindHeaderBands(t *testing.T) {
+	d := buildDetectData(makeHeaderFooterImage())
+	bands := findHeaderBands(d, 0.1)
+	if len(bands) != 2 {
+		t.Fatalf("expected header and footer bands, got %+v", bands)
+	}
+	if bands[0] != (rowBand{edge: "header", y0: 8, y1: 16}) {
+		t.Errorf("unexpected header band: %+v", bands[0])
+	}
+	if bands[1] != (rowBand{edge: "footer", y0: 380, y1: 388}) {
+		t.Errorf("unexpected footer band: %+v", bands[1])
+	}
+}
+
+func TestFindHeaderBands_KeepsOnlyContent(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	for x := 10; x < 90; x++ {
+		img.Set(x, 3, color.Black)
+	}
+	if bands := findHeaderBands(buildDetectData(img), 0.1); len(bands) != 0 {
+		t.Errorf("expected the only band to be kept, got %+v", bands)
+	}
+}
+
+func TestExcludeRows(t *testing.T) {
+	img := makeHeaderFooterImage()
+	d := buildDetectData(img)
+	d.excludeRows(8, 16)
+
+	if got := d.countNonZero(0, 0, d.width, 40); got != 0 {
+		t.Errorf("expected excluded rows to be blank, got %d", got)
+	}
+	if got, want := d.countNonZero(0, 0, d.width, d.height), 120*270+10*8; got != want {
+		t.Errorf("expected %d remaining pixels, got %d", want, got)
+	}
+	if got := d.countNonZero(40, 60, 160, 330); got != 120*270 {
+		t.Errorf("expected body untouched, got %d", got)
+	}
+	if d.rowCounts[10] != 0 || d.colCounts[30] != 0 || d.colCounts[100] != 270+8 {
+		t.Errorf("unexpected projections after exclusion: row=%d col30=%d col100=%d", d.rowCounts[10], d.colCounts[30], d.colCounts[100])
+	}
+}
+
+func TestAnalyzeFrame_DropHeaders(t *testing.T) {
+	img := makeHeaderFooterImage()
+
+	kept := analyzeFrame(img, Options{Space: 2, Threshold: 0.01, CropFrom: "border"})
+	if kept.top > 0.05 || kept.bottom < 0.95 || len(kept.dropped) != 0 {
+		t.Errorf("expected headers kept by default, got %+v", kept)
+	}
+
+	dropped := analyzeFrame(img, Options{Space: 2, Threshold: 0.01, CropFrom: "border", DropHeaders: true, HeaderZone: 0.1})
+	if dropped.top < 0.14 || dropped.bottom > 0.84 {
+		t.Errorf("expected frame around body only, got top=%.3f bottom=%.3f", dropped.top, dropped.bottom)
+	}
+	if len(dropped.dropped) != 2 || dropped.dropped[0].edge != "header" || dropped.dropped[1].edge != "footer" {
+		t.Errorf("expected header and footer reported, got %+v", dropped.dropped)
+	}
+}
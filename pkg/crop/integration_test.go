@@ -1,14 +1,20 @@
 package crop
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/gen2brain/go-fitz"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
 )
@@ -35,7 +41,7 @@ func makeTestImage(w, h int) *image.RGBA {
 }
 
 // createPDFViaImport creates a single-page PDF from an image using pdfcpu ImportImagesFile.
-func createPDFViaImport(t *testing.T, imgPath, pdfPath string) {
+func createPDFViaImport(t testing.TB, imgPath, pdfPath string) {
 	imp, err := api.Import("", types.POINTS)
 	if err != nil {
 		t.Fatalf("import config: %v", err)
@@ -46,7 +52,7 @@ func createPDFViaImport(t *testing.T, imgPath, pdfPath string) {
 	}
 }
 
-func writePNG(t *testing.T, p string, img image.Image) {
+func writePNG(t testing.TB, p string, img image.Image) {
 	f, err := os.Create(p)
 	if err != nil {
 		t.Fatalf("create png: %v", err)
@@ -477,11 +483,384 @@ func TestPageMediaBox_FallbackA4(t *testing.T) {
 		t.Fatalf("read ctx: %v", err)
 	}
 	// Query a non-existent page number to trigger fallback
-	rect, err := pageMediaBox(ctx, 99)
+	rect, fallback, err := pageMediaBox(ctx, 99)
 	if err != nil {
 		t.Fatalf("pageMediaBox error: %v", err)
 	}
+	if !fallback {
+		t.Fatalf("expected the fallback to be reported")
+	}
 	if int(rect.UR.X-rect.LL.X) != 595 || int(rect.UR.Y-rect.LL.Y) != 842 {
 		t.Fatalf("expected A4 size 595x842, got %dx%d", int(rect.UR.X-rect.LL.X), int(rect.UR.Y-rect.LL.Y))
 	}
 }
+
+func TestPageMediaBox_LaterPage(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "p.png")
+	pdfPath := filepath.Join(tdir, "p.pdf")
+
+	writePNG(t, pngPath, makeTestImage(300, 300))
+	createMultiPagePDFViaImport(t, []string{pngPath, pngPath}, pdfPath)
+
+	ctx, err := api.ReadContextFile(pdfPath)
+	if err != nil {
+		t.Fatalf("read ctx: %v", err)
+	}
+	d, _, _, err := ctx.PageDict(2, false)
+	if err != nil {
+		t.Fatalf("page dict: %v", err)
+	}
+	d["MediaBox"] = types.NewRectangle(0, 0, 400, 500).Array()
+
+	rect, fallback, err := pageMediaBox(ctx, 2)
+	if err != nil {
+		t.Fatalf("pageMediaBox error: %v", err)
+	}
+	if fallback || !rect.Equals(*types.NewRectangle(0, 0, 400, 500)) {
+		t.Fatalf("expected the MediaBox of page 2, got %s (fallback %v)", RectString(rect), fallback)
+	}
+}
+
+func TestCropPages_DropHeadersReportsBands(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "headers.png")
+	pdfPath := filepath.Join(tdir, "headers.pdf")
+
+	writePNG(t, pngPath, makeHeaderFooterImage())
+	createPDFViaImport(t, pngPath, pdfPath)
+
+	opts := Options{DPI: 128, Threshold: 0.01, Space: 2, CropFrom: "center", DropHeaders: true}
+	results, err := CropPages(pdfPath, nil, opts)
+	if err != nil {
+		t.Fatalf("CropPages: %v", err)
+	}
+	res := results[0]
+	if len(res.Dropped) != 2 {
+		t.Fatalf("expected header and footer dropped, got %+v", res.Dropped)
+	}
+	for _, band := range res.Dropped {
+		if band.Rect == nil {
+			t.Fatalf("missing rectangle for dropped %s", band.Edge)
+		}
+		if band.Rect.LL.Y < res.Crop.UR.Y && band.Rect.UR.Y > res.Crop.LL.Y {
+			t.Errorf("dropped %s %s overlaps crop %s", band.Edge, RectString(band.Rect), RectString(res.Crop))
+		}
+	}
+}
+
+func TestCropAllPagesToSingleFile_DeskewCorrectStraightensContent(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "skewed.png")
+	pdfPath := filepath.Join(tdir, "skewed.pdf")
+	outPath := filepath.Join(tdir, "straight.pdf")
+
+	writePNG(t, pngPath, makeSkewedTextImage(400, 500, 2))
+	createPDFViaImport(t, pngPath, pdfPath)
+
+	detected, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "detect.pdf"),
+		Options{DPI: 72, Threshold: 0.02, Space: 2, CropFrom: "border", Deskew: DeskewDetect})
+	if err != nil {
+		t.Fatalf("CropAllPagesToSingleFile detect: %v", err)
+	}
+	if math.Abs(detected[0].Skew-2) > 0.2 {
+		t.Fatalf("expected skew around 2 deg, got %.2f", detected[0].Skew)
+	}
+
+	results, err := CropAllPagesToSingleFile(pdfPath, outPath,
+		Options{DPI: 72, Threshold: 0.02, Space: 2, CropFrom: "border", Deskew: DeskewCorrect})
+	if err != nil {
+		t.Fatalf("CropAllPagesToSingleFile correct: %v", err)
+	}
+	if math.Abs(results[0].Skew-2) > 0.2 {
+		t.Fatalf("expected reported skew around 2 deg, got %.2f", results[0].Skew)
+	}
+	// The straightened page is tighter than the skewed one.
+	if height(results[0].Crop) >= height(detected[0].Crop) {
+		t.Errorf("expected tighter crop after deskew: %s vs %s", RectString(results[0].Crop), RectString(detected[0].Crop))
+	}
+
+	doc, err := fitz.New(outPath)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer doc.Close()
+	img, err := doc.ImageDPI(0, 72)
+	if err != nil {
+		t.Fatalf("render output: %v", err)
+	}
+	if got := estimateSkew(img); math.Abs(got) > 0.2 {
+		t.Errorf("expected straightened output, got skew %.2f", got)
+	}
+}
+
+func TestCropAllPagesToSingleFile_DeskewCorrectSkipsRotatedPages(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "skewed.png")
+	pdfPath := filepath.Join(tdir, "skewed.pdf")
+	rotatedPath := filepath.Join(tdir, "rotated.pdf")
+
+	writePNG(t, pngPath, makeSkewedTextImage(400, 500, 2))
+	createPDFViaImport(t, pngPath, pdfPath)
+	if err := api.RotateFile(pdfPath, rotatedPath, 90, nil, nil); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	results, err := CropAllPagesToSingleFile(rotatedPath, filepath.Join(tdir, "out.pdf"),
+		Options{DPI: 72, Threshold: 0.02, Space: 2, CropFrom: "border", Deskew: DeskewCorrect})
+	if err != nil {
+		t.Fatalf("CropAllPagesToSingleFile: %v", err)
+	}
+	if math.Abs(results[0].Skew) < 1 {
+		t.Errorf("expected the skew to be reported, got %.2f", results[0].Skew)
+	}
+	if len(results[0].Warnings) != 1 || !strings.Contains(results[0].Warnings[0], "rotated") {
+		t.Errorf("warnings = %q, want one about the rotation", results[0].Warnings)
+	}
+}
+
+func height(r *types.Rectangle) float64 {
+	return r.UR.Y - r.LL.Y
+}
+
+func TestCropAllPagesToSingleFile_RefineDPI(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "precise.png")
+	pdfPath := filepath.Join(tdir, "precise.pdf")
+
+	writePNG(t, pngPath, makeTestImage(600, 800))
+	createPDFViaImport(t, pngPath, pdfPath)
+
+	opts := Options{DPI: 36, Threshold: 0.01, Space: 5, CropFrom: "center"}
+	coarse, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "coarse.pdf"), opts)
+	if err != nil {
+		t.Fatalf("coarse crop: %v", err)
+	}
+	opts.RefineDPI = 288
+	fine, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "fine.pdf"), opts)
+	if err != nil {
+		t.Fatalf("refined crop: %v", err)
+	}
+
+	// Content occupies x 180..420 and y 240..560 in PDF coordinates.
+	c, f := coarse[0].Crop, fine[0].Crop
+	if f.LL.X > 180 || f.LL.Y > 240 || f.UR.X < 420 || f.UR.Y < 560 {
+		t.Errorf("refined crop clips content: %s", RectString(f))
+	}
+	if f.LL.X < c.LL.X || f.LL.Y < c.LL.Y || f.UR.X > c.UR.X || f.UR.Y > c.UR.Y {
+		t.Errorf("refined crop %s grew beyond coarse crop %s", RectString(f), RectString(c))
+	}
+	if f.Width() > 241 || f.Height() > 321 {
+		t.Errorf("expected refined crop within a point of the content, got %s", RectString(f))
+	}
+}
+
+func TestCropAllPagesToSingleFile_CoarseToFineMatchesSinglePass(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "c2f.png")
+	pdfPath := filepath.Join(tdir, "c2f.pdf")
+
+	writePNG(t, pngPath, makeTestImage(600, 800))
+	createPDFViaImport(t, pngPath, pdfPath)
+
+	single, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "single.pdf"),
+		Options{DPI: 144, Threshold: 0.01, Space: 2, CropFrom: "center"})
+	if err != nil {
+		t.Fatalf("single pass: %v", err)
+	}
+	c2f, err := CropAllPagesToSingleFile(pdfPath, filepath.Join(tdir, "c2f_out.pdf"),
+		Options{DPI: 144, CoarseDPI: 36, Threshold: 0.01, Space: 2, CropFrom: "center"})
+	if err != nil {
+		t.Fatalf("coarse to fine: %v", err)
+	}
+
+	s, c := single[0].Crop, c2f[0].Crop
+	if math.Abs(s.LL.X-c.LL.X) > 1 || math.Abs(s.LL.Y-c.LL.Y) > 1 || math.Abs(s.UR.X-c.UR.X) > 1 || math.Abs(s.UR.Y-c.UR.Y) > 1 {
+		t.Errorf("coarse-to-fine crop %s differs from single pass %s", RectString(c), RectString(s))
+	}
+}
+
+func TestCropPagesToFile_MixedOrderedPages(t *testing.T) {
+	tdir := t.TempDir()
+	var pngs []string
+	for i, size := range [][2]int{{400, 600}, {500, 500}, {600, 400}} {
+		p := filepath.Join(tdir, fmt.Sprintf("p%d.png", i+1))
+		writePNG(t, p, makeTestImage(size[0], size[1]))
+		pngs = append(pngs, p)
+	}
+	pdfPath := filepath.Join(tdir, "three.pdf")
+	outPath := filepath.Join(tdir, "out", "selected.pdf")
+	createMultiPagePDFViaImport(t, pngs, pdfPath)
+
+	// Page 2 auto first, then page 0 with a manual rect; page 1 is left out.
+	pageOpts := []PageOption{
+		{Number: 2},
+		{Number: 0, Left: 10, Top: 10, Right: 120, Bottom: 120},
+	}
+	opts := Options{DPI: 128, Threshold: 0.05, Space: 5, CropFrom: "center"}
+	results, err := CropPagesToFile(pdfPath, outPath, pageOpts, opts)
+	if err != nil {
+		t.Fatalf("CropPagesToFile: %v", err)
+	}
+	if len(results) != 2 || results[0].PageNo != 2 || results[1].PageNo != 0 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if !results[0].WasAuto || results[1].WasAuto {
+		t.Errorf("expected page 2 auto and page 0 manual")
+	}
+
+	ctx, err := api.ReadContextFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if ctx.PageCount != 2 {
+		t.Fatalf("expected 2 output pages, got %d", ctx.PageCount)
+	}
+	boxes, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatalf("page boundaries: %v", err)
+	}
+	for i, r := range results {
+		if r.Output != outPath {
+			t.Errorf("result %d: Output = %q, want %q", i, r.Output, outPath)
+		}
+		if got := RectString(boxes[i].CropBox()); got != RectString(r.Crop) {
+			t.Errorf("output page %d: CropBox %s, want %s", i+1, got, RectString(r.Crop))
+		}
+		if got := RectString(boxes[i].MediaBox()); got != RectString(r.Media) {
+			t.Errorf("output page %d: MediaBox %s, want %s", i+1, got, RectString(r.Media))
+		}
+	}
+}
+
+func TestCropPagesToFile_RejectsDuplicatePages(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "d.png")
+	pdfPath := filepath.Join(tdir, "d.pdf")
+	writePNG(t, pngPath, makeTestImage(300, 300))
+	createPDFViaImport(t, pngPath, pdfPath)
+
+	_, err := CropPagesToFile(pdfPath, filepath.Join(tdir, "out.pdf"), []PageOption{{Number: 0}, {Number: 0}}, DefaultOptions())
+	if err == nil {
+		t.Fatalf("expected error for duplicate page")
+	}
+	if _, err := CropPagesToFile(pdfPath, "", nil, DefaultOptions()); err == nil {
+		t.Fatalf("expected error for missing output")
+	}
+}
+
+func TestCropPages_OutputTemplate(t *testing.T) {
+	tdir := t.TempDir()
+	p1 := filepath.Join(tdir, "a.png")
+	p2 := filepath.Join(tdir, "b.png")
+	pdfPath := filepath.Join(tdir, "book.pdf")
+	outDir := filepath.Join(tdir, "out")
+	writePNG(t, p1, makeTestImage(300, 400))
+	writePNG(t, p2, makeTestImage(400, 300))
+	createMultiPagePDFViaImport(t, []string{p1, p2}, pdfPath)
+
+	opts := DefaultOptions()
+	opts.OutputTemplate = "{dir}/{name}_p{page1:03}{ext}"
+	opts.OutputDir = outDir
+	results, err := CropPages(pdfPath, nil, opts)
+	if err != nil {
+		t.Fatalf("CropPages: %v", err)
+	}
+	for i, r := range results {
+		want := filepath.Join(outDir, fmt.Sprintf("book_p%03d.pdf", i+1))
+		if r.Output != want {
+			t.Errorf("page %d: Output = %q, want %q", r.PageNo, r.Output, want)
+		}
+		if _, err := os.Stat(want); err != nil {
+			t.Errorf("page %d: expected output file: %v", r.PageNo, err)
+		}
+	}
+}
+
+func TestCropPages_LegacyOutputNames(t *testing.T) {
+	tdir := t.TempDir()
+	pngPath := filepath.Join(tdir, "a.png")
+	pdfPath := filepath.Join(tdir, "book.pdf")
+	writePNG(t, pngPath, makeTestImage(300, 300))
+	createMultiPagePDFViaImport(t, []string{pngPath, pngPath}, pdfPath)
+
+	given := filepath.Join(tdir, "given.pdf")
+	results, err := CropPages(pdfPath, []PageOption{{Number: 0, Output: given}, {Number: 1}}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("CropPages: %v", err)
+	}
+	want := []string{
+		filepath.Join(tdir, "given.pdf_page_1.pdf"),
+		filepath.Join(tdir, "book - page  1.pdf_page_2.pdf"),
+	}
+	for i, r := range results {
+		if r.Output != want[i] {
+			t.Errorf("page %d: Output = %q, want %q", r.PageNo, r.Output, want[i])
+		}
+		if _, err := os.Stat(want[i]); err != nil {
+			t.Errorf("page %d: expected output file: %v", r.PageNo, err)
+		}
+	}
+}
+
+func TestCropPages_LoggerDebugRecords(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+
+	var buf bytes.Buffer
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if _, err := CropPages(pdfPath, []PageOption{{Number: 0, Output: filepath.Join(tdir, "p.pdf")}}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad record %q: %v", line, err)
+		}
+		records[rec["msg"].(string)] = rec
+	}
+	detect, ok := records["detect"]
+	if !ok {
+		t.Fatalf("no detect record in %s", buf.String())
+	}
+	for _, key := range []string{"page", "dpi", "width", "height", "center_x", "threshold_w", "frame"} {
+		if _, ok := detect[key]; !ok {
+			t.Errorf("detect record missing %q", key)
+		}
+	}
+	cropped, ok := records["page cropped"]
+	if !ok {
+		t.Fatalf("no page record in %s", buf.String())
+	}
+	if cropped["media_fallback"] != false || cropped["page"] != 0.0 {
+		t.Errorf("page record = %v", cropped)
+	}
+}
+
+func TestFallbackMediaBox_PrefersMuPDF(t *testing.T) {
+	tdir := t.TempDir()
+	d, err := OpenDocument(writeFixturePDF(t, tdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	rect, warning := fallbackMediaBox(d.doc, 0)
+	if rect.Width() != 300 || rect.Height() != 300 {
+		t.Errorf("expected the 300x300 MuPDF size, got %s", RectString(rect))
+	}
+	if !strings.Contains(warning, "MuPDF") {
+		t.Errorf("warning = %q", warning)
+	}
+
+	rect, warning = fallbackMediaBox(d.doc, 5)
+	if rect.Width() != 595 || rect.Height() != 842 {
+		t.Errorf("expected A4 without a MuPDF size, got %s", RectString(rect))
+	}
+	if !strings.Contains(warning, "A4") {
+		t.Errorf("warning = %q", warning)
+	}
+}
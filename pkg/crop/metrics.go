@@ -0,0 +1,86 @@
+package crop
+
+import (
+	"io"
+	"time"
+)
+
+// Pipeline stages whose latency is reported to Metrics.
+const (
+	// StageRender is rasterizing a page for detection.
+	StageRender = "render"
+	// StageDetect is finding the crop in a rendered page, including
+	// deskewing and any strips re-rendered for refinement.
+	StageDetect = "detect"
+	// StageWrite is serializing and saving an output PDF.
+	StageWrite = "write"
+)
+
+// Failure kinds reported to Metrics.
+const (
+	FailureOpen     = "open"
+	FailureRender   = "render"
+	FailureDetect   = "detect"
+	FailureBoxes    = "boxes"
+	FailureWrite    = "write"
+	FailureCanceled = "canceled"
+)
+
+// Metrics receives instrumentation from the crop pipeline through
+// Options.Metrics. Implementations must be safe for concurrent use. The file
+// based entry points report input and output sizes themselves; callers of
+// NewDocument and Document.Write know theirs.
+type Metrics interface {
+	// PageProcessed is called once per page whose crop was set.
+	PageProcessed()
+	// ObserveStage reports how long one pass through stage took.
+	ObserveStage(stage string, d time.Duration)
+	// Failure counts a failed operation of the given kind.
+	Failure(kind string)
+	// BytesRead reports the size of an input document.
+	BytesRead(n int)
+	// BytesWritten reports the size of an output document.
+	BytesWritten(n int)
+}
+
+// NoMetrics is a Metrics that discards everything. It stands in for a nil
+// Options.Metrics.
+type NoMetrics struct{}
+
+func (NoMetrics) PageProcessed()                     {}
+func (NoMetrics) ObserveStage(string, time.Duration) {}
+func (NoMetrics) Failure(string)                     {}
+func (NoMetrics) BytesRead(int)                      {}
+func (NoMetrics) BytesWritten(int)                   {}
+
+// metrics returns opts.Metrics, or a no-op implementation when it is nil.
+func (opts Options) metrics() Metrics {
+	if opts.Metrics == nil {
+		return NoMetrics{}
+	}
+	return opts.Metrics
+}
+
+// openDocument opens the PDF at path like OpenDocument and reports its size,
+// or the failure, to opts.Metrics.
+func openDocument(path string, opts Options) (*Document, error) {
+	d, err := OpenDocumentWithPassword(path, opts.Password, opts.OwnerPassword)
+	if err != nil {
+		opts.metrics().Failure(FailureOpen)
+		return nil, err
+	}
+	opts.metrics().BytesRead(len(d.data))
+	return d, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,110 @@
+package crop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	now := time.Date(2024, 3, 9, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		template  string
+		inputFile string
+		outputDir string
+		pageNo    int
+		expected  string
+	}{
+		{
+			name:      "Zero-padded page in input dir",
+			template:  "{dir}/{name}_p{page:03}.pdf",
+			inputFile: "/scans/book.pdf",
+			pageNo:    7,
+			expected:  "/scans/book_p007.pdf",
+		},
+		{
+			name:      "One-based page",
+			template:  "{name}-{page1}{ext}",
+			inputFile: "/scans/book.PDF",
+			pageNo:    0,
+			expected:  "/scans/book-1.PDF",
+		},
+		{
+			name:      "Relative template goes to output dir",
+			template:  "{name}.cropped.pdf",
+			inputFile: "/scans/book.pdf",
+			outputDir: "/out",
+			pageNo:    noPage,
+			expected:  "/out/book.cropped.pdf",
+		},
+		{
+			name:      "Dir placeholder uses output dir",
+			template:  "{dir}/{date}/{stem}.pdf",
+			inputFile: "in/report.v2.pdf",
+			outputDir: "out",
+			pageNo:    noPage,
+			expected:  "out/2024-03-09/report.v2.pdf",
+		},
+		{
+			name:      "Space padding",
+			template:  "{name} - page {page:2}.pdf",
+			inputFile: "document.pdf",
+			pageNo:    5,
+			expected:  "document - page  5.pdf",
+		},
+		{
+			name:      "Absolute template",
+			template:  "/tmp/{name}.pdf",
+			inputFile: "/scans/book.pdf",
+			outputDir: "/out",
+			pageNo:    noPage,
+			expected:  "/tmp/book.pdf",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExpandTemplate(tt.template, tt.inputFile, tt.outputDir, tt.pageNo, now)
+			if err != nil {
+				t.Fatalf("ExpandTemplate(%q): %v", tt.template, err)
+			}
+			if result != tt.expected {
+				t.Errorf("ExpandTemplate(%q, %q, %q, %d) = %q, expected %q",
+					tt.template, tt.inputFile, tt.outputDir, tt.pageNo, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidTemplate(t *testing.T) {
+	valid := []struct {
+		template string
+		perPage  bool
+	}{
+		{"{dir}/{name}_p{page:03}.pdf", true},
+		{"{name}.cropped.pdf", false},
+		{DefaultDocumentTemplate, false},
+	}
+	for _, tt := range valid {
+		if err := ValidTemplate(tt.template, tt.perPage); err != nil {
+			t.Errorf("ValidTemplate(%q, %v): %v", tt.template, tt.perPage, err)
+		}
+	}
+
+	invalid := []struct {
+		template string
+		perPage  bool
+	}{
+		{"", true},
+		{"{name", true},
+		{"{title}.pdf", true},
+		{"{page:x3}.pdf", true},
+		{"{name:3}.pdf", true},
+		{"{name}_{page}.pdf", false},
+	}
+	for _, tt := range invalid {
+		if err := ValidTemplate(tt.template, tt.perPage); err == nil {
+			t.Errorf("ValidTemplate(%q, %v): expected error", tt.template, tt.perPage)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package crop
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestProvenance_PropertiesRoundTrip(t *testing.T) {
+	results := []PageResult{
+		{PageNo: 2, Crop: types.NewRectangle(10.5, 20, 300, 400.25), WasAuto: true},
+		{PageNo: 0, Crop: types.NewRectangle(0, 0, 100, 100)},
+		{PageNo: 1},
+	}
+	opts := Options{CropFrom: "border", DPI: 200, Threshold: 0.02}
+	now := time.Date(2024, 5, 6, 7, 8, 9, 500, time.FixedZone("CEST", 2*3600))
+	props, err := newProvenance(results, opts, now).properties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parseProvenance(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != Version || p.Mode != "border" || p.DPI != 200 || p.Threshold != 0.02 {
+		t.Errorf("provenance = %+v", p)
+	}
+	if !p.Time.Equal(now.Truncate(time.Second)) {
+		t.Errorf("time = %v, want %v", p.Time, now)
+	}
+	if len(p.Pages) != 3 || p.Pages[0].PageNo != 2 || !p.Pages[0].Auto || p.Pages[1].Auto {
+		t.Fatalf("pages = %+v", p.Pages)
+	}
+	if !p.Pages[0].Crop.Equals(*results[0].Crop) {
+		t.Errorf("crop = %v, want %v", p.Pages[0].Crop, results[0].Crop)
+	}
+	if p.Pages[2].Crop != nil || strings.Count(props[provenancePages], "crop") != 2 {
+		t.Errorf("page without a crop: %v in %s", p.Pages[2].Crop, props[provenancePages])
+	}
+
+	if _, err := parseProvenance(map[string]string{"Title": "x"}); !errors.Is(err, ErrNoProvenance) {
+		t.Errorf("without provenance: %v", err)
+	}
+	props[provenancePages] = "[{"
+	if _, err := parseProvenance(props); err == nil {
+		t.Error("broken pages: expected error")
+	}
+}
+
+func TestCropAllPagesToSingleFile_Provenance(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+
+	opts := DefaultOptions()
+	opts.Provenance = true
+	results, err := CropAllPagesToSingleFile(pdfPath, outPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadProvenance(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Mode != "center/median" || p.DPI != 128 || p.Threshold != 0.008 || time.Since(p.Time) > time.Minute {
+		t.Errorf("provenance = %+v", p)
+	}
+	if len(p.Pages) != 1 || !p.Pages[0].Auto || !p.Pages[0].Crop.Equals(*results[0].Crop) {
+		t.Errorf("pages = %+v, want the crop %v", p.Pages, results[0].Crop)
+	}
+
+	if _, err := ReadProvenance(pdfPath); !errors.Is(err, ErrNoProvenance) {
+		t.Errorf("uncropped input: %v", err)
+	}
+}
+
+func TestCropPages_ProvenancePerPage(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	out := filepath.Join(tdir, "page.pdf")
+
+	opts := DefaultOptions()
+	opts.Provenance = true
+	page := PageOption{Number: 0, Left: 10, Top: 20, Right: 110, Bottom: 120, Output: out}
+	results, err := CropPages(pdfPath, []PageOption{page}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = results[0].Output
+	p, err := ReadProvenance(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Pages) != 1 || p.Pages[0].Auto || !p.Pages[0].Crop.Equals(*types.NewRectangle(10, 180, 110, 280)) {
+		t.Errorf("pages = %+v", p.Pages)
+	}
+}
@@ -0,0 +1,194 @@
+package crop
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"math"
+
+	"github.com/gen2brain/go-fitz"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// regionRenderer rasterizes rectangular regions of a single page.
+type regionRenderer struct {
+	page []byte
+}
+
+// newRegionRenderer extracts page pageNumber of ctx, including any content
+// changes made so far, so that regions of it can be rendered on their own.
+// With annotations the appearances of its annotations are drawn too.
+func newRegionRenderer(ctx *model.Context, pageNumber int, annotations bool) (*regionRenderer, error) {
+	r, err := api.ExtractPage(ctx, pageNumber)
+	if err != nil {
+		return nil, err
+	}
+	page, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if annotations {
+		pageCtx, err := api.ReadAndValidate(bytes.NewReader(page), model.NewDefaultConfiguration())
+		if err != nil {
+			return nil, err
+		}
+		if err := drawAnnotations(pageCtx, 1); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := api.WriteContext(pageCtx, &buf); err != nil {
+			return nil, err
+		}
+		page = buf.Bytes()
+	}
+	return &regionRenderer{page: page}, nil
+}
+
+// render rasterizes region, given in PDF user space, at dpi. MuPDF renders
+// only the CropBox of a page, so the region is set as the CropBox of a
+// throwaway copy of the page.
+func (r *regionRenderer) render(region *types.Rectangle, dpi float64) (*image.RGBA, error) {
+	ctx, err := api.ReadAndValidate(bytes.NewReader(r.page), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, err
+	}
+	if err := setCropBox(ctx, 1, region); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := api.WriteContext(ctx, &buf); err != nil {
+		return nil, err
+	}
+
+	doc, err := fitz.NewFromMemory(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+	return doc.ImageDPI(0, dpi)
+}
+
+// refineFrame tightens each edge of rect by rendering a narrow strip just
+// inside it at opts.RefineDPI, one clipped render per edge, and moving the
+// edge to the outermost content found in its strip. The coarse frame from
+// detection never clips content, so edges only move inward; an edge whose
+// strip is blank is left where it was.
+func refineFrame(ctx *model.Context, pageNumber int, media, rect *types.Rectangle, opts Options) (*types.Rectangle, error) {
+	if rect.Width() <= 0 || rect.Height() <= 0 {
+		return rect, nil
+	}
+	renderer, err := newRegionRenderer(ctx, pageNumber, opts.DetectAnnotations)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detection can leave up to Space coarse pixels of whitespace inside
+	// each edge; search one pixel more than that.
+	depth := float64(opts.Space+1) * 72 / opts.DPI
+	depthX := math.Min(depth, rect.Width()/2)
+	depthY := math.Min(depth, rect.Height()/2)
+
+	top := types.NewRectangle(rect.LL.X, rect.UR.Y-depthY, rect.UR.X, rect.UR.Y)
+	bottom := types.NewRectangle(rect.LL.X, rect.LL.Y, rect.UR.X, rect.LL.Y+depthY)
+	left := types.NewRectangle(rect.LL.X, rect.LL.Y, rect.LL.X+depthX, rect.UR.Y)
+	right := types.NewRectangle(rect.UR.X-depthX, rect.LL.Y, rect.UR.X, rect.UR.Y)
+	// The bottom and right strips are the size of the top and left ones.
+	for _, strip := range []*types.Rectangle{top, left} {
+		if err := checkPixels(strip, opts.RefineDPI, opts.MaxPixels); err != nil {
+			return nil, err
+		}
+	}
+
+	llx, lly, urx, ury := rect.LL.X, rect.LL.Y, rect.UR.X, rect.UR.Y
+	img, scale, err := renderer.renderStrip(top, opts.RefineDPI, false)
+	if err != nil {
+		return nil, err
+	}
+	if row := firstContentRow(img, false); row >= 0 {
+		ury = top.UR.Y - float64(row)*scale
+	}
+	if img, scale, err = renderer.renderStrip(bottom, opts.RefineDPI, false); err != nil {
+		return nil, err
+	}
+	if row := firstContentRow(img, true); row >= 0 {
+		lly = bottom.UR.Y - float64(row+1)*scale
+	}
+	if img, scale, err = renderer.renderStrip(left, opts.RefineDPI, true); err != nil {
+		return nil, err
+	}
+	if col := firstContentCol(img, false); col >= 0 {
+		llx = left.LL.X + float64(col)*scale
+	}
+	if img, scale, err = renderer.renderStrip(right, opts.RefineDPI, true); err != nil {
+		return nil, err
+	}
+	if col := firstContentCol(img, true); col >= 0 {
+		urx = right.LL.X + float64(col+1)*scale
+	}
+	return roundOutward(types.NewRectangle(llx, lly, urx, ury), media), nil
+}
+
+// renderStrip renders strip at dpi and returns the image with the size in
+// points of one pixel across the strip, which runs down the page when
+// vertical is set and along it otherwise. The size is 0 for a strip too thin
+// to render.
+func (r *regionRenderer) renderStrip(strip *types.Rectangle, dpi float64, vertical bool) (*image.RGBA, float64, error) {
+	img, err := r.render(strip, dpi)
+	if err != nil {
+		return nil, 0, err
+	}
+	if img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+		return img, 0, nil
+	}
+	if vertical {
+		return img, strip.Width() / float64(img.Bounds().Dx()), nil
+	}
+	return img, strip.Height() / float64(img.Bounds().Dy()), nil
+}
+
+// firstContentRow returns the index of the first row with a non-white pixel,
+// scanning from the top, or from the bottom when fromBottom is set. It
+// returns -1 for a blank image.
+func firstContentRow(img *image.RGBA, fromBottom bool) int {
+	height := img.Bounds().Dy()
+	width := img.Bounds().Dx()
+	for i := 0; i < height; i++ {
+		y := i
+		if fromBottom {
+			y = height - 1 - i
+		}
+		for x := 0; x < width; x++ {
+			if isNonWhite(img, x, y) {
+				return y
+			}
+		}
+	}
+	return -1
+}
+
+// firstContentCol returns the index of the first column with a non-white
+// pixel, scanning from the left, or from the right when fromRight is set. It
+// returns -1 for a blank image.
+func firstContentCol(img *image.RGBA, fromRight bool) int {
+	height := img.Bounds().Dy()
+	width := img.Bounds().Dx()
+	for i := 0; i < width; i++ {
+		x := i
+		if fromRight {
+			x = width - 1 - i
+		}
+		for y := 0; y < height; y++ {
+			if isNonWhite(img, x, y) {
+				return x
+			}
+		}
+	}
+	return -1
+}
+
+func isNonWhite(img *image.RGBA, x, y int) bool {
+	idx := y*img.Stride + x*4
+	return img.Pix[idx] != 255 || img.Pix[idx+1] != 255 || img.Pix[idx+2] != 255 || img.Pix[idx+3] != 255
+}
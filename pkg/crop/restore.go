@@ -0,0 +1,153 @@
+package crop
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// originalBoxesKey is the private page dictionary entry in which cropping
+// records the boxes a page had before it was first cropped. Its value is a
+// dictionary with the MediaBox, CropBox, TrimBox, BleedBox and ArtBox the
+// page had; boxes it did not have are left out.
+const originalBoxesKey = "PDFCropOriginalBoxes"
+
+// ErrNoRecord is returned by Restore for a page that holds no record of its
+// original boxes, because it was not cropped by this package or was cropped
+// by a version that did not record them.
+var ErrNoRecord = errors.New("no record of the original page boxes")
+
+// restoredBoxes are the boxes Restore sets or removes, in page order.
+var restoredBoxes = []string{"MediaBox", "CropBox", "TrimBox", "BleedBox", "ArtBox"}
+
+// recordOriginalBoxes stores the current boxes of a page under
+// originalBoxesKey unless an earlier crop already did, so that the record
+// always holds the boxes from before the first crop.
+func recordOriginalBoxes(ctx *model.Context, pageNumber int) error {
+	d, _, inhPAttrs, err := ctx.PageDict(pageNumber, false)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return fmt.Errorf("page %d not found", pageNumber)
+	}
+	if _, found := d.Find(originalBoxesKey); found {
+		return nil
+	}
+
+	record := types.Dict{}
+	// MediaBox and CropBox may be inherited from the page tree; the record
+	// holds the values in effect.
+	if inhPAttrs.MediaBox != nil {
+		record["MediaBox"] = inhPAttrs.MediaBox.Array()
+	}
+	if inhPAttrs.CropBox != nil {
+		record["CropBox"] = inhPAttrs.CropBox.Array()
+	}
+	for _, key := range []string{"TrimBox", "BleedBox", "ArtBox"} {
+		obj, found := d.Find(key)
+		if !found {
+			continue
+		}
+		box, err := dictRect(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		record[key] = box.Array()
+	}
+	d[originalBoxesKey] = record
+	return nil
+}
+
+// Restore puts back the boxes that the given pages of inputFile had before
+// they were cropped, and writes the result to outputFile. Pages are
+// zero-based; no pages selects every page. Every selected page must carry
+// a record of its original boxes, or Restore fails with ErrNoRecord before
+// writing anything. Content removed by Options.StripHidden or straightened
+// by deskewing is not restored. Options.Overwrite and Options.InPlace apply
+// to outputFile as for cropping.
+func Restore(inputFile, outputFile string, pages []int, opts Options) ([]PageResult, error) {
+	if outputFile == "" {
+		return nil, fmt.Errorf("output file is required")
+	}
+	if err := checkOutput(inputFile, outputFile, opts); err != nil {
+		return nil, err
+	}
+
+	d, err := openDocument(inputFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	if len(pages) == 0 {
+		pages = make([]int, d.NumPage())
+		for i := range pages {
+			pages[i] = i
+		}
+	}
+	records := make([]types.Dict, len(pages))
+	for i, pageNo := range pages {
+		if pageNo < 0 || pageNo >= d.NumPage() {
+			return nil, fmt.Errorf("page no exceed the page number")
+		}
+		pd, _, _, err := d.ctx.PageDict(pageNo+1, false)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", pageNo, err)
+		}
+		obj, found := pd.Find(originalBoxesKey)
+		if !found {
+			return nil, fmt.Errorf("page %d: %w", pageNo, ErrNoRecord)
+		}
+		if records[i], err = d.ctx.DereferenceDict(obj); err != nil {
+			return nil, fmt.Errorf("page %d: %w", pageNo, err)
+		}
+	}
+
+	log := opts.logger()
+	results := make([]PageResult, 0, len(pages))
+	for i, pageNo := range pages {
+		if err := restorePage(d.ctx, pageNo+1, records[i]); err != nil {
+			return nil, fmt.Errorf("page %d restore: %w", pageNo, err)
+		}
+		media, _, err := pageMediaBox(d.ctx, pageNo+1)
+		if err != nil {
+			return nil, fmt.Errorf("page %d mediabox: %w", pageNo, err)
+		}
+		res := PageResult{PageNo: pageNo, Media: media, Crop: pageCropBox(d.ctx, pageNo+1, media), Output: outputFile}
+		log.Debug("page restored", "page", pageNo, "media", preciseRectString(res.Media), "crop", preciseRectString(res.Crop))
+		results = append(results, res)
+	}
+	if err := writeOutput(d.ctx, inputFile, outputFile, opts); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// restorePage sets the boxes of a page to those in record, removes the
+// boxes record does not list, and then removes the record itself. A
+// MediaBox is only ever replaced, since a page needs one.
+func restorePage(ctx *model.Context, pageNumber int, record types.Dict) error {
+	d, _, _, err := ctx.PageDict(pageNumber, false)
+	if err != nil {
+		return err
+	}
+	for _, key := range restoredBoxes {
+		obj, found := record.Find(key)
+		if !found {
+			if key != "MediaBox" {
+				d.Delete(key)
+			}
+			continue
+		}
+		box, err := dictRect(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		d.Update(key, box.Array())
+	}
+	d.Delete(originalBoxesKey)
+	return nil
+}
@@ -0,0 +1,127 @@
+package crop
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func readPageBoundaries(t *testing.T, path string) (*model.Context, []model.PageBoundaries) {
+	t.Helper()
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, pages
+}
+
+func TestRestore_UndoesCrop(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	first := filepath.Join(tdir, "first.pdf")
+	second := filepath.Join(tdir, "second.pdf")
+	restored := filepath.Join(tdir, "restored.pdf")
+
+	opts := DefaultOptions()
+	opts.Boxes = []string{BoxCrop, BoxTrim}
+	if _, err := CropAllPagesToSingleFile(pdfPath, first, opts); err != nil {
+		t.Fatal(err)
+	}
+	// Cropping again, harder, must not replace the record of the original.
+	opts.HardCrop = true
+	if _, err := CropAllPagesToSingleFile(first, second, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := Restore(second, restored, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := types.RectForDim(300, 300)
+	if len(results) != 1 || !results[0].Media.Equals(*page) || !results[0].Crop.Equals(*page) {
+		t.Fatalf("results = %+v", results)
+	}
+
+	ctx, pages := readPageBoundaries(t, restored)
+	if !pages[0].MediaBox().Equals(*page) {
+		t.Errorf("MediaBox = %v", pages[0].MediaBox())
+	}
+	if pages[0].Crop != nil && !pages[0].Crop.Rect.Equals(*page) {
+		t.Errorf("CropBox = %v", pages[0].Crop.Rect)
+	}
+	d, _, _, err := ctx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"TrimBox", originalBoxesKey} {
+		if _, found := d.Find(key); found {
+			t.Errorf("%s left on the restored page", key)
+		}
+	}
+	if _, err := Restore(restored, filepath.Join(tdir, "again.pdf"), nil, Options{}); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("restoring twice: %v", err)
+	}
+}
+
+func TestRestore_SelectedPages(t *testing.T) {
+	tdir := t.TempDir()
+	var imgs []string
+	for i := 0; i < 2; i++ {
+		p := filepath.Join(tdir, fmt.Sprintf("p%d.png", i))
+		writePNG(t, p, makeTestImage(300, 300))
+		imgs = append(imgs, p)
+	}
+	pdfPath := filepath.Join(tdir, "multi.pdf")
+	createMultiPagePDFViaImport(t, imgs, pdfPath)
+	cropped := filepath.Join(tdir, "cropped.pdf")
+	restored := filepath.Join(tdir, "restored.pdf")
+
+	results, err := CropAllPagesToSingleFile(pdfPath, cropped, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(cropped, restored, []int{1}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	_, pages := readPageBoundaries(t, restored)
+	if !pages[0].CropBox().Equals(*results[0].Crop) {
+		t.Errorf("page 0 CropBox = %v, want it still cropped to %v", pages[0].CropBox(), results[0].Crop)
+	}
+	if crop := pages[1].CropBox(); crop != nil && !crop.Equals(*types.RectForDim(300, 300)) {
+		t.Errorf("page 1 CropBox = %v, want it restored", crop)
+	}
+
+	if _, err := Restore(restored, filepath.Join(tdir, "again.pdf"), []int{0, 1}, Options{}); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("page without a record: %v", err)
+	}
+	if _, err := Restore(cropped, filepath.Join(tdir, "bad.pdf"), []int{5}, Options{}); err == nil {
+		t.Error("page out of range: expected error")
+	}
+}
+
+func TestRestore_PerPageOutput(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	out := filepath.Join(tdir, "page.pdf")
+	results, err := CropPages(pdfPath, []PageOption{{Number: 0, Output: out}}, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = results[0].Output
+	if _, err := Restore(out, out, nil, Options{InPlace: true}); err != nil {
+		t.Fatal(err)
+	}
+	_, pages := readPageBoundaries(t, out)
+	if crop := pages[0].CropBox(); crop != nil && !crop.Equals(*types.RectForDim(300, 300)) {
+		t.Errorf("CropBox = %v after restoring a per-page output", crop)
+	}
+}
@@ -2,4 +2,4 @@ package crop
 
 // Version is the library version. Override at build time with:
 // go build -ldflags "-X pdf-crop/pkg/crop.Version=vX.Y.Z"
-const Version = "v0.0.1"
+var Version = "v0.0.1"
@@ -0,0 +1,232 @@
+package crop
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Overwrite policies select what happens when an output file already exists.
+const (
+	// OverwriteReplace replaces the existing file.
+	OverwriteReplace = "overwrite"
+	// OverwriteNever fails with ErrOutputExists.
+	OverwriteNever = "no-clobber"
+	// OverwriteBackup renames the existing file to <name>.bak, replacing an
+	// older backup, before writing the new one.
+	OverwriteBackup = "backup"
+)
+
+var (
+	// ErrOutputExists is returned under OverwriteNever when an output file
+	// already exists.
+	ErrOutputExists = errors.New("output file exists")
+	// ErrInPlace is returned when an output would replace the input file
+	// and Options.InPlace is not set.
+	ErrInPlace = errors.New("output would replace the input file")
+)
+
+// ValidOverwrite reports whether policy names a known overwrite policy. The
+// empty string selects OverwriteReplace.
+func ValidOverwrite(policy string) bool {
+	switch policy {
+	case "", OverwriteReplace, OverwriteNever, OverwriteBackup:
+		return true
+	}
+	return false
+}
+
+// backupFile returns the name an existing output is moved to under
+// OverwriteBackup.
+func backupFile(output string) string {
+	return output + ".bak"
+}
+
+// checkOutput reports whether output may be written under opts. Writing over
+// the input file requires opts.InPlace, which then takes precedence over
+// OverwriteNever.
+func checkOutput(inputFile, output string, opts Options) error {
+	if sameFile(inputFile, output) {
+		if !opts.InPlace {
+			return fmt.Errorf("%w: %s", ErrInPlace, output)
+		}
+		return nil
+	}
+	if opts.Overwrite == OverwriteNever {
+		if _, err := os.Lstat(output); err == nil {
+			return fmt.Errorf("%w: %s", ErrOutputExists, output)
+		}
+	}
+	return nil
+}
+
+// writeOutput writes ctx to output atomically: the PDF goes to a temporary
+// file in the same directory, which is renamed over output only once it is
+// complete, so an interrupted run never leaves a truncated file behind.
+func writeOutput(ctx *model.Context, inputFile, output string, opts Options) (err error) {
+	m := opts.metrics()
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			m.Failure(FailureWrite)
+			return
+		}
+		m.ObserveStage(StageWrite, time.Since(start))
+	}()
+
+	if err := checkOutput(inputFile, output, opts); err != nil {
+		return err
+	}
+	dir := filepath.Dir(output)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	existing, statErr := os.Stat(output)
+	if statErr == nil {
+		mode = existing.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err = applyEncryption(ctx, opts); err != nil {
+		return err
+	}
+	cw := &countingWriter{w: tmp}
+	if err = api.WriteContext(ctx, cw); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	noClobber := opts.Overwrite == OverwriteNever && !sameFile(inputFile, output)
+	backup := opts.Overwrite == OverwriteBackup && statErr == nil
+	if err = installOutput(tmp.Name(), output, noClobber, backup); err != nil {
+		return err
+	}
+	m.BytesWritten(cw.n)
+	return nil
+}
+
+// installOutput moves the finished temporary file tmp to output. With
+// noClobber it links tmp to output instead, which unlike a rename fails when
+// output exists, so a file created after checkOutput is never replaced. With
+// backup the existing output is first moved to its backup name, and moved
+// back if output cannot be installed.
+func installOutput(tmp, output string, noClobber, backup bool) error {
+	if noClobber {
+		err := linkFile(tmp, output)
+		if linkUnsupported(err) {
+			err = copyExclusive(tmp, output)
+		}
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("%w: %s", ErrOutputExists, output)
+			}
+			return err
+		}
+		// The output is in place; a leftover temporary name is harmless.
+		os.Remove(tmp)
+		return nil
+	}
+	if backup {
+		if err := os.Rename(output, backupFile(output)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		if backup {
+			if restoreErr := os.Rename(backupFile(output), output); restoreErr != nil {
+				return errors.Join(err, restoreErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// linkFile is os.Link, replaced in tests to act like a file system without
+// hard links.
+var linkFile = os.Link
+
+// linkUnsupported reports whether err is how a file system without hard
+// links, such as FAT, exFAT and many network and FUSE mounts, refuses one.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// copyExclusive copies tmp to output, creating output only if it does not
+// exist yet. It stands in for the link under no-clobber where hard links
+// are unavailable; a partly written output is removed.
+func copyExclusive(tmp, output string) (err error) {
+	in, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(output)
+		}
+	}()
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// sameFile reports whether a and b name the same file, either because the
+// paths match or because both exist and are the same file on disk.
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,207 @@
+package crop
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func TestValidOverwrite(t *testing.T) {
+	for _, policy := range []string{"", OverwriteReplace, OverwriteNever, OverwriteBackup} {
+		if !ValidOverwrite(policy) {
+			t.Errorf("ValidOverwrite(%q) = false", policy)
+		}
+	}
+	if ValidOverwrite("clobber") {
+		t.Errorf("ValidOverwrite(%q) = true", "clobber")
+	}
+}
+
+// writeFixturePDF creates a one-page PDF in dir and returns its path.
+func writeFixturePDF(t *testing.T, dir string) string {
+	t.Helper()
+	pngPath := filepath.Join(dir, "w.png")
+	pdfPath := filepath.Join(dir, "w.pdf")
+	writePNG(t, pngPath, makeTestImage(300, 300))
+	createPDFViaImport(t, pngPath, pdfPath)
+	return pdfPath
+}
+
+// assertNoTempFiles fails if writeOutput left temporary files in dir.
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("temporary file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestCropDocument_OverwritePolicies(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	outPath := filepath.Join(tdir, "out.pdf")
+	old := []byte("previous output")
+
+	// no-clobber leaves the existing file alone.
+	if err := os.WriteFile(outPath, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.Overwrite = OverwriteNever
+	if err := CropDocument(pdfPath, outPath, opts); !errors.Is(err, ErrOutputExists) {
+		t.Fatalf("no-clobber: expected ErrOutputExists, got %v", err)
+	}
+	if got, _ := os.ReadFile(outPath); !bytes.Equal(got, old) {
+		t.Fatalf("no-clobber modified the existing output")
+	}
+
+	// backup keeps the previous file next to the new one.
+	opts.Overwrite = OverwriteBackup
+	if err := CropDocument(pdfPath, outPath, opts); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if got, _ := os.ReadFile(backupFile(outPath)); !bytes.Equal(got, old) {
+		t.Fatalf("backup does not hold the previous output")
+	}
+	if _, err := api.ReadContextFile(outPath); err != nil {
+		t.Fatalf("backup: new output unreadable: %v", err)
+	}
+	info, err := os.Stat(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode of the replaced file to be kept, got %v", info.Mode().Perm())
+	}
+
+	// overwrite replaces without a backup.
+	os.Remove(backupFile(outPath))
+	opts.Overwrite = OverwriteReplace
+	if err := CropDocument(pdfPath, outPath, opts); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if _, err := os.Stat(backupFile(outPath)); !os.IsNotExist(err) {
+		t.Fatalf("overwrite should not create a backup")
+	}
+	assertNoTempFiles(t, tdir)
+}
+
+func TestCropDocument_InPlace(t *testing.T) {
+	tdir := t.TempDir()
+	pdfPath := writeFixturePDF(t, tdir)
+	before, err := os.ReadFile(pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	if err := CropDocument(pdfPath, pdfPath, opts); !errors.Is(err, ErrInPlace) {
+		t.Fatalf("expected ErrInPlace, got %v", err)
+	}
+	if got, _ := os.ReadFile(pdfPath); !bytes.Equal(got, before) {
+		t.Fatalf("input modified without InPlace")
+	}
+
+	opts.InPlace = true
+	opts.Overwrite = OverwriteNever
+	if err := CropDocument(pdfPath, filepath.Join(tdir, ".", "w.pdf"), opts); err != nil {
+		t.Fatalf("in-place: %v", err)
+	}
+	ctx, err := api.ReadContextFile(pdfPath)
+	if err != nil {
+		t.Fatalf("read cropped input: %v", err)
+	}
+	boxes, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boxes[0].Crop == nil || boxes[0].Crop.Rect == nil {
+		t.Fatalf("expected CropBox on the input after in-place crop")
+	}
+	assertNoTempFiles(t, tdir)
+}
+
+func TestInstallOutput(t *testing.T) {
+	tdir := t.TempDir()
+	outPath := filepath.Join(tdir, "out.pdf")
+	tmpPath := filepath.Join(tdir, ".out.pdf.tmp")
+	old := []byte("previous output")
+	assertOutput := func(want []byte) {
+		t.Helper()
+		got, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("output = %q, want %q", got, want)
+		}
+	}
+
+	// A file that appeared after checkOutput is not replaced under
+	// no-clobber.
+	if err := os.WriteFile(outPath, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tmpPath, []byte("new output"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := installOutput(tmpPath, outPath, true, false); !errors.Is(err, ErrOutputExists) {
+		t.Fatalf("no-clobber: err = %v, want ErrOutputExists", err)
+	}
+	assertOutput(old)
+
+	// The backup is moved back when the new output cannot be installed.
+	os.Remove(tmpPath)
+	if err := installOutput(tmpPath, outPath, false, true); err == nil {
+		t.Fatal("expected error for a missing temporary file")
+	}
+	assertOutput(old)
+	if _, err := os.Stat(backupFile(outPath)); !os.IsNotExist(err) {
+		t.Errorf("backup left behind: %v", err)
+	}
+}
+
+func TestInstallOutput_NoHardLinks(t *testing.T) {
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	t.Cleanup(func() { linkFile = os.Link })
+
+	tdir := t.TempDir()
+	outPath := filepath.Join(tdir, "out.pdf")
+	tmpPath := filepath.Join(tdir, ".out.pdf.tmp")
+	if err := os.WriteFile(tmpPath, []byte("new output"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := installOutput(tmpPath, outPath, true, false); err != nil {
+		t.Fatalf("no-clobber without hard links: %v", err)
+	}
+	if got, err := os.ReadFile(outPath); err != nil || string(got) != "new output" {
+		t.Fatalf("output = %q, %v", got, err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// The copy still refuses to replace an existing output.
+	if err := os.WriteFile(tmpPath, []byte("newer output"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := installOutput(tmpPath, outPath, true, false); !errors.Is(err, ErrOutputExists) {
+		t.Fatalf("err = %v, want ErrOutputExists", err)
+	}
+	if got, _ := os.ReadFile(outPath); string(got) != "new output" {
+		t.Errorf("output replaced: %q", got)
+	}
+}